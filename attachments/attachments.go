@@ -0,0 +1,135 @@
+package attachments
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+)
+
+var (
+	ErrTooLarge = errors.New("the file is too large")
+	ErrType     = errors.New("files of this type cannot be attached")
+)
+
+// types are the content types that can be attached. The type is sniffed
+// from the content; whatever the client claims is ignored.
+var types = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"application/zip": true,
+	"text/plain":      true,
+}
+
+const (
+	// thumbnailSize is the longest side of thumbnails in pixels.
+	thumbnailSize = 256
+	// maxPixels keeps huge images from being decoded for a thumbnail.
+	maxPixels = 40_000_000
+)
+
+// Upload is a checked and stored file. ThumbnailKey is empty for files that
+// have no thumbnail.
+type Upload struct {
+	ContentType  string
+	Size         int64
+	Key          string
+	ThumbnailKey string
+}
+
+// IsImage tells whether browsers can show files of contentType inline.
+func IsImage(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Save checks the file read from r, which may have at most maxSize bytes,
+// and puts it into storage together with a thumbnail if it is an image.
+func Save(storage Storage, r io.Reader, maxSize int64) (Upload, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return Upload{}, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return Upload{}, ErrTooLarge
+	}
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil || !types[contentType] {
+		return Upload{}, ErrType
+	}
+
+	upload := Upload{ContentType: contentType, Size: int64(len(data))}
+	if upload.Key, err = newKey(); err != nil {
+		return Upload{}, err
+	}
+	if err := storage.Put(upload.Key, bytes.NewReader(data)); err != nil {
+		return Upload{}, err
+	}
+
+	thumb, ok := thumbnail(data)
+	if !ok {
+		return upload, nil
+	}
+	thumbnailKey := upload.Key + "-thumbnail"
+	if err := storage.Put(thumbnailKey, bytes.NewReader(thumb)); err != nil {
+		storage.Delete(upload.Key)
+		return Upload{}, err
+	}
+	upload.ThumbnailKey = thumbnailKey
+	return upload, nil
+}
+
+// thumbnail returns a PNG of the image in data scaled down to fit
+// thumbnailSize, or false for images Go cannot decode, like WebP.
+func thumbnail(data []byte) ([]byte, bool) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width == 0 || config.Height == 0 || config.Width*config.Height > maxPixels {
+		return nil, false
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > thumbnailSize || h > thumbnailSize {
+		if w >= h {
+			tw, th = thumbnailSize, h*thumbnailSize/w
+		} else {
+			tw, th = w*thumbnailSize/h, thumbnailSize
+		}
+		if tw == 0 {
+			tw = 1
+		}
+		if th == 0 {
+			th = 1
+		}
+	}
+	// Nearest neighbour is good enough at this size.
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			thumb.Set(x, y, img.At(b.Min.X+x*w/tw, b.Min.Y+y*h/th))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, thumb); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
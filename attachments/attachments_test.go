@@ -0,0 +1,171 @@
+package attachments
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func encodePNG(t *testing.T, w int, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// hugePNG returns a valid black and white PNG of w×h pixels, which is small
+// as a file but takes w×h bytes once decoded.
+func hugePNG(t *testing.T, w int, h int) []byte {
+	var buf bytes.Buffer
+	chunk := func(typ string, data []byte) {
+		binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+		crc := crc32.NewIEEE()
+		io.WriteString(crc, typ)
+		crc.Write(data)
+		buf.WriteString(typ)
+		buf.Write(data)
+		binary.Write(&buf, binary.BigEndian, crc.Sum32())
+	}
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	header := make([]byte, 13)
+	binary.BigEndian.PutUint32(header[0:], uint32(w))
+	binary.BigEndian.PutUint32(header[4:], uint32(h))
+	header[8] = 1 // one bit per pixel, grey
+	chunk("IHDR", header)
+
+	var pixels bytes.Buffer
+	z := zlib.NewWriter(&pixels)
+	row := make([]byte, 1+(w+7)/8)
+	for y := 0; y < h; y++ {
+		z.Write(row)
+	}
+	z.Close()
+	chunk("IDAT", pixels.Bytes())
+	chunk("IEND", nil)
+	return buf.Bytes()
+}
+
+func newTestDisk(t *testing.T) (*Disk, string) {
+	dir := filepath.Join(t.TempDir(), "uploads")
+	disk, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return disk, dir
+}
+
+func TestSave(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		maxSize   int64
+		want      string
+		err       error
+		thumbnail bool
+	}{
+		{"PNG", encodePNG(t, 600, 300), 1 << 20, "image/png", nil, true},
+		{"text", []byte("just some notes"), 1 << 20, "text/plain", nil, false},
+		{"PDF", []byte("%PDF-1.4\n..."), 1 << 20, "application/pdf", nil, false},
+		{"HTML", []byte("<html><script>alert(1)</script></html>"), 1 << 20, "", ErrType, false},
+		{"SVG", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`), 1 << 20, "", ErrType, false},
+		{"executable", append([]byte("MZ"), make([]byte, 64)...), 1 << 20, "", ErrType, false},
+		{"too large", []byte("0123456789"), 9, "", ErrTooLarge, false},
+		{"just small enough", []byte("0123456789"), 10, "text/plain", nil, false},
+		// Images beyond maxPixels are kept, but never decoded for a
+		// thumbnail.
+		{"too many pixels", hugePNG(t, 8000, 5001), 1 << 20, "image/png", nil, false},
+	}
+	for _, tt := range tests {
+		disk, dir := newTestDisk(t)
+		upload, err := Save(disk, bytes.NewReader(tt.data), tt.maxSize)
+		if err != tt.err {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		files, _ := os.ReadDir(dir)
+		if err != nil {
+			if len(files) != 0 {
+				t.Errorf("%s: refused file was stored", tt.name)
+			}
+			continue
+		}
+		if upload.ContentType != tt.want || upload.Size != int64(len(tt.data)) {
+			t.Errorf("%s: upload %+v, want type %s", tt.name, upload, tt.want)
+		}
+		if (upload.ThumbnailKey != "") != tt.thumbnail {
+			t.Errorf("%s: thumbnail key %q", tt.name, upload.ThumbnailKey)
+		}
+		if !tt.thumbnail {
+			continue
+		}
+		f, err := disk.Open(upload.ThumbnailKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := png.DecodeConfig(f)
+		f.Close()
+		if err != nil || config.Width != thumbnailSize || config.Height != thumbnailSize/2 {
+			t.Errorf("%s: thumbnail %dx%d, %v", tt.name, config.Width, config.Height, err)
+		}
+	}
+}
+
+func TestDiskKeys(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "uploads")
+	disk, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(parent, "secret"), []byte("secret"), 0o600)
+
+	for _, key := range []string{"", ".", "..", "../secret", "../uploads/x", "a/b", "/etc/passwd", ".hidden"} {
+		if err := disk.Put(key, bytes.NewReader([]byte("x"))); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+		if f, err := disk.Open(key); err == nil {
+			f.Close()
+			t.Errorf("Open(%q) succeeded", key)
+		}
+		if err := disk.Delete(key); err == nil {
+			t.Errorf("Delete(%q) succeeded", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "secret")); err != nil {
+		t.Errorf("file outside the directory is gone: %v", err)
+	}
+
+	key, _ := newKey()
+	if err := disk.Put(key, bytes.NewReader([]byte("content"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := disk.Put(key, bytes.NewReader([]byte("other"))); err == nil {
+		t.Error("Put overwrote a file")
+	}
+	f, err := disk.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "content" {
+		t.Errorf("Open read %q", data)
+	}
+	if err := disk.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := disk.Delete(key); err != nil {
+		t.Errorf("Delete of a missing file: %v", err)
+	}
+}
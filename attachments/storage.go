@@ -0,0 +1,89 @@
+// Package attachments checks uploaded chat files and keeps them in a
+// Storage.
+package attachments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Storage keeps the content of attachments under keys of the package's
+// choosing. Disk is the only one for now; anything with the same methods,
+// such as an S3-compatible bucket, can take its place.
+type Storage interface {
+	Put(key string, r io.Reader) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// Disk is a Storage keeping every attachment in a file of one directory.
+type Disk struct {
+	dir string
+}
+
+// NewDisk returns a Disk storing files in dir, which is created if needed.
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create attachment directory: %w", err)
+	}
+	return &Disk{dir: dir}, nil
+}
+
+func (d *Disk) Put(key string, r io.Reader) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to create attachment file: %w", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to write attachment file: %w", err)
+	}
+	return f.Close()
+}
+
+func (d *Disk) Open(key string) (io.ReadCloser, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (d *Disk) Delete(key string) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// path returns the file of key. Keys are made by newKey, so anything that
+// could leave the directory is refused.
+func (d *Disk) path(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || key[0] == '.' {
+		return "", fmt.Errorf("invalid attachment key %q", key)
+	}
+	return filepath.Join(d.dir, key), nil
+}
+
+// newKey returns a random key for a new attachment.
+func newKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,117 @@
+package chat
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is how long a single write may take.
+	writeWait = 10 * time.Second
+	// pongWait is how long a connection may stay silent, pongs included,
+	// before it is considered dead.
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so that a healthy peer always
+	// answers in time.
+	pingPeriod = pongWait * 9 / 10
+	// maxFrameSize limits frames sent by clients.
+	maxFrameSize = 64 * 1024
+	// SendQueueSize is how many frames may wait for a slow connection before
+	// it is dropped.
+	SendQueueSize = 64
+)
+
+// Handler is called with every frame a client sends.
+type Handler func(c *Client, data []byte)
+
+// Client is one websocket connection of a user.
+type Client struct {
+	hub  *Hub
+	conn *websocket.Conn
+	user User
+	send chan []byte
+}
+
+func (c *Client) User() User {
+	return c.user
+}
+
+// Reply sends a frame to this connection only, typically answering the
+// client frame with the given id.
+func (c *Client) Reply(typ string, id string, payload interface{}) error {
+	data, err := Frame(typ, id, payload)
+	if err != nil {
+		return err
+	}
+	c.hub.broadcast <- delivery{client: c, data: data}
+	return nil
+}
+
+// Serve registers conn for user and reads frames from it until it closes,
+// passing each to handle. connected, unless nil, is called once the client
+// is registered and before the first frame is read. Writes happen on a
+// goroutine of their own, so both may send to the hub freely.
+func (h *Hub) Serve(conn *websocket.Conn, user User, connected func(c *Client), handle Handler) {
+	c := &Client{hub: h, conn: conn, user: user, send: make(chan []byte, SendQueueSize)}
+	go c.writePump()
+	h.register <- c
+	if connected != nil {
+		connected(c)
+	}
+	c.readPump(handle)
+}
+
+func (c *Client) readPump(handle Handler) {
+	defer func() {
+		c.hub.unregister <- c
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxFrameSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Chat connection of %s: %v", c.user.Username, err)
+			}
+			return
+		}
+		handle(c, data)
+	}
+}
+
+// writePump is the only goroutine writing to the connection. It stops when
+// the hub closes the send queue.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package chat
+
+import "time"
+
+// hiding is a block between two users on its way to Run.
+type hiding struct {
+	a, b   string
+	hidden bool
+}
+
+// SetHidden tells the hub that users a and b blocked one another, or that
+// neither blocks the other any more. Users who are online then see the other
+// go offline, or come back online.
+func (h *Hub) SetHidden(a string, b string, hidden bool) {
+	h.hides <- hiding{a, b, hidden}
+}
+
+func (h *Hub) handleHiding(e hiding) {
+	aOnline, bOnline := len(h.clients[e.a]) > 0, len(h.clients[e.b]) > 0
+	if !aOnline && !bOnline {
+		// Whoever connects next brings the blocks along.
+		return
+	}
+	if h.hidden[e.a][e.b] == e.hidden {
+		return
+	}
+	h.hide(e.a, e.b, e.hidden)
+	if aOnline && bOnline {
+		h.deliver(delivery{usernames: []string{e.b}, data: presenceFrame(h.user(e.a), !e.hidden, time.Time{})})
+		h.deliver(delivery{usernames: []string{e.a}, data: presenceFrame(h.user(e.b), !e.hidden, time.Time{})})
+	}
+}
+
+// hide records whether a and b are hidden from each other.
+func (h *Hub) hide(a string, b string, hidden bool) {
+	if !hidden {
+		delete(h.hidden[a], b)
+		delete(h.hidden[b], a)
+		return
+	}
+	for _, pair := range [][2]string{{a, b}, {b, a}} {
+		if h.hidden[pair[0]] == nil {
+			h.hidden[pair[0]] = make(map[string]bool)
+		}
+		h.hidden[pair[0]][pair[1]] = true
+	}
+}
+
+// forgetHidden drops the pairs of username once they went offline; their
+// next connection brings them along again.
+func (h *Hub) forgetHidden(username string) {
+	for other := range h.hidden[username] {
+		delete(h.hidden[other], username)
+		if len(h.hidden[other]) == 0 {
+			delete(h.hidden, other)
+		}
+	}
+	delete(h.hidden, username)
+}
+
+// user returns who the connections of username, who must be online, belong
+// to.
+func (h *Hub) user(username string) User {
+	for c := range h.clients[username] {
+		return c.user
+	}
+	return User{Username: username}
+}
@@ -0,0 +1,105 @@
+package chat
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// connect registers a connection of user with a running hub, without a
+// websocket behind it.
+func connect(h *Hub, user User) *Client {
+	c := &Client{hub: h, user: user, send: make(chan []byte, SendQueueSize)}
+	h.register <- c
+	return c
+}
+
+// presenceOf waits briefly for the presence frames queued for c and returns
+// them by username; snapshots count as online frames.
+func presenceOf(t *testing.T, c *Client) map[string][]bool {
+	seen := make(map[string][]bool)
+	for {
+		select {
+		case data := <-c.send:
+			var env Envelope
+			if err := json.Unmarshal(data, &env); err != nil {
+				t.Fatal(err)
+			}
+			switch env.Type {
+			case TypePresence:
+				var p PresencePayload
+				json.Unmarshal(env.Payload, &p)
+				seen[p.Username] = append(seen[p.Username], p.Online)
+			case TypePresenceSnapshot:
+				var s PresenceSnapshotPayload
+				json.Unmarshal(env.Payload, &s)
+				for _, p := range s.Users {
+					seen[p.Username] = append(seen[p.Username], true)
+				}
+			}
+		case <-time.After(50 * time.Millisecond):
+			return seen
+		}
+	}
+}
+
+func TestHiddenPresence(t *testing.T) {
+	h := NewHub(nil)
+	go h.Run()
+
+	bob := connect(h, User{ID: 2, Username: "bob"})
+	carol := connect(h, User{ID: 3, Username: "carol"})
+	presenceOf(t, bob)
+	presenceOf(t, carol)
+
+	// alice blocked bob, or bob blocked alice: neither sees the other.
+	alice := connect(h, User{ID: 1, Username: "alice", Hidden: []string{"bob"}})
+	if seen := presenceOf(t, alice); len(seen["bob"]) != 0 || len(seen["carol"]) != 1 {
+		t.Errorf("alice saw %v, want carol only", seen)
+	}
+	if seen := presenceOf(t, bob); len(seen) != 0 {
+		t.Errorf("bob saw %v", seen)
+	}
+	if seen := presenceOf(t, carol); len(seen["alice"]) != 1 || !seen["alice"][0] {
+		t.Errorf("carol saw %v", seen)
+	}
+
+	h.SetHidden("bob", "alice", false)
+	if seen := presenceOf(t, alice); len(seen["bob"]) != 1 || !seen["bob"][0] {
+		t.Errorf("unblocked: alice saw %v", seen)
+	}
+	if seen := presenceOf(t, bob); len(seen["alice"]) != 1 || !seen["alice"][0] {
+		t.Errorf("unblocked: bob saw %v", seen)
+	}
+	if seen := presenceOf(t, carol); len(seen) != 0 {
+		t.Errorf("unblocked: carol saw %v", seen)
+	}
+
+	h.SetHidden("alice", "bob", true)
+	if seen := presenceOf(t, bob); len(seen["alice"]) != 1 || seen["alice"][0] {
+		t.Errorf("blocked: bob saw %v, want alice offline", seen)
+	}
+	if seen := presenceOf(t, alice); len(seen["bob"]) != 1 || seen["bob"][0] {
+		t.Errorf("blocked: alice saw %v, want bob offline", seen)
+	}
+	// Blocking twice changes nothing.
+	h.SetHidden("bob", "alice", true)
+	if seen := presenceOf(t, alice); len(seen) != 0 {
+		t.Errorf("blocked again: alice saw %v", seen)
+	}
+
+	h.unregister <- alice
+	if seen := presenceOf(t, bob); len(seen) != 0 {
+		t.Errorf("alice left: bob saw %v", seen)
+	}
+	if seen := presenceOf(t, carol); len(seen["alice"]) != 1 || seen["alice"][0] {
+		t.Errorf("alice left: carol saw %v", seen)
+	}
+
+	// Blocks of users who went offline are forgotten; their next connection
+	// brings them along.
+	connect(h, User{ID: 1, Username: "alice"})
+	if seen := presenceOf(t, bob); len(seen["alice"]) != 1 || !seen["alice"][0] {
+		t.Errorf("alice came back unblocked: bob saw %v", seen)
+	}
+}
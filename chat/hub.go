@@ -0,0 +1,223 @@
+// Package chat keeps track of the websocket connections of logged in users
+// and delivers frames to them.
+package chat
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// User is who a connection belongs to.
+type User struct {
+	ID       int
+	Username string
+	// Hidden are the users this one blocked or was blocked by, as they were
+	// when the connection opened. Neither side sees the other come online or
+	// go offline.
+	Hidden []string
+}
+
+// delivery is a frame for a single connection, for the connections of some
+// users, or for everyone when both client and usernames are nil. Users hidden
+// from hiddenFrom are left out.
+type delivery struct {
+	client     *Client
+	usernames  []string
+	data       []byte
+	hiddenFrom string
+}
+
+// Hub owns the set of connections. Only Run touches it; everything else talks
+// to Run through the channels, so no locking is needed.
+type Hub struct {
+	register   chan *Client
+	unregister chan *Client
+	broadcast  chan delivery
+	typing     chan typingEvent
+	hides      chan hiding
+	clients    map[string]map[*Client]bool
+	typists    map[typingPair]*typingState
+	// hidden holds both ways the pairs of users who do not see each other,
+	// for the users who are online.
+	hidden   map[string]map[string]bool
+	presence *Presence
+}
+
+// NewHub returns a hub that reports users coming and going to presence,
+// which may be nil.
+func NewHub(presence *Presence) *Hub {
+	return &Hub{
+		presence:   presence,
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		broadcast:  make(chan delivery),
+		typing:     make(chan typingEvent),
+		hides:      make(chan hiding),
+		clients:    make(map[string]map[*Client]bool),
+		typists:    make(map[typingPair]*typingState),
+		hidden:     make(map[string]map[string]bool),
+	}
+}
+
+// Run serves the hub and never returns; start it in its own goroutine.
+func (h *Hub) Run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case c := <-h.register:
+			first := len(h.clients[c.user.Username]) == 0
+			if first {
+				h.clients[c.user.Username] = make(map[*Client]bool)
+			}
+			h.clients[c.user.Username][c] = true
+			for _, username := range c.user.Hidden {
+				h.hide(c.user.Username, username, true)
+			}
+			if first {
+				h.presence.changed(c.user, true)
+				h.deliver(delivery{data: presenceFrame(c.user, true, time.Time{}), hiddenFrom: c.user.Username})
+			}
+			// Tell the newcomer who is online already, in a single frame
+			// however many they are.
+			h.deliver(delivery{client: c, data: h.snapshotFrame(c.user)})
+
+		case c := <-h.unregister:
+			h.remove(c)
+
+		case d := <-h.broadcast:
+			h.deliver(d)
+
+		case e := <-h.typing:
+			h.handleTyping(e, time.Now())
+
+		case e := <-h.hides:
+			h.handleHiding(e)
+
+		case now := <-ticker.C:
+			h.expireTyping(now)
+		}
+	}
+}
+
+func (h *Hub) deliver(d delivery) {
+	if d.client != nil {
+		// The client may have been dropped while the frame was on its way.
+		if h.clients[d.client.user.Username][d.client] {
+			h.queue(d.client, d.data)
+		}
+		return
+	}
+	if d.usernames == nil {
+		for username, conns := range h.clients {
+			if h.hidden[d.hiddenFrom][username] {
+				continue
+			}
+			for c := range conns {
+				h.queue(c, d.data)
+			}
+		}
+		return
+	}
+	for _, username := range d.usernames {
+		if h.hidden[d.hiddenFrom][username] {
+			continue
+		}
+		for c := range h.clients[username] {
+			h.queue(c, d.data)
+		}
+	}
+}
+
+// queue hands data to the writer of c. A client whose queue is full cannot
+// keep up and is dropped, so that it does not hold up everyone else.
+func (h *Hub) queue(c *Client, data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		log.Printf("Dropping slow chat connection of %s", c.user.Username)
+		h.remove(c)
+	}
+}
+
+// remove forgets c and stops its writer. Users whose last connection is gone
+// are announced as offline. Connections are removed when they fail to answer
+// pings in time as well as when they close, so users who just vanish go
+// offline too.
+func (h *Hub) remove(c *Client) {
+	conns, ok := h.clients[c.user.Username]
+	if !ok || !conns[c] {
+		return
+	}
+	delete(conns, c)
+	close(c.send)
+	if len(conns) == 0 {
+		delete(h.clients, c.user.Username)
+		h.stopTyping(c.user.Username)
+		h.presence.changed(c.user, false)
+		h.deliver(delivery{data: presenceFrame(c.user, false, time.Now()), hiddenFrom: c.user.Username})
+		h.forgetHidden(c.user.Username)
+	}
+}
+
+// SendTo sends a frame to every connection of the given users.
+func (h *Hub) SendTo(typ string, payload interface{}, usernames ...string) error {
+	data, err := Frame(typ, "", payload)
+	if err != nil {
+		return err
+	}
+	if usernames == nil {
+		usernames = []string{}
+	}
+	h.broadcast <- delivery{usernames: usernames, data: data}
+	return nil
+}
+
+// Broadcast sends a frame to every connection.
+func (h *Hub) Broadcast(typ string, payload interface{}) error {
+	data, err := Frame(typ, "", payload)
+	if err != nil {
+		return err
+	}
+	h.broadcast <- delivery{data: data}
+	return nil
+}
+
+// snapshotFrame lists the users online besides user and those hidden from
+// them, by username.
+func (h *Hub) snapshotFrame(user User) []byte {
+	payload := PresenceSnapshotPayload{Users: []PresencePayload{}}
+	for username, conns := range h.clients {
+		if h.hidden[user.Username][username] {
+			continue
+		}
+		for c := range conns {
+			if c.user.Username != user.Username {
+				payload.Users = append(payload.Users, PresencePayload{Username: c.user.Username, UserID: c.user.ID, Online: true})
+			}
+			break
+		}
+	}
+	sort.Slice(payload.Users, func(i, j int) bool {
+		return payload.Users[i].Username < payload.Users[j].Username
+	})
+	data, err := Frame(TypePresenceSnapshot, "", payload)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func presenceFrame(user User, online bool, lastSeen time.Time) []byte {
+	payload := PresencePayload{Username: user.Username, UserID: user.ID, Online: online}
+	if !lastSeen.IsZero() {
+		payload.LastSeen = lastSeen.UTC().Format(time.RFC3339)
+	}
+	data, err := Frame(TypePresence, "", payload)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,97 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startHub serves a hub over websockets, with the username in the query.
+// connected receives every client once it is registered.
+func startHub(t *testing.T) (*httptest.Server, chan *Client) {
+	return startHubWith(t, nil)
+}
+
+// startHubWith is startHub with a hub reporting to presence.
+func startHubWith(t *testing.T, presence *Presence) (*httptest.Server, chan *Client) {
+	hub := NewHub(presence)
+	go hub.Run()
+	connected := make(chan *Client, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		username := r.URL.Query().Get("user")
+		var id int
+		fmt.Sscan(strings.TrimPrefix(username, "user"), &id)
+		hub.Serve(conn, User{ID: id, Username: username}, func(c *Client) { connected <- c }, func(*Client, []byte) {})
+	}))
+	t.Cleanup(server.Close)
+	return server, connected
+}
+
+func dial(t *testing.T, server *httptest.Server, username string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?user="+username, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", username, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestPresenceSnapshot connects more users than a send queue holds and
+// checks that the next one learns about all of them.
+func TestPresenceSnapshot(t *testing.T) {
+	server, connected := startHub(t)
+	const online = SendQueueSize + 36
+	for i := 1; i <= online; i++ {
+		conn := dial(t, server, fmt.Sprintf("user%d", i))
+		<-connected
+		// Keep reading so that presence frames of later users do not pile up.
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	conn := dial(t, server, "newcomer")
+	<-connected
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("no presence_snapshot frame: %v", err)
+		}
+		var env Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			t.Fatal(err)
+		}
+		if env.Type != TypePresenceSnapshot {
+			continue
+		}
+		var snapshot PresenceSnapshotPayload
+		if err := json.Unmarshal(env.Payload, &snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshot.Users) != online {
+			t.Fatalf("snapshot lists %d users, want %d", len(snapshot.Users), online)
+		}
+		for _, user := range snapshot.Users {
+			if user.Username == "newcomer" || !user.Online {
+				t.Errorf("unexpected entry %+v", user)
+			}
+		}
+		return
+	}
+}
@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	check := CheckOrigin([]string{"https://forum.example.com/", "http://localhost:8080"})
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://forum.example.com", true},
+		{"HTTPS://Forum.Example.com", true},
+		{"http://localhost:8080", true},
+		// Requests without Origin do not come from a browser.
+		{"", true},
+		{"http://forum.example.com", false},
+		{"https://forum.example.com:8443", false},
+		{"https://evil.example.com", false},
+		{"https://forum.example.com.evil.com", false},
+		{"http://localhost:8081", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := check(r); got != tt.want {
+			t.Errorf("origin %q: got %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	if !CheckOrigin(nil)(httptest.NewRequest("GET", "/ws", nil)) {
+		t.Error("no allowed origins: request without Origin refused")
+	}
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set("Origin", "https://forum.example.com")
+	if CheckOrigin(nil)(r) {
+		t.Error("no allowed origins: browser request accepted")
+	}
+}
@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// lastSeenInterval is how often the last seen time of online users is
+// refreshed, so that a crash loses at most this much of it.
+const lastSeenInterval = time.Minute
+
+// LastSeenStore keeps when users were last connected.
+type LastSeenStore interface {
+	SetLastSeen(userID int, at time.Time) error
+}
+
+type presenceChange struct {
+	user   User
+	online bool
+}
+
+// Presence records when users were last seen. The hub tells it about users
+// coming online and going offline; it writes to the store on a goroutine of
+// its own, so that a slow database never holds up the hub. Changes are
+// handed over without waiting: while a write is slow, the changes of a user
+// pile up as just the latest one.
+type Presence struct {
+	store LastSeenStore
+	// mu guards pending, the latest change of each user not written yet.
+	mu      sync.Mutex
+	pending map[int]presenceChange
+	// wake tells Run that there are pending changes.
+	wake   chan struct{}
+	online map[int]User
+}
+
+func NewPresence(store LastSeenStore) *Presence {
+	return &Presence{
+		store:   store,
+		pending: make(map[int]presenceChange),
+		wake:    make(chan struct{}, 1),
+		online:  make(map[int]User),
+	}
+}
+
+// Run serves the presence service and never returns; start it in its own
+// goroutine.
+func (p *Presence) Run() {
+	ticker := time.NewTicker(lastSeenInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.wake:
+			p.mu.Lock()
+			changes := p.pending
+			p.pending = make(map[int]presenceChange)
+			p.mu.Unlock()
+
+			now := time.Now()
+			for _, change := range changes {
+				if change.online {
+					p.online[change.user.ID] = change.user
+				} else {
+					delete(p.online, change.user.ID)
+				}
+				p.seen(change.user, now)
+			}
+
+		case now := <-ticker.C:
+			for _, user := range p.online {
+				p.seen(user, now)
+			}
+		}
+	}
+}
+
+func (p *Presence) seen(user User, at time.Time) {
+	if err := p.store.SetLastSeen(user.ID, at); err != nil {
+		log.Printf("Record last seen of %s: %v", user.Username, err)
+	}
+}
+
+// changed is called by the hub exactly once for every transition. It never
+// blocks.
+func (p *Presence) changed(user User, online bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.pending[user.ID] = presenceChange{user, online}
+	p.mu.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
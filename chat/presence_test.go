@@ -0,0 +1,147 @@
+package chat
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lastSeenLog is a LastSeenStore that remembers every write. Writes wait
+// for release when it is set.
+type lastSeenLog struct {
+	release chan struct{}
+
+	mu   sync.Mutex
+	seen map[int][]time.Time
+}
+
+func newLastSeenLog() *lastSeenLog {
+	return &lastSeenLog{seen: make(map[int][]time.Time)}
+}
+
+func (l *lastSeenLog) SetLastSeen(userID int, at time.Time) error {
+	if l.release != nil {
+		<-l.release
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seen[userID] = append(l.seen[userID], at)
+	return nil
+}
+
+func (l *lastSeenLog) writes(userID int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.seen[userID])
+}
+
+// waitForWrite waits until last seen of userID was written at least once.
+func (l *lastSeenLog) waitForWrite(t *testing.T, userID int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for l.writes(userID) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("last seen of user %d was not recorded", userID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestPresenceDoesNotBlock checks that the hub can report changes while
+// the store hangs, and that they are written once it recovers.
+func TestPresenceDoesNotBlock(t *testing.T) {
+	store := newLastSeenLog()
+	store.release = make(chan struct{})
+	p := NewPresence(store)
+	go p.Run()
+
+	const users, changes = 10, 1000
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < changes; i++ {
+			id := i%users + 1
+			p.changed(User{ID: id, Username: "user"}, i/users%2 == 0)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("changed blocked while the store hung")
+	}
+
+	close(store.release)
+	for id := 1; id <= users; id++ {
+		store.waitForWrite(t, id)
+	}
+	time.Sleep(50 * time.Millisecond)
+	for id := 1; id <= users; id++ {
+		// One batch may have been taken before the store hung, the rest
+		// piled up as one change per user.
+		if writes := store.writes(id); writes > 2 {
+			t.Errorf("user %d: %d writes for %d changes", id, writes, changes/users)
+		}
+	}
+}
+
+// TestPresenceFrames checks that a user with several connections is
+// announced online and offline once each, and that last seen is recorded.
+func TestPresenceFrames(t *testing.T) {
+	store := newLastSeenLog()
+	p := NewPresence(store)
+	go p.Run()
+	server, connected := startHubWith(t, p)
+
+	watcher := dial(t, server, "user2")
+	<-connected
+	frames := make(chan PresencePayload, 16)
+	go func() {
+		for {
+			_, data, err := watcher.ReadMessage()
+			if err != nil {
+				return
+			}
+			var env Envelope
+			var payload PresencePayload
+			if json.Unmarshal(data, &env) == nil && env.Type == TypePresence && json.Unmarshal(env.Payload, &payload) == nil && payload.Username == "user1" {
+				frames <- payload
+			}
+		}
+	}()
+
+	first := dial(t, server, "user1")
+	<-connected
+	second := dial(t, server, "user1")
+	<-connected
+	store.waitForWrite(t, 1)
+	first.Close()
+	second.Close()
+
+	var got []PresencePayload
+	timeout := time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case payload := <-frames:
+			got = append(got, payload)
+		case <-timeout:
+			t.Fatalf("presence frames %+v", got)
+		}
+	}
+	// Give duplicates time to show up.
+	select {
+	case payload := <-frames:
+		got = append(got, payload)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if len(got) != 2 || !got[0].Online || got[1].Online || got[1].LastSeen == "" {
+		t.Fatalf("presence frames %+v, want user1 online then offline", got)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for store.writes(1) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("going offline was not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
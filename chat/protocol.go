@@ -0,0 +1,252 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"forum/store"
+)
+
+// Version is the protocol version this server speaks. Frames of any other
+// version are refused with an error frame.
+const Version = 1
+
+// Every frame, in both directions, is an Envelope. Frames sent by clients
+// should carry an id of the client's choosing; the ack or error answering
+// the frame carries the same id, so the client can tell which of its
+// frames went through.
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Version int             `json:"version"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Frame types. Clients send send, history, typing, read, edit and delete
+// frames; the server answers history frames with history frames and sends
+// the others. It pushes edit and delete frames as message frames of the
+// changed message.
+// Conversation frames carry a store.Conversation to users who were added to
+// a group, so that it shows up without reloading the page. Reply frames tell
+// users someone replied to one of their comments. A presence_snapshot frame
+// lists who is online when a connection opens.
+const (
+	TypeSend             = "send"
+	TypeAck              = "ack"
+	TypeMessage          = "message"
+	TypeHistory          = "history"
+	TypeTyping           = "typing"
+	TypeRead             = "read"
+	TypeDelivered        = "delivered"
+	TypeEdit             = "edit"
+	TypeDelete           = "delete"
+	TypePresence         = "presence"
+	TypePresenceSnapshot = "presence_snapshot"
+	TypeConversation     = "conversation"
+	TypeReply            = "reply"
+	TypeError            = "error"
+)
+
+// SendPayload asks the server to store a message for another user, or for
+// the members of a group or channel when Conversation is set. Attachment is
+// the ID of a file the sender uploaded; the content may be empty then.
+type SendPayload struct {
+	To           string `json:"to,omitempty"`
+	Conversation int    `json:"conversation,omitempty"`
+	Content      string `json:"content"`
+	Attachment   int    `json:"attachment,omitempty"`
+}
+
+// AckPayload confirms that a send frame was stored. MessageID is assigned by
+// the server and identifies the message in every later frame.
+type AckPayload struct {
+	MessageID int `json:"messageId"`
+}
+
+// MessagePayload carries a newly stored message to both of its users, or to
+// every member of its group or channel. Replayed is set when a private
+// message that was not delivered yet is sent again as its receiver connects.
+type MessagePayload struct {
+	From         string               `json:"from"`
+	To           string               `json:"to,omitempty"`
+	Conversation int                  `json:"conversation,omitempty"`
+	Message      store.PrivateMessage `json:"message"`
+	Replayed     bool                 `json:"replayed,omitempty"`
+}
+
+// HistoryPayload asks for messages of the conversation with a user, or of
+// the group or channel Conversation: those older than the cursor Before,
+// newer than the cursor After, or the newest ones when neither is set.
+type HistoryPayload struct {
+	With         string `json:"with,omitempty"`
+	Conversation int    `json:"conversation,omitempty"`
+	Before       string `json:"before"`
+	After        string `json:"after"`
+	Limit        int    `json:"limit"`
+}
+
+// HistoryPagePayload answers a history frame with the same page GET
+// /get-message or /conversations/messages returns.
+type HistoryPagePayload struct {
+	With         string `json:"with,omitempty"`
+	Conversation int    `json:"conversation,omitempty"`
+	store.MessagePage
+}
+
+// TypingPayload tells the other side of a conversation that a user started
+// or stopped typing. Clients send it with the receiver in To; the server
+// relays it with the typist in From.
+type TypingPayload struct {
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Typing bool   `json:"typing"`
+}
+
+// ReadPayload is a read receipt. Clients send it with the user whose
+// messages they read up to MessageID, 0 meaning all of them; the server
+// sends it to that user with the reader in With. Reading a group or channel
+// sets Conversation instead of With and sends no receipts.
+type ReadPayload struct {
+	With         string `json:"with,omitempty"`
+	Conversation int    `json:"conversation,omitempty"`
+	MessageID    int    `json:"messageId"`
+}
+
+// DeliveredPayload is a delivery receipt. Clients send it for every private
+// message they receive; the server sends it to the sender with the receiver
+// in With.
+type DeliveredPayload struct {
+	With      string `json:"with,omitempty"`
+	MessageID int    `json:"messageId"`
+}
+
+// EditPayload asks the server to replace the content of one of the user's
+// private messages, or to delete it in a delete frame, which has no
+// content. Messages of groups and channels cannot be changed; frames that
+// name a Conversation are refused with an unsupported error.
+type EditPayload struct {
+	MessageID    int    `json:"messageId"`
+	Conversation int    `json:"conversation,omitempty"`
+	Content      string `json:"content,omitempty"`
+}
+
+// PresencePayload tells clients that a user came online or went offline.
+// Users going offline come with the time they were last seen.
+type PresencePayload struct {
+	Username string `json:"username"`
+	UserID   int    `json:"userId"`
+	Online   bool   `json:"online"`
+	LastSeen string `json:"lastSeen,omitempty"`
+}
+
+// PresenceSnapshotPayload lists the users online when a connection opens.
+type PresenceSnapshotPayload struct {
+	Users []PresencePayload `json:"users"`
+}
+
+// ReplyPayload carries a new comment to the author of the comment it
+// replies to.
+type ReplyPayload struct {
+	From     string        `json:"from"`
+	PostID   int           `json:"postId"`
+	ParentID int           `json:"parentId"`
+	Comment  store.Comment `json:"comment"`
+}
+
+// Error codes of error frames.
+const (
+	CodeBadFrame            = "bad_frame"
+	CodeUnsupportedVersion  = "unsupported_version"
+	CodeUnknownType         = "unknown_type"
+	CodeBadPayload          = "bad_payload"
+	CodeUnknownUser         = "unknown_user"
+	CodeUnknownConversation = "unknown_conversation"
+	CodeUnknownMessage      = "unknown_message"
+	CodeUnknownAttachment   = "unknown_attachment"
+	CodeBlocked             = "blocked"
+	CodePrivacy             = "privacy"
+	CodeForbidden           = "forbidden"
+	CodeUnsupported         = "unsupported"
+	CodeInternal            = "internal"
+)
+
+// Error is the payload of error frames. Frame handlers return it to refuse
+// a frame with a reason the client may show.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Frame encodes payload in an envelope of type typ.
+func Frame(typ string, id string, payload interface{}) ([]byte, error) {
+	env := Envelope{Type: typ, ID: id, Version: Version}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		env.Payload = data
+	}
+	return json.Marshal(env)
+}
+
+// FrameHandler handles one type of client frame.
+type FrameHandler func(c *Client, env Envelope) error
+
+// Router passes client frames to the handler of their type. Frames that
+// cannot be handled are answered with an error frame instead of being
+// dropped silently.
+type Router struct {
+	handlers map[string]FrameHandler
+}
+
+func NewRouter() *Router {
+	return &Router{handlers: make(map[string]FrameHandler)}
+}
+
+func (r *Router) Handle(typ string, handler FrameHandler) {
+	r.handlers[typ] = handler
+}
+
+// Dispatch is a Handler for Hub.Serve.
+func (r *Router) Dispatch(c *Client, data []byte) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil || env.Type == "" {
+		c.Reply(TypeError, "", &Error{CodeBadFrame, "frames must be JSON envelopes with a type"})
+		return
+	}
+	if env.Version != Version {
+		c.Reply(TypeError, env.ID, &Error{CodeUnsupportedVersion, fmt.Sprintf("this server speaks version %d", Version)})
+		return
+	}
+	handler, ok := r.handlers[env.Type]
+	if !ok {
+		c.Reply(TypeError, env.ID, &Error{CodeUnknownType, "unknown frame type " + env.Type})
+		return
+	}
+
+	err := handler(c, env)
+	if err == nil {
+		return
+	}
+	if e, ok := err.(*Error); ok {
+		c.Reply(TypeError, env.ID, e)
+		return
+	}
+	log.Printf("Chat %s frame of %s: %v", env.Type, c.user.Username, err)
+	c.Reply(TypeError, env.ID, &Error{CodeInternal, "the frame could not be handled"})
+}
+
+// DecodePayload unmarshals the payload of env into v, failing with a
+// bad_payload error.
+func DecodePayload(env Envelope, v interface{}) error {
+	if err := json.Unmarshal(env.Payload, v); err != nil {
+		return &Error{CodeBadPayload, "invalid " + env.Type + " payload"}
+	}
+	return nil
+}
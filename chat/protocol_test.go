@@ -0,0 +1,101 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	data, err := Frame(TypeAck, "42", AckPayload{MessageID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"type":"ack","id":"42","version":1,"payload":{"messageId":7}}`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+	data, _ = Frame(TypePresence, "", nil)
+	if want := `{"type":"presence","version":1}`; string(data) != want {
+		t.Errorf("without payload: got %s, want %s", data, want)
+	}
+}
+
+// replies returns a client whose replies are kept in the returned channel
+// instead of going through a running hub.
+func replies() (*Client, chan delivery) {
+	hub := &Hub{broadcast: make(chan delivery, 1)}
+	return &Client{hub: hub, user: User{ID: 1, Username: "alice"}}, hub.broadcast
+}
+
+func TestRouterDispatch(t *testing.T) {
+	router := NewRouter()
+	var handled []Envelope
+	router.Handle(TypeSend, func(c *Client, env Envelope) error {
+		handled = append(handled, env)
+		var payload SendPayload
+		if err := DecodePayload(env, &payload); err != nil {
+			return err
+		}
+		switch payload.Content {
+		case "blocked":
+			return &Error{CodeBlocked, "you cannot message this user"}
+		case "fail":
+			return errors.New("database is locked")
+		}
+		return c.Reply(TypeAck, env.ID, AckPayload{MessageID: 1})
+	})
+
+	tests := []struct {
+		name    string
+		frame   string
+		handled bool
+		reply   string
+		id      string
+		code    string
+	}{
+		{"handled", `{"type":"send","id":"a","version":1,"payload":{"to":"bob","content":"hi"}}`, true, TypeAck, "a", ""},
+		{"not JSON", `hello`, false, TypeError, "", CodeBadFrame},
+		{"no type", `{"id":"b","version":1}`, false, TypeError, "", CodeBadFrame},
+		{"no version", `{"type":"send","id":"c","payload":{}}`, false, TypeError, "c", CodeUnsupportedVersion},
+		{"other version", `{"type":"send","id":"d","version":2,"payload":{}}`, false, TypeError, "d", CodeUnsupportedVersion},
+		{"unknown type", `{"type":"shout","id":"e","version":1}`, false, TypeError, "e", CodeUnknownType},
+		{"bad payload", `{"type":"send","id":"f","version":1,"payload":{"content":3}}`, true, TypeError, "f", CodeBadPayload},
+		{"refused", `{"type":"send","id":"g","version":1,"payload":{"content":"blocked"}}`, true, TypeError, "g", CodeBlocked},
+		// Other errors are logged and not shown to the client.
+		{"failed", `{"type":"send","id":"h","version":1,"payload":{"content":"fail"}}`, true, TypeError, "h", CodeInternal},
+	}
+	for _, tt := range tests {
+		c, sent := replies()
+		handled = nil
+		router.Dispatch(c, []byte(tt.frame))
+		if (len(handled) == 1) != tt.handled {
+			t.Errorf("%s: handled %d times", tt.name, len(handled))
+		}
+
+		var d delivery
+		select {
+		case d = <-sent:
+		default:
+			t.Errorf("%s: no reply", tt.name)
+			continue
+		}
+		if d.client != c {
+			t.Errorf("%s: reply not addressed to the client", tt.name)
+		}
+		var env Envelope
+		if err := json.Unmarshal(d.data, &env); err != nil {
+			t.Fatal(err)
+		}
+		if env.Type != tt.reply || env.ID != tt.id || env.Version != Version {
+			t.Errorf("%s: reply %s", tt.name, d.data)
+		}
+		if tt.code != "" {
+			var e Error
+			json.Unmarshal(env.Payload, &e)
+			if e.Code != tt.code || e.Message == "" || strings.Contains(e.Message, "locked") {
+				t.Errorf("%s: error %+v, want code %s", tt.name, e, tt.code)
+			}
+		}
+	}
+}
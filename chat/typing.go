@@ -0,0 +1,94 @@
+package chat
+
+import "time"
+
+const (
+	// typingTimeout is how long a user counts as typing after their last
+	// typing frame. Clients repeat the frame while the user keeps typing.
+	typingTimeout = 5 * time.Second
+	// typingThrottle is the least time between two typing frames of a user
+	// to the same receiver; faster starts are ignored. Stops always count.
+	typingThrottle = 300 * time.Millisecond
+)
+
+// typingEvent is a typing frame on its way to Run.
+type typingEvent struct {
+	from   User
+	to     string
+	typing bool
+}
+
+type typingPair struct {
+	from string
+	to   string
+}
+
+type typingState struct {
+	seen    time.Time
+	typing  bool
+	expires time.Time
+}
+
+// Typing relays that from started or stopped typing to the user named to.
+// Only changes are relayed, and users who stay silent for typingTimeout are
+// announced as stopped. Nothing of this is stored.
+func (h *Hub) Typing(from User, to string, typing bool) {
+	h.typing <- typingEvent{from, to, typing}
+}
+
+func (h *Hub) handleTyping(e typingEvent, now time.Time) {
+	if e.to == e.from.Username {
+		return
+	}
+	key := typingPair{e.from.Username, e.to}
+	state, ok := h.typists[key]
+	if ok && e.typing && now.Sub(state.seen) < typingThrottle {
+		return
+	}
+	if !ok {
+		state = &typingState{}
+		h.typists[key] = state
+	}
+	// Stopped typists are kept until they expire too, so that quick
+	// stop/start cycles stay throttled.
+	state.seen = now
+	state.expires = now.Add(typingTimeout)
+	if state.typing != e.typing {
+		state.typing = e.typing
+		h.relayTyping(key, e.typing)
+	}
+}
+
+// expireTyping forgets the users who went silent, announcing them as
+// stopped if they were typing.
+func (h *Hub) expireTyping(now time.Time) {
+	for key, state := range h.typists {
+		if now.After(state.expires) {
+			delete(h.typists, key)
+			if state.typing {
+				h.relayTyping(key, false)
+			}
+		}
+	}
+}
+
+// stopTyping announces that user stopped typing to everyone, e.g. because
+// their last connection closed.
+func (h *Hub) stopTyping(username string) {
+	for key, state := range h.typists {
+		if key.from == username {
+			delete(h.typists, key)
+			if state.typing {
+				h.relayTyping(key, false)
+			}
+		}
+	}
+}
+
+func (h *Hub) relayTyping(key typingPair, typing bool) {
+	data, err := Frame(TypeTyping, "", TypingPayload{From: key.from, Typing: typing})
+	if err != nil {
+		panic(err)
+	}
+	h.deliver(delivery{usernames: []string{key.to}, data: data})
+}
@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// typingFrames returns the typing frames queued for c, oldest first.
+func typingFrames(t *testing.T, c *Client) []TypingPayload {
+	var frames []TypingPayload
+	for {
+		select {
+		case data := <-c.send:
+			var env Envelope
+			var payload TypingPayload
+			if err := json.Unmarshal(data, &env); err != nil || env.Type != TypeTyping {
+				t.Fatalf("unexpected frame %s", data)
+			}
+			json.Unmarshal(env.Payload, &payload)
+			frames = append(frames, payload)
+		default:
+			return frames
+		}
+	}
+}
+
+func TestTyping(t *testing.T) {
+	alice := User{ID: 1, Username: "alice"}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	type step struct {
+		at     time.Duration
+		typing bool
+		// expire runs expireTyping at the time instead of sending a frame.
+		expire bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		// want lists the typing states relayed to bob.
+		want []bool
+	}{
+		{"start and stop", []step{{0, true, false}, {time.Second, false, false}}, []bool{true, false}},
+		{"repeated starts", []step{{0, true, false}, {time.Second, true, false}, {2 * time.Second, true, false}}, []bool{true}},
+		{"stop without start", []step{{0, false, false}}, nil},
+		// Starting again within typingThrottle of a stop is ignored, stops
+		// are not throttled.
+		{"quick restart", []step{{0, true, false}, {100 * time.Millisecond, false, false}, {200 * time.Millisecond, true, false}}, []bool{true, false}},
+		{"restart after the throttle", []step{{0, true, false}, {100 * time.Millisecond, false, false}, {400 * time.Millisecond, true, false}}, []bool{true, false, true}},
+		{"silent typist", []step{{0, true, false}, {typingTimeout, false, true}, {typingTimeout + time.Millisecond, false, true}}, []bool{true, false}},
+		{"typing frames keep it alive", []step{{0, true, false}, {4 * time.Second, true, false}, {typingTimeout + time.Second, false, true}, {9*time.Second + time.Millisecond, false, true}}, []bool{true, false}},
+	}
+	for _, tt := range tests {
+		h := NewHub(nil)
+		bob := &Client{hub: h, user: User{ID: 2, Username: "bob"}, send: make(chan []byte, SendQueueSize)}
+		h.clients["bob"] = map[*Client]bool{bob: true}
+
+		var got []bool
+		for _, s := range tt.steps {
+			if s.expire {
+				h.expireTyping(at(s.at))
+			} else {
+				h.handleTyping(typingEvent{alice, "bob", s.typing}, at(s.at))
+			}
+			for _, frame := range typingFrames(t, bob) {
+				if frame.From != "alice" || frame.To != "" {
+					t.Errorf("%s: frame %+v", tt.name, frame)
+				}
+				got = append(got, frame.Typing)
+			}
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: relayed %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: relayed %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestTypingToSelf(t *testing.T) {
+	h := NewHub(nil)
+	alice := &Client{hub: h, user: User{ID: 1, Username: "alice"}, send: make(chan []byte, SendQueueSize)}
+	h.clients["alice"] = map[*Client]bool{alice: true}
+	h.handleTyping(typingEvent{alice.user, "alice", true}, time.Now())
+	if frames := typingFrames(t, alice); len(frames) != 0 || len(h.typists) != 0 {
+		t.Errorf("typing to oneself relayed %v", frames)
+	}
+}
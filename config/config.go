@@ -0,0 +1,353 @@
+// Package config loads the server settings. Defaults are overridden by an
+// optional TOML file, then by FORUM_* environment variables and finally by
+// command line flags, so one binary can run any deployment.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+type Config struct {
+	// Addr is the address the HTTP server listens on.
+	Addr string `toml:"addr"`
+	// Database is the path of the SQLite database file.
+	Database string `toml:"database"`
+	// PublicURL is where browsers reach the forum. OAuth callbacks and the
+	// websocket URL are derived from it.
+	PublicURL string `toml:"public_url"`
+	// AllowedOrigins are the pages allowed to open the chat websocket.
+	// Defaults to the origin of PublicURL.
+	AllowedOrigins []string `toml:"allowed_origins"`
+
+	Google OAuthClient `toml:"google"`
+	GitHub OAuthClient `toml:"github"`
+	// OIDC lists further OpenID Connect providers. They can only be
+	// configured in the file.
+	OIDC    []OIDCProvider `toml:"oidc"`
+	Session Session        `toml:"session"`
+	Chat    Chat           `toml:"chat"`
+	// Attachments are the files users upload to the chat.
+	Attachments Attachments `toml:"attachments"`
+	Comments    Comments    `toml:"comments"`
+}
+
+// OAuthClient holds the credentials of an OAuth application. A provider
+// without a client ID is disabled.
+type OAuthClient struct {
+	ClientID     string `toml:"client_id"`
+	ClientSecret string `toml:"client_secret"`
+}
+
+func (c OAuthClient) Enabled() bool {
+	return c.ClientID != ""
+}
+
+// OIDCProvider is an OpenID Connect issuer such as Keycloak, Authentik or
+// GitLab. Its endpoints are read from the issuer's discovery document.
+type OIDCProvider struct {
+	// Name identifies the provider in URLs, e.g. /auth/<name>.
+	Name   string `toml:"name"`
+	Issuer string `toml:"issuer"`
+	OAuthClient
+	// Scopes are requested in addition to "openid".
+	Scopes []string `toml:"scopes"`
+}
+
+type Session struct {
+	// Store is where sessions are kept: "sqlite" survives restarts,
+	// "memory" does not.
+	Store          string        `toml:"store"`
+	TTL            time.Duration `toml:"ttl"`
+	MultipleLogins bool          `toml:"multiple_logins"`
+	SweepInterval  time.Duration `toml:"sweep_interval"`
+	CookieDomain   string        `toml:"cookie_domain"`
+	CookieSecure   bool          `toml:"cookie_secure"`
+	// CookieSameSite is "lax", "strict" or "none".
+	CookieSameSite string `toml:"cookie_same_site"`
+}
+
+type Chat struct {
+	// EditWindow is how long after sending a private message its author may
+	// still edit or delete it; 0 turns editing and deleting off.
+	EditWindow time.Duration `toml:"edit_window"`
+}
+
+type Attachments struct {
+	// Dir is the directory uploaded files are kept in.
+	Dir string `toml:"dir"`
+	// MaxSize is the size of the largest file users may upload, in bytes.
+	MaxSize int64 `toml:"max_size"`
+}
+
+type Comments struct {
+	// MaxDepth is how deep replies may nest; comments on the post itself are
+	// at depth 0, so 1 allows replies but no replies to replies.
+	MaxDepth int `toml:"max_depth"`
+}
+
+// Default is the configuration for running locally.
+func Default() Config {
+	return Config{
+		Addr:      ":8080",
+		Database:  "registration.db",
+		PublicURL: "http://localhost:8080",
+		Session: Session{
+			Store:          "sqlite",
+			TTL:            40 * time.Minute,
+			SweepInterval:  time.Minute,
+			CookieSameSite: "lax",
+		},
+		Chat: Chat{
+			EditWindow: 15 * time.Minute,
+		},
+		Attachments: Attachments{
+			Dir:     "uploads",
+			MaxSize: 5 << 20,
+		},
+		Comments: Comments{
+			MaxDepth: 5,
+		},
+	}
+}
+
+// Load builds the configuration from args (without the program name) and the
+// environment, and validates it. The arguments left after the flags are
+// returned for subcommands.
+func Load(args []string) (*Config, []string, error) {
+	cfg := Default()
+
+	// The file has to be read before the flags are applied on top of it, so
+	// the flags are parsed once just to find it.
+	scratch := cfg
+	fs, path := flagSet(&scratch)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if *path != "" {
+		if _, err := toml.DecodeFile(*path, &cfg); err != nil {
+			return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, nil, err
+	}
+
+	fs, _ = flagSet(&cfg)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return &cfg, fs.Args(), nil
+}
+
+func flagSet(cfg *Config) (fs *flag.FlagSet, path *string) {
+	fs = flag.NewFlagSet("forum", flag.ContinueOnError)
+	path = fs.String("config", os.Getenv("FORUM_CONFIG"), "path of a TOML config file")
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on")
+	fs.StringVar(&cfg.Database, "db", cfg.Database, "path of the SQLite database")
+	fs.StringVar(&cfg.PublicURL, "public-url", cfg.PublicURL, "URL browsers use to reach the forum")
+	fs.StringVar(&cfg.Session.Store, "session-store", cfg.Session.Store, `where sessions are kept, "sqlite" or "memory"`)
+	fs.DurationVar(&cfg.Session.TTL, "session-ttl", cfg.Session.TTL, "how long an idle session stays valid")
+	fs.BoolVar(&cfg.Session.CookieSecure, "cookie-secure", cfg.Session.CookieSecure, "only send cookies over HTTPS")
+	return fs, path
+}
+
+// loadEnv overrides cfg with the FORUM_* environment variables that are set.
+func (cfg *Config) loadEnv() error {
+	texts := map[string]*string{
+		"FORUM_ADDR":                 &cfg.Addr,
+		"FORUM_DB":                   &cfg.Database,
+		"FORUM_PUBLIC_URL":           &cfg.PublicURL,
+		"FORUM_GOOGLE_CLIENT_ID":     &cfg.Google.ClientID,
+		"FORUM_GOOGLE_CLIENT_SECRET": &cfg.Google.ClientSecret,
+		"FORUM_GITHUB_CLIENT_ID":     &cfg.GitHub.ClientID,
+		"FORUM_GITHUB_CLIENT_SECRET": &cfg.GitHub.ClientSecret,
+		"FORUM_SESSION_STORE":        &cfg.Session.Store,
+		"FORUM_COOKIE_DOMAIN":        &cfg.Session.CookieDomain,
+		"FORUM_COOKIE_SAMESITE":      &cfg.Session.CookieSameSite,
+		"FORUM_ATTACHMENTS_DIR":      &cfg.Attachments.Dir,
+	}
+	for name, field := range texts {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"FORUM_SESSION_TTL":            &cfg.Session.TTL,
+		"FORUM_SESSION_SWEEP_INTERVAL": &cfg.Session.SweepInterval,
+		"FORUM_CHAT_EDIT_WINDOW":       &cfg.Chat.EditWindow,
+	}
+	for name, field := range durations {
+		if value, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*field = d
+		}
+	}
+
+	if value, ok := os.LookupEnv("FORUM_ALLOWED_ORIGINS"); ok {
+		cfg.AllowedOrigins = nil
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				cfg.AllowedOrigins = append(cfg.AllowedOrigins, origin)
+			}
+		}
+	}
+
+	if value, ok := os.LookupEnv("FORUM_ATTACHMENTS_MAX_SIZE"); ok {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("FORUM_ATTACHMENTS_MAX_SIZE: %w", err)
+		}
+		cfg.Attachments.MaxSize = n
+	}
+
+	if value, ok := os.LookupEnv("FORUM_COMMENTS_MAX_DEPTH"); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("FORUM_COMMENTS_MAX_DEPTH: %w", err)
+		}
+		cfg.Comments.MaxDepth = n
+	}
+
+	bools := map[string]*bool{
+		"FORUM_SESSION_MULTIPLE_LOGINS": &cfg.Session.MultipleLogins,
+		"FORUM_COOKIE_SECURE":           &cfg.Session.CookieSecure,
+	}
+	for name, field := range bools {
+		if value, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*field = b
+		}
+	}
+	return nil
+}
+
+// Validate reports the first setting that the server cannot run with.
+func (cfg *Config) Validate() error {
+	if cfg.Addr == "" {
+		return fmt.Errorf("addr must not be empty")
+	}
+	if cfg.Database == "" {
+		return fmt.Errorf("database must not be empty")
+	}
+
+	u, err := url.Parse(cfg.PublicURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("public_url %q must be an absolute http or https URL", cfg.PublicURL)
+	}
+	cfg.PublicURL = strings.TrimSuffix(cfg.PublicURL, "/")
+
+	if len(cfg.AllowedOrigins) == 0 {
+		cfg.AllowedOrigins = []string{u.Scheme + "://" + u.Host}
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		o, err := url.Parse(origin)
+		if err != nil || (o.Scheme != "http" && o.Scheme != "https") || o.Host == "" || strings.TrimSuffix(o.Path, "/") != "" {
+			return fmt.Errorf("allowed origin %q must look like https://host[:port]", origin)
+		}
+	}
+
+	for name, client := range map[string]OAuthClient{"google": cfg.Google, "github": cfg.GitHub} {
+		if client.Enabled() && client.ClientSecret == "" {
+			return fmt.Errorf("%s client_secret is required when client_id is set", name)
+		}
+	}
+
+	names := map[string]bool{"google": cfg.Google.Enabled(), "github": cfg.GitHub.Enabled()}
+	for _, p := range cfg.OIDC {
+		if p.Name == "" || strings.Trim(p.Name, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+			return fmt.Errorf("oidc provider name %q must be lowercase letters, digits and dashes", p.Name)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("oidc provider %q is configured twice", p.Name)
+		}
+		names[p.Name] = true
+		if err := validateIssuer(p.Issuer); err != nil {
+			return fmt.Errorf("oidc provider %q: %w", p.Name, err)
+		}
+		if !p.Enabled() {
+			return fmt.Errorf("oidc provider %q needs a client_id", p.Name)
+		}
+	}
+
+	switch cfg.Session.Store {
+	case "sqlite", "memory":
+	default:
+		return fmt.Errorf(`session store must be "sqlite" or "memory", not %q`, cfg.Session.Store)
+	}
+	if cfg.Session.TTL <= 0 {
+		return fmt.Errorf("session ttl must be positive")
+	}
+	if cfg.Session.SweepInterval <= 0 {
+		return fmt.Errorf("session sweep_interval must be positive")
+	}
+	switch cfg.Session.CookieSameSite {
+	case "lax", "strict":
+	case "none":
+		if !cfg.Session.CookieSecure {
+			return fmt.Errorf("cookie_same_site none requires cookie_secure")
+		}
+	default:
+		return fmt.Errorf(`cookie_same_site must be "lax", "strict" or "none", not %q`, cfg.Session.CookieSameSite)
+	}
+	if cfg.Chat.EditWindow < 0 {
+		return fmt.Errorf("chat edit_window must not be negative")
+	}
+	if cfg.Attachments.Dir == "" {
+		return fmt.Errorf("attachments dir must not be empty")
+	}
+	if cfg.Attachments.MaxSize <= 0 {
+		return fmt.Errorf("attachments max_size must be positive")
+	}
+	if cfg.Comments.MaxDepth <= 0 {
+		return fmt.Errorf("comments max_depth must be positive")
+	}
+	return nil
+}
+
+// validateIssuer requires HTTPS, except for issuers on the local machine used
+// in development.
+func validateIssuer(issuer string) error {
+	u, err := url.Parse(issuer)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("issuer %q must be an absolute URL", issuer)
+	}
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && (u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1"):
+	default:
+		return fmt.Errorf("issuer %q must use https", issuer)
+	}
+	return nil
+}
+
+// WebSocketURL is the chat endpoint as seen from the browser.
+func (cfg *Config) WebSocketURL() string {
+	u, _ := url.Parse(cfg.PublicURL)
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/ws"
+	return u.String()
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "forum.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadPrecedence checks that each source overrides the ones before it:
+// defaults, then the file, then the environment, then the flags.
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+addr = ":1000"
+database = "file.db"
+public_url = "https://file.example.com/"
+
+[session]
+ttl = "1h"
+store = "memory"
+`)
+	t.Setenv("FORUM_DB", "env.db")
+	t.Setenv("FORUM_PUBLIC_URL", "https://env.example.com")
+	t.Setenv("FORUM_SESSION_TTL", "2h")
+
+	cfg, rest, err := Load([]string{"-config", path, "-public-url", "https://flag.example.com", "migrate", "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		setting string
+		got     interface{}
+		want    interface{}
+	}{
+		{"addr from the file", cfg.Addr, ":1000"},
+		{"database from the environment", cfg.Database, "env.db"},
+		{"public_url from the flag", cfg.PublicURL, "https://flag.example.com"},
+		{"session ttl from the environment", cfg.Session.TTL, 2 * time.Hour},
+		{"session store from the file", cfg.Session.Store, "memory"},
+		{"default sweep interval", cfg.Session.SweepInterval, time.Minute},
+		{"default max depth", cfg.Comments.MaxDepth, 5},
+		{"allowed origins from public_url", strings.Join(cfg.AllowedOrigins, ","), "https://flag.example.com"},
+		{"arguments after the flags", strings.Join(rest, " "), "migrate up"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.setting, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFromEnvironment(t *testing.T) {
+	t.Setenv("FORUM_CONFIG", writeFile(t, `addr = ":2000"`))
+	t.Setenv("FORUM_ALLOWED_ORIGINS", "https://a.example.com, ,https://b.example.com")
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":2000" {
+		t.Errorf("addr %q, want the one in FORUM_CONFIG", cfg.Addr)
+	}
+	if got := strings.Join(cfg.AllowedOrigins, ","); got != "https://a.example.com,https://b.example.com" {
+		t.Errorf("allowed origins %q", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"missing file", nil, []string{"-config", filepath.Join(t.TempDir(), "none.toml")}, "failed to read config file"},
+		{"bad file", nil, []string{"-config", writeFile(t, "addr = ")}, "failed to read config file"},
+		{"bad duration", map[string]string{"FORUM_SESSION_TTL": "soon"}, nil, "FORUM_SESSION_TTL"},
+		{"bad bool", map[string]string{"FORUM_COOKIE_SECURE": "maybe"}, nil, "FORUM_COOKIE_SECURE"},
+		{"bad number", map[string]string{"FORUM_COMMENTS_MAX_DEPTH": "deep"}, nil, "FORUM_COMMENTS_MAX_DEPTH"},
+		{"unknown flag", nil, []string{"-verbose"}, "flag provided but not defined"},
+		{"invalid value", map[string]string{"FORUM_SESSION_STORE": "redis"}, nil, "session store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if _, _, err := Load(tt.args); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error about %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		want   string
+	}{
+		{"defaults", func(cfg *Config) {}, ""},
+		{"no addr", func(cfg *Config) { cfg.Addr = "" }, "addr"},
+		{"no database", func(cfg *Config) { cfg.Database = "" }, "database"},
+		{"relative public_url", func(cfg *Config) { cfg.PublicURL = "/forum" }, "public_url"},
+		{"ftp public_url", func(cfg *Config) { cfg.PublicURL = "ftp://example.com" }, "public_url"},
+		{"origin with a path", func(cfg *Config) { cfg.AllowedOrigins = []string{"https://example.com/chat"} }, "allowed origin"},
+		{"origin with a slash", func(cfg *Config) { cfg.AllowedOrigins = []string{"https://example.com/"} }, ""},
+		{"client without secret", func(cfg *Config) { cfg.GitHub.ClientID = "id" }, "github client_secret"},
+		{"oidc name", func(cfg *Config) {
+			cfg.OIDC = []OIDCProvider{{Name: "Key Cloak", Issuer: "https://id.example.com", OAuthClient: OAuthClient{ClientID: "id"}}}
+		}, "oidc provider name"},
+		{"oidc name taken", func(cfg *Config) {
+			cfg.Google = OAuthClient{ClientID: "id", ClientSecret: "secret"}
+			cfg.OIDC = []OIDCProvider{{Name: "google", Issuer: "https://id.example.com", OAuthClient: OAuthClient{ClientID: "id"}}}
+		}, "configured twice"},
+		{"oidc over http", func(cfg *Config) {
+			cfg.OIDC = []OIDCProvider{{Name: "keycloak", Issuer: "http://id.example.com", OAuthClient: OAuthClient{ClientID: "id"}}}
+		}, "must use https"},
+		{"oidc on localhost", func(cfg *Config) {
+			cfg.OIDC = []OIDCProvider{{Name: "keycloak", Issuer: "http://localhost:8081", OAuthClient: OAuthClient{ClientID: "id"}}}
+		}, ""},
+		{"oidc without client", func(cfg *Config) {
+			cfg.OIDC = []OIDCProvider{{Name: "keycloak", Issuer: "https://id.example.com"}}
+		}, "needs a client_id"},
+		{"session store", func(cfg *Config) { cfg.Session.Store = "redis" }, "session store"},
+		{"session ttl", func(cfg *Config) { cfg.Session.TTL = 0 }, "session ttl"},
+		{"sweep interval", func(cfg *Config) { cfg.Session.SweepInterval = -time.Second }, "sweep_interval"},
+		{"same site", func(cfg *Config) { cfg.Session.CookieSameSite = "always" }, "cookie_same_site"},
+		{"same site none over http", func(cfg *Config) { cfg.Session.CookieSameSite = "none" }, "requires cookie_secure"},
+		{"same site none over https", func(cfg *Config) {
+			cfg.Session.CookieSameSite = "none"
+			cfg.Session.CookieSecure = true
+		}, ""},
+		{"edit window", func(cfg *Config) { cfg.Chat.EditWindow = -time.Minute }, "edit_window"},
+		{"no edit window", func(cfg *Config) { cfg.Chat.EditWindow = 0 }, ""},
+		{"attachments dir", func(cfg *Config) { cfg.Attachments.Dir = "" }, "attachments dir"},
+		{"attachments size", func(cfg *Config) { cfg.Attachments.MaxSize = 0 }, "max_size"},
+		{"comment depth", func(cfg *Config) { cfg.Comments.MaxDepth = 0 }, "max_depth"},
+	}
+	for _, tt := range tests {
+		cfg := Default()
+		tt.change(&cfg)
+		err := cfg.Validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: got %v, want an error about %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestWebSocketURL(t *testing.T) {
+	tests := []struct {
+		publicURL string
+		want      string
+	}{
+		{"http://localhost:8080", "ws://localhost:8080/ws"},
+		{"https://example.com/forum/", "wss://example.com/forum/ws"},
+	}
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.PublicURL = tt.publicURL
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg.WebSocketURL(); got != tt.want {
+			t.Errorf("WebSocketURL for %s = %q, want %q", tt.publicURL, got, tt.want)
+		}
+	}
+}
@@ -1,4 +1,5 @@
 import { fetchMyPosts } from "./mypostsfilter.js";
+import { csrfHeaders } from "/static/csrf.js";
 
 export function addCommentForm() {
     var appDiv = document.getElementById('app');
@@ -10,7 +11,9 @@ export function addCommentForm() {
     // Create the main title
     var mainTitle = document.createElement('h1');
     mainTitle.className = 'text-3xl font-bold text-center pt-8';
-    mainTitle.textContent = 'Will you add a comment?';
+    // Replies come with the comment they answer in the parent parameter
+    var parentId = parseInt(new URL(window.location.href).searchParams.get('parent')) || 0;
+    mainTitle.textContent = parentId ? 'Will you reply?' : 'Will you add a comment?';
     appDiv.appendChild(mainTitle);
     var flexDiv = document.createElement('div')
     flexDiv.className = 'flex justify-center p-2 font-bold'
@@ -117,18 +120,18 @@ export function addCommentForm() {
             }
             fetch(`/submitcomment`, {
             method: "POST",
-            body: JSON.stringify({ postID: postIdWithoutHash, comment: postContent }),
-            headers: {
+            body: JSON.stringify({ postID: postIdWithoutHash, parentID: parentId, comment: postContent }),
+            headers: csrfHeaders({
                     "Content-Type": "application/json",
-                },
+                }),
             })
             .then((response) => {
                 if (response.ok) {
                     console.log("Post added successfully!");
-                    window.location.href = "http://localhost:8080/#login";
+                    window.location.href = "/#login";
                 } else {
                     console.error("Error adding post");
-                    alert("Error adding post ")
+                    response.text().then((text) => alert("Error adding post: " + text))
                 }
             })
             .catch((error) => {
@@ -1,4 +1,5 @@
 import { fetchMyPosts } from "./mypostsfilter.js";
+import { csrfHeaders } from "/static/csrf.js";
 
 export function createPost() {
   var appDiv = document.getElementById("app");
@@ -95,6 +96,16 @@ export function createPost() {
   createCheckbox("runescape", "runescape", 2);
   createCheckbox("counter-strike", "counter-strike", 3);
 
+  // Create title input
+  var postTitleInput = document.createElement("input");
+  postTitleInput.className = "m-3";
+  postTitleInput.id = "postTitle";
+  postTitleInput.name = "postTitle";
+  postTitleInput.maxLength = 120;
+  postTitleInput.placeholder = "Title";
+  postForm.appendChild(postTitleInput);
+  postForm.appendChild(document.createElement("br"));
+
   // Create textarea
   var postContentTextarea = document.createElement("textarea");
   postContentTextarea.className = "m-3";
@@ -133,6 +144,7 @@ export function createPost() {
         });
 
       var postData = {
+        title: document.getElementById("postTitle").value,
         content: postContent,
         categories: categories,
       };
@@ -140,17 +152,17 @@ export function createPost() {
       fetch("/submitpost", {
         method: "POST",
         body: JSON.stringify(postData),
-        headers: {
+        headers: csrfHeaders({
           "Content-Type": "application/json",
-        },
+        }),
       })
         .then((response) => {
           if (response.ok) {
             console.log("Post added successfully!");
-            window.location.href = "http://localhost:8080/#login";
+            window.location.href = "/#login";
           } else {
             console.error("Error adding post");
-            alert("Error adding post ");
+            response.text().then((text) => alert("Error adding post: " + text));
           }
         })
         .catch((error) => {
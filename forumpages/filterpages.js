@@ -1,13 +1,14 @@
 import { mainPage } from "./mainpage.js";
+import { csrfHeaders } from "/static/csrf.js";
 
 export function fetchFilteredPosts(categories) {
     console.log("[start fetch]")
     fetch('/filterpage', {
         method: 'POST', // or GET with query parameters
         body: JSON.stringify({ categories: categories }),
-        headers: {
+        headers: csrfHeaders({
             'Content-Type': 'application/json',
-        },
+        }),
     })
     .then(response => response.json())
     .then(data => {
@@ -6,6 +6,16 @@ import { likesFunction } from "/static/likesFunction.js";
 import { fetchFilteredPosts } from "./filterpages.js";
 import { fetchMyPosts } from "./mypostsfilter.js";
 import { logout } from "./logout.js";
+import { csrfHeaders, csrfToken } from "/static/csrf.js";
+
+// Hidden field that lets plain form posts pass the server's CSRF check.
+function csrfInput() {
+  const input = document.createElement("input");
+  input.type = "hidden";
+  input.name = "csrf_token";
+  input.value = csrfToken();
+  return input;
+}
 
 export async function mainPage(data) {
   if (!data) {
@@ -100,7 +110,7 @@ export async function mainPage(data) {
   showAllPostsBtn.className =
     "bg-blue-300 hover:bg-blue-400 border rounded p-2 m-1 transition duration-500";
   showAllPostsBtn.onclick = function () {
-    window.location.href = "http://localhost:8080#login";
+    window.location.href = "/#login";
   };
   showAllPostsBtn.textContent = "Show all posts";
   showAllPostsBtn.addEventListener("click", function (event) {
@@ -125,9 +135,9 @@ export async function mainPage(data) {
     try {
       const response = await fetch("/logout", {
         method: "POST",
-        headers: {
+        headers: csrfHeaders({
           "Content-Type": "application/json",
-        },
+        }),
       });
 
       if (!response.ok) {
@@ -145,9 +155,33 @@ export async function mainPage(data) {
     logout();
   });
 
+  logoutForm.appendChild(csrfInput());
   logoutForm.appendChild(logoutBtn);
   buttonDiv.appendChild(logoutForm);
 
+  // Link or unlink accounts of the configured login providers
+  fetch("/auth/identities")
+    .then((response) => (response.ok ? response.json() : []))
+    .then((providers) => {
+      providers.forEach((provider) => {
+        const action = provider.linked ? "unlink" : "link";
+        const providerForm = document.createElement("form");
+        providerForm.action = `/auth/${provider.provider}/${action}`;
+        providerForm.method = "post";
+
+        const providerBtn = document.createElement("input");
+        providerBtn.className =
+          "bg-blue-300 hover:bg-blue-400 border rounded p-2 m-1 transition duration-500";
+        providerBtn.type = "submit";
+        providerBtn.value = `${provider.linked ? "Unlink" : "Link"} ${provider.provider}`;
+
+        providerForm.appendChild(csrfInput());
+        providerForm.appendChild(providerBtn);
+        buttonDiv.appendChild(providerForm);
+      });
+    })
+    .catch((error) => console.error("Loading login providers failed:", error));
+
   appDiv.appendChild(buttonDiv);
 
   // Create the filtered posts form
@@ -218,11 +252,273 @@ export async function mainPage(data) {
   filteredPostsForm.appendChild(filterContainerDiv);
 
   appDiv.appendChild(filteredPostsForm);
+  appDiv.appendChild(createSearchForm(games));
+
+  // How deep replies may nest, from the server
+  const { commentMaxDepth } = await fetch("/client-config").then((response) =>
+    response.json()
+  );
+  const moderator = data.Role == "moderator" || data.Role == "admin";
+
+  // A comment with its replies nested below it. Deleted comments that still
+  // have replies are shown as placeholders.
+  function commentElement(post, comment) {
+    const commentDiv = document.createElement("div");
+    const commentInnerDiv = document.createElement("div");
+    commentInnerDiv.className =
+      "flex flex-col p-2 bg-gray-300 mb-4 mx-8 rounded";
+    const commentContent = document.createElement("div");
+    commentContent.className = "m-2";
+    if (comment.Deleted) {
+      commentContent.textContent = comment.Content;
+    } else {
+      showRendered(commentContent, comment.ContentHTML);
+    }
+    commentInnerDiv.appendChild(commentContent);
+    commentDiv.appendChild(commentInnerDiv);
+
+    if (comment.Deleted) {
+      commentDiv.className = "comment-placeholder border rounded my-1";
+      commentContent.classList.add("comment-deleted");
+    } else {
+      commentDiv.className = "comment border rounded my-1";
+      const commentAttributes = document.createElement("p");
+      commentAttributes.className = "my-5 mx-2";
+      commentAttributes.textContent = "Post by: ";
+      const commentUsername = document.createElement("span");
+      commentUsername.className = "font-bold";
+      commentUsername.textContent = comment.Username;
+      const commentPostedAgo = document.createElement("p");
+      commentPostedAgo.textContent = comment.PostedAgo;
+      if (comment.EditedAt) {
+        commentPostedAgo.appendChild(editedMarker("comment", comment.ID));
+      }
+      commentAttributes.appendChild(commentUsername);
+      commentAttributes.appendChild(commentPostedAgo);
+      commentInnerDiv.appendChild(commentAttributes);
+      const commentFlexBox = document.createElement("div");
+      commentFlexBox.className = "flex";
+      const commentLikeButton = document.createElement("button");
+      commentLikeButton.className =
+        "commentlikeButton mr-2 bg-blue-500 hover:bg-blue-700 text-white py-2 px-2 rounded";
+      commentLikeButton.dataset.commentId = comment.ID;
+      //check here
+      commentLikeButton.dataset.userId = comment.Username;
+      commentLikeButton.innerHTML = `👍 <span id="commentlikeCount${comment.ID}">${comment.Likes}</span>`;
+
+      commentFlexBox.appendChild(commentLikeButton);
+      const commentDisLikeButton = document.createElement("button");
+      commentDisLikeButton.className =
+        "commentdislikeButton mr-2 bg-red-500 hover:bg-red-700 text-white py-1.5 px-1.5 rounded";
+      commentDisLikeButton.dataset.commentId = comment.ID;
+      commentDisLikeButton.dataset.userId = comment.Username;
+      commentDisLikeButton.innerHTML = `👎 <span id="commentdislikeCount${comment.ID}">${comment.Dislikes}</span>`;
+      commentFlexBox.appendChild(commentDisLikeButton);
+
+      // Replies are written on the add comment page, like comments
+      if (data.Username && comment.Depth < commentMaxDepth) {
+        const replyLink = document.createElement("a");
+        replyLink.className = "comment-action";
+        replyLink.href = `/?id=${post.ID}&parent=${comment.ID}#addcomment`;
+        replyLink.textContent = "Reply";
+        commentFlexBox.appendChild(replyLink);
+      }
+      if (data.Username && (comment.Username == data.Username || moderator)) {
+        const editButton = document.createElement("button");
+        editButton.className = "comment-action";
+        editButton.textContent = "Edit";
+        editButton.onclick = () =>
+          showEditForm(commentContent, null, comment.Content, (title, content) =>
+            saveEdit("/edit-comment", { id: comment.ID, content: content })
+          );
+        commentFlexBox.appendChild(editButton);
+        const deleteButton = document.createElement("button");
+        deleteButton.className = "comment-action";
+        deleteButton.textContent = "Delete";
+        deleteButton.onclick = () => deleteComment(comment.ID);
+        commentFlexBox.appendChild(deleteButton);
+      }
+      commentInnerDiv.appendChild(commentFlexBox);
+    }
+    if (comment.Depth == 0) {
+      commentDiv.classList.add("ml-24");
+    }
+
+    if (comment.ReplyCount) {
+      const replyCount = document.createElement("p");
+      replyCount.className = "comment-replies-count";
+      replyCount.textContent =
+        comment.ReplyCount == 1 ? "1 reply" : `${comment.ReplyCount} replies`;
+      commentInnerDiv.appendChild(replyCount);
+    }
+    if (comment.Replies) {
+      const repliesDiv = document.createElement("div");
+      repliesDiv.className = "comment-replies";
+      comment.Replies.forEach((reply) => {
+        repliesDiv.appendChild(commentElement(post, reply));
+      });
+      commentDiv.appendChild(repliesDiv);
+    }
+    return commentDiv;
+  }
+
+  function deleteComment(id) {
+    if (!confirm("Delete this comment?")) {
+      return;
+    }
+    fetch("/delete-comment", {
+      method: "POST",
+      headers: csrfHeaders({ "Content-Type": "application/json" }),
+      body: JSON.stringify({ id: id }),
+    })
+      .then((response) => {
+        if (!response.ok) {
+          return response.text().then((text) => alert(text));
+        }
+        mainPage();
+      })
+      .catch((error) => console.error("Error:", error));
+  }
+
+  // Fills element with content the server rendered from Markdown and
+  // sanitized. Clicking a mention opens a chat with the user.
+  function showRendered(element, html) {
+    element.classList.add("rendered-content");
+    element.innerHTML = html;
+    element.querySelectorAll(".mention").forEach((mention) => {
+      if (data.Username && mention.dataset.username != data.Username) {
+        mention.classList.add("mention-link");
+        mention.onclick = () => initiateChat(mention.dataset.username);
+      }
+    });
+  }
+
+  // Marks edited posts and comments; clicking it shows what edits changed.
+  function editedMarker(kind, id) {
+    const marker = document.createElement("button");
+    marker.className = "edited-marker";
+    marker.textContent = "(edited)";
+    marker.onclick = () => showRevisions(kind, id);
+    return marker;
+  }
+
+  // Replaces content, and the title of posts, by a form to edit them.
+  function showEditForm(contentElement, titleElement, content, save) {
+    const form = document.createElement("form");
+    form.className = "edit-form";
+    let titleInput = null;
+    if (titleElement) {
+      titleInput = document.createElement("input");
+      titleInput.maxLength = 120;
+      titleInput.value = titleElement.textContent;
+      form.appendChild(titleInput);
+      titleElement.style.display = "none";
+    }
+    const textarea = document.createElement("textarea");
+    textarea.rows = 4;
+    textarea.value = content;
+    form.appendChild(textarea);
+    const saveButton = document.createElement("button");
+    saveButton.type = "submit";
+    saveButton.textContent = "Save";
+    form.appendChild(saveButton);
+    const cancelButton = document.createElement("button");
+    cancelButton.type = "button";
+    cancelButton.textContent = "Cancel";
+    form.appendChild(cancelButton);
+
+    const close = () => {
+      form.remove();
+      contentElement.style.display = "";
+      if (titleElement) {
+        titleElement.style.display = "";
+      }
+    };
+    cancelButton.onclick = close;
+    form.onsubmit = (event) => {
+      event.preventDefault();
+      save(titleInput ? titleInput.value : "", textarea.value);
+    };
+    contentElement.style.display = "none";
+    contentElement.after(form);
+    textarea.focus();
+  }
+
+  function saveEdit(url, body) {
+    fetch(url, {
+      method: "POST",
+      headers: csrfHeaders({ "Content-Type": "application/json" }),
+      body: JSON.stringify(body),
+    })
+      .then((response) => {
+        if (!response.ok) {
+          return response.text().then((text) => alert(text));
+        }
+        mainPage();
+      })
+      .catch((error) => console.error("Error:", error));
+  }
+
+  // Lists the versions edits replaced, each with what the next edit changed.
+  function showRevisions(kind, id) {
+    fetch(`/${kind}-revisions?id=${id}`)
+      .then((response) => {
+        if (!response.ok) {
+          throw new Error(`revisions: ${response.status}`);
+        }
+        return response.json();
+      })
+      .then((history) => {
+        const overlay = document.createElement("div");
+        overlay.className = "revisions";
+        const closeButton = document.createElement("button");
+        closeButton.className = "revisions-close";
+        closeButton.textContent = "Close";
+        closeButton.onclick = () => overlay.remove();
+        overlay.appendChild(closeButton);
+        const heading = document.createElement("h2");
+        heading.className = "font-bold";
+        heading.textContent = "Edit history";
+        overlay.appendChild(heading);
+
+        history.revisions.forEach((revision) => {
+          const revisionDiv = document.createElement("div");
+          revisionDiv.className = "revision";
+          const editedBy = document.createElement("p");
+          editedBy.className = "revision-heading";
+          editedBy.textContent = `Edited by ${revision.editor} ${formatDate(revision.replacedAt)}`;
+          revisionDiv.appendChild(editedBy);
+          if (revision.titleDiff) {
+            const title = diffElement(revision.titleDiff);
+            title.classList.add("font-bold");
+            revisionDiv.appendChild(title);
+          }
+          revisionDiv.appendChild(diffElement(revision.diff));
+          overlay.appendChild(revisionDiv);
+        });
+        document.body.appendChild(overlay);
+      })
+      .catch((error) => console.error("Error:", error));
+  }
+
+  // Shows a diff with removed text struck through and added text marked.
+  function diffElement(parts) {
+    const diff = document.createElement("p");
+    diff.className = "diff";
+    parts.forEach((part) => {
+      const tag = { insert: "ins", delete: "del" }[part.op];
+      const span = tag ? document.createElement(tag) : document.createTextNode("");
+      span.textContent = part.text;
+      diff.appendChild(span);
+    });
+    return diff;
+  }
 
   // Posts loop
   data.Posts.forEach((post) => {
     const postDiv = document.createElement("div");
     postDiv.className = "post";
+    postDiv.id = `post${post.ID}`;
 
     const innerPostDiv = document.createElement("div");
     innerPostDiv.className = "flex flex-col p-6 bg-gray-200 mb-4 rounded";
@@ -230,9 +526,13 @@ export async function mainPage(data) {
     const innerPostDiv2 = document.createElement("div");
     innerPostDiv2.className = "bg-gray-300";
     innerPostDiv.appendChild(innerPostDiv2);
-    const postContent = document.createElement("p");
+    const postTitle = document.createElement("h2");
+    postTitle.className = "post-title";
+    postTitle.textContent = post.Title;
+    innerPostDiv2.appendChild(postTitle);
+    const postContent = document.createElement("div");
     postContent.className = "m-5";
-    postContent.textContent = post.Content;
+    showRendered(postContent, post.ContentHTML);
     innerPostDiv2.appendChild(postContent);
     const postAttributes = document.createElement("p");
     postAttributes.className = "my-5 mx-5";
@@ -243,6 +543,19 @@ export async function mainPage(data) {
     postAttributes.appendChild(usernamePostAttributes);
     const usernamePostAttributes2 = document.createElement("p");
     usernamePostAttributes2.textContent = post.PostedAgo;
+    if (post.EditedAt) {
+      usernamePostAttributes2.appendChild(editedMarker("post", post.ID));
+    }
+    if (data.Username && (post.Username == data.Username || moderator)) {
+      const editPostButton = document.createElement("button");
+      editPostButton.className = "comment-action";
+      editPostButton.textContent = "Edit";
+      editPostButton.onclick = () =>
+        showEditForm(postContent, postTitle, post.Content, (title, content) =>
+          saveEdit("/edit-post", { id: post.ID, title: title, content: content })
+        );
+      usernamePostAttributes2.appendChild(editPostButton);
+    }
     postAttributes.appendChild(usernamePostAttributes2);
     innerPostDiv2.appendChild(postAttributes);
     const flexBox = document.createElement("div");
@@ -296,48 +609,7 @@ export async function mainPage(data) {
     //range comments
     if (post.Comments) {
       post.Comments.forEach((comment) => {
-        const commentDiv = document.createElement("div");
-        commentDiv.className = "comment border rounded my-1 ml-24";
-        const commentInnerDiv = document.createElement("div");
-        commentInnerDiv.className =
-          "flex flex-col p-2 bg-gray-300 mb-4 mx-8 rounded";
-        const commentContent = document.createElement("p");
-        commentContent.className = "m-2";
-        commentContent.textContent = comment.Content;
-        const commentAttributes = document.createElement("p");
-        commentAttributes.className = "my-5 mx-2";
-        commentAttributes.textContent = "Post by: ";
-        const commentUsername = document.createElement("span");
-        commentUsername.className = "font-bold";
-        commentUsername.textContent = comment.Username;
-        const commentPostedAgo = document.createElement("p");
-        commentPostedAgo.textContent = comment.PostedAgo;
-        commentAttributes.appendChild(commentUsername);
-        commentAttributes.appendChild(commentPostedAgo);
-        commentInnerDiv.appendChild(commentContent);
-        commentInnerDiv.appendChild(commentAttributes);
-        const commentFlexBox = document.createElement("div");
-        commentFlexBox.class = "flex";
-        const commentLikeButton = document.createElement("button");
-        commentLikeButton.className =
-          "commentlikeButton mr-2 bg-blue-500 hover:bg-blue-700 text-white py-2 px-2 rounded";
-        commentLikeButton.dataset.commentId = comment.ID;
-        //check here
-        commentLikeButton.dataset.userId = comment.Username;
-        commentLikeButton.innerHTML = `👍 <span id="commentlikeCount${comment.ID}">${comment.Likes}</span>`;
-
-        commentFlexBox.appendChild(commentLikeButton);
-        const commentDisLikeButton = document.createElement("button");
-        commentDisLikeButton.className =
-          "commentdislikeButton mr-2 bg-red-500 hover:bg-red-700 text-white py-1.5 px-1.5 rounded";
-        commentDisLikeButton.dataset.commentId = comment.ID;
-        commentDisLikeButton.dataset.userId = comment.Username;
-        commentDisLikeButton.innerHTML = `👎 <span id="commentdislikeCount${comment.ID}">${comment.Dislikes}</span>`;
-        commentFlexBox.appendChild(commentDisLikeButton);
-
-        commentInnerDiv.appendChild(commentFlexBox);
-        commentDiv.appendChild(commentInnerDiv);
-        commentsSection.appendChild(commentDiv);
+        commentsSection.appendChild(commentElement(post, comment));
       });
     }
     innerPostDiv.appendChild(commentsSection);
@@ -358,7 +630,7 @@ export async function mainPage(data) {
       "submit-button bg-gray-300 hover:bg-gray-400 text-black p-2 mt-4 rounded";
     inputAddComment2.innerHTML = `Comment`;
     addCommentForm.appendChild(inputAddComment2);
-    if (data.Role == "moderator") {
+    if (data.Role == "moderator" || data.Role == "admin") {
       const moderatorForm = document.createElement("form");
       moderatorForm.action = "/delete";
       moderatorForm.method = "post";
@@ -371,6 +643,7 @@ export async function mainPage(data) {
       reportButton.value = post.ID;
       reportButton.textContent = "Report this post!";
 
+      moderatorForm.appendChild(csrfInput());
       moderatorForm.appendChild(reportButton);
       innerPostDiv.appendChild(moderatorForm);
     }
@@ -383,7 +656,7 @@ export async function mainPage(data) {
     appDiv.appendChild(postDiv);
   });
 
-  function updateUserStatus(username, isOnline, userId) {
+  function updateUserStatus(username, isOnline, userId, lastSeen) {
     const userItem = document.querySelector(
       `.user-list-item[data-user-id="${userId}"]`
     );
@@ -392,18 +665,23 @@ export async function mainPage(data) {
       // If the user is already in the list, update their status
       if (isOnline) {
         userItem.classList.add("online");
+        userItem.title = "Online";
       } else {
         userItem.classList.remove("online");
+        showLastSeen(userItem, lastSeen);
       }
     } else {
       // If the user is not in the list and is online, add them
-      if (isOnline) {
+      if (isOnline && !blockedUsers.has(username)) {
         const newUserItem = document.createElement("div");
         newUserItem.classList.add("user-list-item", "chatboxToggle", "online");
         newUserItem.textContent = username;
         newUserItem.setAttribute("data-username", username);
         newUserItem.onclick = () => initiateChat(username);
-        document.getElementById("userList").appendChild(newUserItem);
+        // Private messages come before the groups and channels
+        document
+          .getElementById("userList")
+          .insertBefore(newUserItem, document.getElementById("conversations"));
       }
     }
   }
@@ -428,6 +706,9 @@ export async function mainPage(data) {
       userItem.textContent = user.username;
       currentChatUsername = user;
       userItem.dataset.userId = user.id; // Store the user ID using data attributes
+      userItem.dataset.username = user.username;
+      setUnread(userItem, user.unread);
+      showLastSeen(userItem, user.lastSeen);
 
       // Set up the click event for initiating chat
       userItem.onclick = () => initiateChat(user.username, user.id); // Pass both username and ID
@@ -446,6 +727,8 @@ export async function mainPage(data) {
       userItem.classList.add("user-list-item", "chatboxToggle");
       userItem.textContent = user.username;
       userItem.dataset.userId = user.id; 
+      userItem.dataset.username = user.username;
+      setUnread(userItem, user.unread);
       //I try to outComment this line
       // currentChatUsername = user;
       // chatBox.classList.add('expanded');
@@ -459,6 +742,53 @@ export async function mainPage(data) {
     addEventListenersToUsers();
   }
 
+  function showLastSeen(userItem, lastSeen) {
+    userItem.title = lastSeen ? `Last seen ${formatDate(lastSeen)}` : "";
+  }
+
+  function userListItem(username) {
+    return document.querySelector(
+      `.user-list-item[data-username="${CSS.escape(username)}"]`
+    );
+  }
+
+  // Shows how many messages from a user are unread next to their name
+  function setUnread(userItem, count) {
+    userItem.dataset.unread = count;
+    let badge = userItem.querySelector(".unread-badge");
+    if (!count) {
+      if (badge) {
+        badge.remove();
+      }
+      return;
+    }
+    if (!badge) {
+      badge = document.createElement("span");
+      badge.classList.add("unread-badge");
+      badge.style.marginLeft = "8px";
+      badge.style.padding = "0 6px";
+      badge.style.borderRadius = "9999px";
+      badge.style.backgroundColor = "#b91c1c";
+      badge.style.color = "white";
+      badge.style.fontSize = "0.75rem";
+      userItem.appendChild(badge);
+    }
+    badge.textContent = count;
+  }
+
+  // The sidebar is ordered by the last message, so a new message moves its
+  // conversation to the top.
+  function moveUserToTop(username) {
+    const userListContainer = document.getElementById("userList");
+    const userItem = userListItem(username);
+    if (userItem) {
+      userListContainer.insertBefore(
+        userItem,
+        userListContainer.querySelector(".user-list-item")
+      );
+    }
+  }
+
   function addEventListenersToUsers() {
     const users = document.querySelectorAll(".chatboxToggle");
     const chatBox = document.getElementById("chatbox");
@@ -470,17 +800,354 @@ export async function mainPage(data) {
     });
   }
 
+  // Groups and channels are listed below the private messages, the ones
+  // with the latest messages first.
+  let currentConversation = null;
+  async function createConversationList() {
+    const response = await fetch("/conversations");
+    if (!response.ok) {
+      return;
+    }
+    const conversations = await response.json();
+    const section = document.createElement("div");
+    section.id = "conversations";
+    const header = document.createElement("div");
+    header.innerHTML = "Channels & groups";
+    header.style.textAlign = "center";
+    header.style.fontWeight = "bold";
+    header.style.margin = "20px 0";
+    section.appendChild(header);
+
+    const newGroupBtn = document.createElement("button");
+    newGroupBtn.textContent = "New group";
+    newGroupBtn.className = "border rounded px-2 mb-2";
+    newGroupBtn.onclick = createGroup;
+    section.appendChild(newGroupBtn);
+
+    const list = document.createElement("div");
+    list.id = "conversationList";
+    section.appendChild(list);
+    conversations.forEach((conversation) => list.appendChild(conversationListItem(conversation)));
+    document.getElementById("userList").appendChild(section);
+  }
+
+  function conversationListItem(conversation) {
+    const item = document.createElement("div");
+    item.classList.add("user-list-item", "conversation-list-item");
+    item.textContent =
+      conversation.kind === "channel" ? `#${conversation.name}` : conversation.name;
+    item.dataset.conversationId = conversation.id;
+    item.dataset.name = item.textContent;
+    setUnread(item, conversation.unread);
+    item.onclick = () => openConversation(conversation.id);
+    return item;
+  }
+
+  function conversationItem(id) {
+    return document.querySelector(
+      `.conversation-list-item[data-conversation-id="${id}"]`
+    );
+  }
+
+  // We were added to a group
+  // Tells the user someone replied to their comment; clicking it shows the
+  // post.
+  function showReplyNotification(reply) {
+    const notification = document.createElement("div");
+    notification.className = "reply-notification";
+    notification.textContent = `${reply.from} replied to your comment: ${reply.comment.Content}`;
+    notification.onclick = () => {
+      notification.remove();
+      const post = document.getElementById(`post${reply.postId}`);
+      if (post) {
+        post.scrollIntoView({ behavior: "smooth" });
+      }
+    };
+    document.body.appendChild(notification);
+    setTimeout(() => notification.remove(), 8000);
+  }
+
+  function addConversation(conversation) {
+    const list = document.getElementById("conversationList");
+    if (list && !conversationItem(conversation.id)) {
+      list.insertBefore(conversationListItem(conversation), list.firstChild);
+    }
+  }
+
+  async function createGroup() {
+    const name = prompt("Name of the group");
+    if (!name) {
+      return;
+    }
+    const members = (prompt("Usernames of the members, separated by commas") || "")
+      .split(",")
+      .map((username) => username.trim())
+      .filter((username) => username);
+    const response = await fetch("/conversations", {
+      method: "POST",
+      headers: csrfHeaders({
+        "Content-Type": "application/json",
+      }),
+      body: JSON.stringify({ name, members }),
+    });
+    if (!response.ok) {
+      alert(`Group not created: ${await response.text()}`);
+      return;
+    }
+    const group = await response.json();
+    addConversation({ id: group.id, kind: "group", name, unread: 0 });
+    openConversation(group.id);
+  }
+
+  function openConversation(id) {
+    stopTyping();
+    currentChatUsername = null;
+    currentConversation = id;
+    const item = conversationItem(id);
+    if (item) {
+      setUnread(item, 0);
+    }
+    setupWebSocket();
+    getMessagesFromServer();
+    allMessagesLoaded = false;
+    document.getElementById("chat-header-username").textContent = item
+      ? item.dataset.name
+      : "";
+    document.getElementById("blockUser").style.display = "none";
+    document.getElementById("chatbox").classList.add("expanded");
+    showTypists();
+  }
+
+  // Users we blocked are left out of the list; the privacy section below the
+  // conversations lets us unblock them and choose who may message us.
+  const blockedUsers = new Set();
+  async function createPrivacyControls() {
+    const response = await fetch("/privacy");
+    if (!response.ok) {
+      return;
+    }
+    const privacy = await response.json();
+    privacy.blocked.forEach((username) => blockedUsers.add(username));
+
+    const section = document.createElement("div");
+    section.id = "privacy";
+    const header = document.createElement("div");
+    header.innerHTML = "Privacy";
+    header.style.textAlign = "center";
+    header.style.fontWeight = "bold";
+    header.style.margin = "20px 0";
+    section.appendChild(header);
+
+    const setting = document.createElement("select");
+    [
+      ["everyone", "Everyone can message me"],
+      ["contacts", "Only people I've chatted with"],
+      ["nobody", "Nobody can message me"],
+    ].forEach(([value, label]) => {
+      const option = document.createElement("option");
+      option.value = value;
+      option.textContent = label;
+      setting.appendChild(option);
+    });
+    setting.value = privacy.setting;
+    setting.onchange = async () => {
+      const response = await fetch("/privacy", {
+        method: "POST",
+        headers: csrfHeaders({ "Content-Type": "application/json" }),
+        body: JSON.stringify({ setting: setting.value }),
+      });
+      if (!response.ok) {
+        alert(await response.text());
+      }
+    };
+    section.appendChild(setting);
+
+    const list = document.createElement("div");
+    list.id = "blockedList";
+    section.appendChild(list);
+    blockedUsers.forEach(showBlocked);
+    document.getElementById("userList").appendChild(section);
+  }
+
+  function showBlocked(username) {
+    const list = document.getElementById("blockedList");
+    if (!list) {
+      return;
+    }
+    const item = document.createElement("div");
+    item.classList.add("blocked-user");
+    item.textContent = username;
+    const unblock = document.createElement("button");
+    unblock.classList.add("message-action");
+    unblock.textContent = "unblock";
+    unblock.onclick = async () => {
+      const response = await fetch("/unblock", {
+        method: "POST",
+        headers: csrfHeaders({ "Content-Type": "application/json" }),
+        body: JSON.stringify({ username }),
+      });
+      if (!response.ok) {
+        alert(await response.text());
+        return;
+      }
+      // They are listed again after the next reload or when they come online
+      blockedUsers.delete(username);
+      item.remove();
+    };
+    item.appendChild(unblock);
+    list.appendChild(item);
+  }
+
+  async function blockUser(username) {
+    if (!confirm(`Block ${username}? Neither of you will be able to message the other.`)) {
+      return;
+    }
+    const response = await fetch("/block", {
+      method: "POST",
+      headers: csrfHeaders({ "Content-Type": "application/json" }),
+      body: JSON.stringify({ username }),
+    });
+    if (!response.ok) {
+      alert(await response.text());
+      return;
+    }
+    blockedUsers.add(username);
+    showBlocked(username);
+    const userItem = userListItem(username);
+    if (userItem) {
+      userItem.remove();
+    }
+    document.getElementById("chatbox").classList.remove("expanded");
+  }
+
+  document.getElementById("blockUser").addEventListener("click", () => {
+    if (currentChatUsername && !currentConversation) {
+      blockUser(currentChatUsername);
+    }
+  });
+
   // Function to initiate chat with a user
+  // Searches posts, comments and our private messages
+  function createSearchForm(games) {
+    const searchForm = document.createElement("form");
+    searchForm.className = "search";
+    const searchInput = document.createElement("input");
+    searchInput.type = "search";
+    searchInput.placeholder = 'Search, "a phrase" or word*';
+    searchInput.className = "border rounded p-2 m-1";
+    const typeSelect = document.createElement("select");
+    typeSelect.className = "border rounded p-2 m-1";
+    [
+      ["", "Everything"],
+      ["post", "Posts"],
+      ["comment", "Comments"],
+      ["message", "Messages"],
+    ].forEach(([value, text]) => typeSelect.add(new Option(text, value)));
+    const categorySelect = document.createElement("select");
+    categorySelect.className = "border rounded p-2 m-1";
+    categorySelect.add(new Option("All games", ""));
+    games.forEach((game) => categorySelect.add(new Option(game.text, game.value)));
+    const authorInput = document.createElement("input");
+    authorInput.placeholder = "Author";
+    authorInput.className = "border rounded p-2 m-1";
+    const searchBtn = document.createElement("button");
+    searchBtn.type = "submit";
+    searchBtn.className =
+      "font-bold bg-blue-300 hover:bg-blue-400 border rounded p-2 m-1 transition duration-500";
+    searchBtn.textContent = "Search";
+    const results = document.createElement("div");
+    results.id = "searchResults";
+
+    let page = 1;
+    async function search() {
+      const params = new URLSearchParams({ q: searchInput.value, page });
+      if (typeSelect.value) params.set("type", typeSelect.value);
+      if (categorySelect.value) params.set("category", categorySelect.value);
+      if (authorInput.value.trim()) params.set("author", authorInput.value.trim());
+      const response = await fetch(`/search?${params}`);
+      if (!response.ok) {
+        alert(await response.text());
+        return;
+      }
+      const found = await response.json();
+      if (page === 1) {
+        results.innerHTML = "";
+        if (found.results.length === 0) {
+          results.textContent = "Nothing found.";
+        }
+      }
+      const oldMoreBtn = results.querySelector(".search-more");
+      if (oldMoreBtn) {
+        oldMoreBtn.remove();
+      }
+      found.results.forEach((result) => results.appendChild(searchResult(result)));
+      if (found.more) {
+        const moreBtn = document.createElement("button");
+        moreBtn.type = "button";
+        moreBtn.className = "search-more";
+        moreBtn.textContent = "More results";
+        moreBtn.onclick = () => {
+          page++;
+          search();
+        };
+        results.appendChild(moreBtn);
+      }
+    }
+    searchForm.addEventListener("submit", (event) => {
+      event.preventDefault();
+      if (searchInput.value.trim()) {
+        page = 1;
+        search();
+      }
+    });
+
+    searchForm.append(searchInput, typeSelect, categorySelect, authorInput, searchBtn, results);
+    return searchForm;
+  }
+
+  function searchResult(result) {
+    const item = document.createElement("div");
+    item.className = "search-result";
+    const heading = document.createElement("div");
+    heading.className = "search-heading";
+    heading.textContent =
+      result.kind === "message"
+        ? `Message with ${result.with} · ${formatDate(result.createdAt)}`
+        : `${result.kind === "post" ? "Post" : "Comment"} by ${result.author} · ${formatDate(result.createdAt)}`;
+    const snippet = document.createElement("div");
+    // The server escapes snippets and only adds <mark> around matches
+    snippet.innerHTML = result.snippet;
+    item.append(heading, snippet);
+    item.onclick = () => {
+      if (result.kind === "message") {
+        initiateChat(result.with);
+        return;
+      }
+      const postDiv = document.getElementById(`post${result.postId}`);
+      if (postDiv) {
+        postDiv.scrollIntoView({ behavior: "smooth" });
+      }
+    };
+    return item;
+  }
+
   function initiateChat(nickname) {
     currentChatUsername = nickname;
+    currentConversation = null;
+    // Opening the conversation marks its messages read
+    const userItem = userListItem(nickname);
+    if (userItem) {
+      setUnread(userItem, 0);
+    }
     console.log("Initiating chat with username:", nickname);
-    setupWebSocket(data.Username);
-    // Call with 1 to load the first page of messages
-    getMessagesFromServer(1);
-    currentPage = 1;
+    setupWebSocket();
+    // Load the newest messages first
+    getMessagesFromServer();
     allMessagesLoaded = false;
     const chatHeaderUsername = document.getElementById("chat-header-username"); 
     chatHeaderUsername.textContent = `Chat with ${nickname}`;
+    document.getElementById("blockUser").style.display = "";
+    showTypists();
   }
   const toggleUserListBtn = document.getElementById("toggleUserListBtn");
   const userList = document.getElementById("userList"); 
@@ -502,8 +1169,27 @@ export async function mainPage(data) {
 
   // Establish a WebSocket connection when the user navigates to the chat
   let socket = null;
-  function setupWebSocket(username) {
-    socket = new WebSocket(`ws://localhost:8080/ws?username=${username}`);
+  // Frames are envelopes of this protocol version, see "Chat protocol" in the README.
+  const protocolVersion = 1;
+  let frameCounter = 0;
+  // Sent messages waiting for their ack, by frame id
+  const pendingMessages = {};
+  // Edits and deletes waiting for their ack, by frame id
+  const pendingChanges = {};
+  // How long we may change our private messages, from the server
+  let editWindow = 0;
+  // The largest file we may attach, in bytes
+  let attachmentMaxSize = 0;
+  async function setupWebSocket() {
+    // One connection serves every conversation
+    if (socket && socket.readyState !== WebSocket.CLOSED) {
+      return;
+    }
+    // The server knows the public websocket address, e.g. wss:// behind HTTPS.
+    const config = await fetch("/client-config").then((response) => response.json());
+    editWindow = config.editWindowSeconds * 1000;
+    attachmentMaxSize = config.attachmentMaxSize;
+    socket = new WebSocket(config.websocketURL);
 
     socket.onopen = function (e) {
       console.log("[open] Connection established");
@@ -512,18 +1198,53 @@ export async function mainPage(data) {
     socket.onmessage = function (event) {
       // console.log(`[message] Data received from server: ${event.data}`);
       try {
-        const data = JSON.parse(event.data);
-
-        if (data.type === "message") {
-          // Handle message data
-          //try to reverse here
-          displayMessages(data.messages.reverse()); 
-        } else if (data.type === "userlist") {
-          // Handle user list data
-          updateUserList(data.userlist);
-        } else if (data.type == "status") {
+        const frame = JSON.parse(event.data);
+        const payload = frame.payload || {};
+
+        if (frame.type === "message") {
+          receiveMessage(payload);
+        } else if (frame.type === "presence") {
           // console.log("[UPDATE]: Updating userlist status ");
-          updateUserStatus(data.username, data.online, data.userid);
+          updateUserStatus(
+            payload.username,
+            payload.online,
+            payload.userId,
+            payload.lastSeen
+          );
+        } else if (frame.type === "presence_snapshot") {
+          payload.users.forEach((user) =>
+            updateUserStatus(user.username, true, user.userId)
+          );
+        } else if (frame.type === "typing") {
+          if (payload.typing) {
+            typists.add(payload.from);
+          } else {
+            typists.delete(payload.from);
+          }
+          showTypists();
+        } else if (frame.type === "edit" || frame.type === "delete") {
+          updateMessage(payload.message);
+        } else if (frame.type === "conversation") {
+          addConversation(payload);
+        } else if (frame.type === "reply") {
+          showReplyNotification(payload);
+        } else if (frame.type === "read") {
+          markSeen(payload.with, payload.messageId);
+        } else if (frame.type === "delivered") {
+          markDelivered(payload.with, payload.messageId);
+        } else if (frame.type === "ack") {
+          delete pendingMessages[frame.id];
+          delete pendingChanges[frame.id];
+        } else if (frame.type === "error") {
+          console.error(`[error] ${payload.code}: ${payload.message}`);
+          if (frame.id in pendingMessages) {
+            alert(`Message not sent: ${payload.message}`);
+            delete pendingMessages[frame.id];
+          }
+          if (frame.id in pendingChanges) {
+            alert(`Message not changed: ${payload.message}`);
+            delete pendingChanges[frame.id];
+          }
         }
       } catch (e) {
         console.error("Error parsing JSON:", e);
@@ -555,21 +1276,371 @@ export async function mainPage(data) {
     if (message) {
       sendMessageToServer(message);
       messageInput.value = ""; // Clear the input after sending
+      // The server ends our typing indicator when the message arrives
+      typingTo = null;
+      clearTimeout(typingStopTimer);
+    }
+  });
+
+  // Files are uploaded first and then sent as a message carrying the
+  // attachment, with whatever was typed as its text.
+  const attachmentInput = document.getElementById("attachmentInput");
+  document.getElementById("attachFile").addEventListener("click", () => {
+    attachmentInput.click();
+  });
+  attachmentInput.addEventListener("change", async () => {
+    const file = attachmentInput.files[0];
+    attachmentInput.value = "";
+    if (!file) {
+      return;
+    }
+    if (attachmentMaxSize && file.size > attachmentMaxSize) {
+      alert(`Files may have at most ${formatSize(attachmentMaxSize)}.`);
+      return;
+    }
+    const form = new FormData();
+    form.append("file", file);
+    const response = await fetch("/attachments", {
+      method: "POST",
+      headers: csrfHeaders(),
+      body: form,
+    });
+    if (!response.ok) {
+      alert(`File not sent: ${await response.text()}`);
+      return;
+    }
+    const attachment = await response.json();
+    const messageInput = document.getElementById("messageInput");
+    sendMessageToServer(messageInput.value.trim(), attachment.id);
+    messageInput.value = "";
+  });
+
+  function formatSize(bytes) {
+    if (bytes >= 1 << 20) {
+      return `${(bytes / (1 << 20)).toFixed(1)} MB`;
+    }
+    if (bytes >= 1 << 10) {
+      return `${Math.round(bytes / (1 << 10))} KB`;
+    }
+    return `${bytes} B`;
+  }
+
+  // While the user types we repeat a typing frame every few seconds; the
+  // server announces a stop by itself when they go quiet.
+  const typingRepeat = 2000;
+  const typingIdle = 3000;
+  let typingTo = null;
+  let typingSentAt = 0;
+  let typingStopTimer = null;
+  // Users currently typing to us
+  const typists = new Set();
+
+  document.getElementById("messageInput").addEventListener("input", () => {
+    if (!socket || socket.readyState !== WebSocket.OPEN || !currentChatUsername) {
+      return;
+    }
+    if (typingTo !== currentChatUsername) {
+      stopTyping();
     }
+    const now = Date.now();
+    if (typingTo === null || now - typingSentAt > typingRepeat) {
+      sendFrame("typing", { to: currentChatUsername, typing: true });
+      typingTo = currentChatUsername;
+      typingSentAt = now;
+    }
+    clearTimeout(typingStopTimer);
+    typingStopTimer = setTimeout(stopTyping, typingIdle);
   });
 
-  function sendMessageToServer(message) {
-    var senderusername = data.Username;
+  function stopTyping() {
+    clearTimeout(typingStopTimer);
+    if (typingTo !== null) {
+      sendFrame("typing", { to: typingTo, typing: false });
+      typingTo = null;
+    }
+  }
+
+  function showTypists() {
+    const typingIndicator = document.getElementById("typingIndicator");
+    typingIndicator.textContent = typists.has(currentChatUsername)
+      ? `${currentChatUsername} is typing…`
+      : "";
+  }
+
+  function sendMessageToServer(message, attachment = 0) {
     var receiverusername = currentChatUsername;
 
     // Here we send the message through the WebSocket instead of using fetch
-    if (socket.readyState === WebSocket.OPEN) {
-      socket.send(
-        JSON.stringify({ message, senderusername, receiverusername })
+    const id = currentConversation
+      ? sendFrame("send", { conversation: currentConversation, content: message, attachment })
+      : sendFrame("send", { to: receiverusername, content: message, attachment });
+    if (id) {
+      pendingMessages[id] = message;
+    }
+  }
+
+  // Sends a frame over the websocket and returns its id
+  function sendFrame(type, payload) {
+    if (!socket || socket.readyState !== WebSocket.OPEN) {
+      console.error("WebSocket is not open. Cannot send message.");
+      return null;
+    }
+    const id = `m${++frameCounter}`;
+    socket.send(
+      JSON.stringify({ type, id, version: protocolVersion, payload })
+    );
+    return id;
+  }
+
+  // A message was stored in one of our conversations, sent by us or to us
+  function receiveMessage(payload) {
+    if (payload.conversation) {
+      receiveConversationMessage(payload);
+      return;
+    }
+    const incoming = payload.from !== data.Username;
+    const other = incoming ? payload.from : payload.to;
+    if (incoming) {
+      // Tell the server, and through it the sender, that it arrived
+      sendFrame("delivered", { messageId: payload.message.id });
+      typists.delete(other);
+      showTypists();
+    }
+    const chatOpen = document
+      .getElementById("chatbox")
+      .classList.contains("expanded");
+    if (other === currentChatUsername) {
+      displayMessages([payload.message], true);
+    }
+    if (incoming && other === currentChatUsername && chatOpen) {
+      sendFrame("read", { with: other, messageId: payload.message.id });
+    } else if (incoming && !payload.replayed) {
+      // The user list counts replayed messages already
+      const userItem = userListItem(other);
+      if (userItem) {
+        setUnread(userItem, Number(userItem.dataset.unread || 0) + 1);
+      }
+    }
+    moveUserToTop(other);
+  }
+
+  // A message was posted to one of our groups or channels
+  function receiveConversationMessage(payload) {
+    const chatOpen = document
+      .getElementById("chatbox")
+      .classList.contains("expanded");
+    const incoming = payload.from !== data.Username;
+    if (payload.conversation === currentConversation) {
+      displayMessages([payload.message], true);
+    }
+    if (incoming && payload.conversation === currentConversation && chatOpen) {
+      sendFrame("read", {
+        conversation: payload.conversation,
+        messageId: payload.message.id,
+      });
+    } else if (incoming) {
+      const item = conversationItem(payload.conversation);
+      if (item) {
+        setUnread(item, Number(item.dataset.unread || 0) + 1);
+      }
+    }
+    const item = conversationItem(payload.conversation);
+    if (item) {
+      item.parentNode.insertBefore(item, item.parentNode.firstChild);
+    }
+  }
+
+  // The other side read our messages up to messageId
+  function markSeen(username, messageId) {
+    if (username !== currentChatUsername) {
+      return;
+    }
+    document.querySelectorAll(".message-wrapper.right").forEach((wrapper) => {
+      if (Number(wrapper.dataset.messageId) <= messageId) {
+        showSeen(wrapper);
+      }
+    });
+  }
+
+  function showSeen(messageWrapper) {
+    const status = messageWrapper.querySelector(".message-status");
+    if (status) {
+      status.textContent = " · seen";
+    }
+  }
+
+  // Our message messageId reached username
+  function markDelivered(username, messageId) {
+    if (username !== currentChatUsername) {
+      return;
+    }
+    const messageWrapper = document.getElementById(`message-${messageId}`);
+    const status = messageWrapper && messageWrapper.querySelector(".message-status");
+    if (status && status.textContent !== " · seen") {
+      status.textContent = " · delivered";
+    }
+  }
+
+  // How far our private messages got
+  function messageStatus(message) {
+    if (message.readAt) {
+      return " · seen";
+    }
+    return message.status === "delivered" ? " · delivered" : " · sent";
+  }
+
+  // Private messages and those of groups are numbered separately
+  function messageElementId(message) {
+    return message.conversation
+      ? `message-${message.conversation}-${message.id}`
+      : `message-${message.id}`;
+  }
+
+  // Messages of groups and channels show who sent them
+  function messageHeading(message) {
+    const sentAt = formatDate(message.timestamp);
+    if (message.conversation && message.sender !== data.UsernameId.toString()) {
+      return `${message.senderName} · ${sentAt}`;
+    }
+    return sentAt;
+  }
+
+  function messageElement(message) {
+    const messageWrapper = document.createElement("div");
+    messageWrapper.id = messageElementId(message);
+    messageWrapper.dataset.messageId = message.id;
+    messageWrapper.classList.add(
+      "message-wrapper",
+      message.sender === data.UsernameId.toString() ? "right" : "left"
+    );
+    fillMessage(messageWrapper, message);
+    return messageWrapper;
+  }
+
+  // Shows message in its wrapper, again after it was edited or deleted
+  function fillMessage(messageWrapper, message) {
+    const own = message.sender === data.UsernameId.toString();
+    messageWrapper.innerHTML = "";
+
+    const timestampDiv = document.createElement("div");
+    timestampDiv.classList.add("message-timestamp");
+    const timeSpan = document.createElement("span");
+    timeSpan.classList.add("message-time");
+    timeSpan.textContent = messageHeading(message);
+    if (message.editedAt && !message.deletedAt) {
+      timeSpan.textContent += " · edited";
+    }
+    timestampDiv.appendChild(timeSpan);
+    if (own && !message.conversation) {
+      const statusSpan = document.createElement("span");
+      statusSpan.classList.add("message-status");
+      statusSpan.textContent = messageStatus(message);
+      timestampDiv.appendChild(statusSpan);
+    }
+
+    const contentDiv = document.createElement("div");
+    contentDiv.classList.add("message-content");
+    if (message.deletedAt) {
+      // A tombstone
+      contentDiv.classList.add("message-deleted");
+      contentDiv.textContent = "Message deleted";
+    } else {
+      showRendered(contentDiv, message.contentHtml);
+      if (message.attachment) {
+        contentDiv.appendChild(attachmentElement(message.attachment));
+      }
+    }
+
+    messageWrapper.appendChild(timestampDiv);
+    messageWrapper.appendChild(contentDiv);
+
+    // Private messages can be changed by their author for a while
+    const changeable =
+      own &&
+      !message.conversation &&
+      !message.deletedAt &&
+      Date.now() - Date.parse(message.timestamp) < editWindow;
+    if (changeable) {
+      timestampDiv.appendChild(
+        messageAction("edit", () => {
+          const content = prompt("Edit message", message.content);
+          if (content && content.trim()) {
+            changeMessage("edit", { messageId: message.id, content });
+          }
+        })
       );
+      timestampDiv.appendChild(
+        messageAction("delete", () => {
+          if (confirm("Delete this message?")) {
+            changeMessage("delete", { messageId: message.id });
+          }
+        })
+      );
+    }
+    // Moderators can see what changed messages said
+    const moderator = data.Role == "moderator" || data.Role == "admin";
+    if (moderator && !message.conversation && (message.editedAt || message.deletedAt)) {
+      timestampDiv.appendChild(
+        messageAction("original", () => showRevisions(message.id))
+      );
+    }
+  }
+
+  // Images show their thumbnail, other files a download link
+  function attachmentElement(attachment) {
+    const link = document.createElement("a");
+    link.classList.add("message-attachment");
+    link.href = `/attachment?id=${attachment.id}`;
+    link.target = "_blank";
+    link.rel = "noopener";
+    if (attachment.thumbnail) {
+      const img = document.createElement("img");
+      img.src = `/attachment?id=${attachment.id}&thumbnail=1`;
+      img.alt = attachment.name;
+      link.appendChild(img);
     } else {
-      console.error("WebSocket is not open. Cannot send message.");
+      link.textContent = `${attachment.name} (${formatSize(attachment.size)})`;
     }
+    return link;
+  }
+
+  function messageAction(label, onclick) {
+    const button = document.createElement("button");
+    button.classList.add("message-action");
+    button.textContent = label;
+    button.onclick = onclick;
+    return button;
+  }
+
+  function changeMessage(type, payload) {
+    const id = sendFrame(type, payload);
+    if (id) {
+      pendingChanges[id] = true;
+    }
+  }
+
+  // An edit or delete pushed by the server
+  function updateMessage(message) {
+    const messageWrapper = document.getElementById(messageElementId(message));
+    if (messageWrapper) {
+      fillMessage(messageWrapper, message);
+    }
+  }
+
+  async function showRevisions(id) {
+    const response = await fetch(`/message-revisions?id=${id}`);
+    if (!response.ok) {
+      alert(await response.text());
+      return;
+    }
+    const history = await response.json();
+    const lines = history.revisions.map(
+      (revision) => `${formatDate(revision.replacedAt)}: ${revision.content}`
+    );
+    lines.push(
+      `${history.message.deletedAt ? "Deleted" : "Now"}: ${history.message.content}`
+    );
+    alert(lines.join("\n"));
   }
 
   function displayMessages(messages, append = false) {
@@ -583,24 +1654,11 @@ export async function mainPage(data) {
       messagesContainer.scrollHeight;
 
     messages.forEach((message) => {
-      const messageWrapper = document.createElement("div");
-
-      messageWrapper.classList.add(
-        "message-wrapper",
-        message.sender === data.UsernameId.toString() ? "right" : "left"
-      );
-
-      const timestampDiv = document.createElement("div");
-      timestampDiv.classList.add("message-timestamp");
-      timestampDiv.textContent = formatDate(message.timestamp);
-
-      const contentDiv = document.createElement("div");
-      contentDiv.classList.add("message-content");
-      contentDiv.textContent = message.content;
-
-      messageWrapper.appendChild(timestampDiv);
-      messageWrapper.appendChild(contentDiv);
-
+      // Messages can arrive twice, e.g. both as history and pushed over the socket
+      if (document.getElementById(messageElementId(message))) {
+        return;
+      }
+      const messageWrapper = messageElement(message);
       messagesContainer.appendChild(messageWrapper);
       if (shouldScrollToBottom) {
         messagesContainer.scrollTop = messagesContainer.scrollHeight;
@@ -610,20 +1668,22 @@ export async function mainPage(data) {
     messagesContainer.scrollTop = messagesContainer.scrollHeight;
   }
 
-  async function getMessagesFromServer(page = 1) {
-    const senderusername = data.Username;
-    const receiverusername = currentChatUsername;
+  // The URL of a page of the open conversation
+  function messagesURL(before = "") {
+    const cursor = before ? `&before=${encodeURIComponent(before)}` : "";
+    if (currentConversation) {
+      return `/conversations/messages?conversation=${currentConversation}${cursor}&limit=${pageSize}`;
+    }
+    return `/get-message?receiverusername=${encodeURIComponent(
+      currentChatUsername
+    )}${cursor}&limit=${pageSize}`;
+  }
 
-    // const url = `/get-message?senderusername=${encodeURIComponent(senderusername)}&receiverusername=${encodeURIComponent(receiverusername)}&page=${page}`;
-    const limit = 10;
-    const offset = (page - 1) * limit;
+  async function getMessagesFromServer() {
+    const receiverusername = currentChatUsername;
+    const conversation = currentConversation;
 
-    const url = `/get-message?senderusername=${encodeURIComponent(
-      senderusername
-    )}&receiverusername=${encodeURIComponent(
-      receiverusername
-    )}&limit=${limit}&offset=${offset}`;
-    page = page || currentPage;
+    const url = messagesURL();
 
     try {
       const response = await fetch(url, {
@@ -637,12 +1697,17 @@ export async function mainPage(data) {
         throw new Error("Network response was not ok");
       }
 
-      const privateMessages = await response.json();
-      if (page === 1) {
-        // Clear previous messages only if it's the first page
-        const messagesContainer = document.getElementById("messages");
-        messagesContainer.innerHTML = "";
+      const page = await response.json();
+      const privateMessages = page.messages;
+      // Another conversation may have been opened meanwhile
+      if (receiverusername !== currentChatUsername || conversation !== currentConversation) {
+        return;
       }
+      const messagesContainer = document.getElementById("messages");
+      messagesContainer.innerHTML = "";
+      // The server only sets the cursor when there are older messages
+      olderMessagesCursor = page.before || "";
+      allMessagesLoaded = !page.before;
 
       // Display the fetched messages
       displayMessages(privateMessages.reverse(), true); // Pass 'true' to append messages
@@ -654,7 +1719,9 @@ export async function mainPage(data) {
     }
   }
 
-  let currentPage = 1; 
+  const pageSize = 10;
+  // Older messages are loaded from before this cursor
+  let olderMessagesCursor = "";
   let loadingMessages = false; 
   let allMessagesLoaded = false; 
 
@@ -667,15 +1734,7 @@ export async function mainPage(data) {
 
     loadingMessages = true;
 
-    const senderusername = data.Username;
-    const receiverusername = currentChatUsername;
-    currentPage++; // Increment page to load the next set of messages
-
-    const url = `/get-message?senderusername=${encodeURIComponent(
-      senderusername
-    )}&receiverusername=${encodeURIComponent(
-      receiverusername
-    )}&page=${currentPage}`;
+    const url = messagesURL(olderMessagesCursor);
 
     try {
       const response = await fetch(url, {
@@ -689,11 +1748,11 @@ export async function mainPage(data) {
         throw new Error("Network response was not ok");
       }
 
-      const moreMessages = await response.json();
+      const page = await response.json();
+      const moreMessages = page.messages;
 
-      if (moreMessages && moreMessages.length < 10) {
-        allMessagesLoaded = true;
-      }
+      olderMessagesCursor = page.before || "";
+      allMessagesLoaded = !page.before;
 
       prependMessages(moreMessages); // Add new messages to the top of the chat
     } catch (error) {
@@ -708,22 +1767,10 @@ export async function mainPage(data) {
     let oldScrollHeight = messagesContainer.scrollHeight;
 
     messages.forEach((message) => {
-      const messageWrapper = document.createElement("div");
-      messageWrapper.classList.add(
-        "message-wrapper",
-        message.sender === data.UsernameId.toString() ? "right" : "left"
-      );
-      const timestampDiv = document.createElement("div");
-      timestampDiv.classList.add("message-timestamp");
-      timestampDiv.textContent = formatDate(message.timestamp);
-
-      const contentDiv = document.createElement("div");
-      contentDiv.classList.add("message-content");
-      contentDiv.textContent = message.content;
-
-      // Append children in the same order as displayMessages
-      messageWrapper.appendChild(timestampDiv);
-      messageWrapper.appendChild(contentDiv);
+      if (document.getElementById(messageElementId(message))) {
+        return;
+      }
+      const messageWrapper = messageElement(message);
 
       // Prepend the message wrapper to the messages container
       messagesContainer.insertBefore(
@@ -738,7 +1785,6 @@ export async function mainPage(data) {
   }
 
   initiateChat(currentChatUsername);
-  getMessagesFromServer(1);
   // Throttle function to limit the rate at which a function can fire
   function throttle(func, limit) {
     let lastFunc;
@@ -779,6 +1825,8 @@ export async function mainPage(data) {
   toggleUserListBtn.textContent = "Show User List";
 
   createUserList();
+  // The sections are added one after the other, in this order
+  createConversationList().then(createPrivacyControls);
 
   // If no posts, display a message
   if (!data.Posts || data.Posts.length === 0) {
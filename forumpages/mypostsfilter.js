@@ -1,13 +1,14 @@
 import { mainPage } from "./mainpage.js";
+import { csrfHeaders } from "/static/csrf.js";
 
 export function fetchMyPosts(myposts) {
     console.log("[start fetch]")
     fetch('/myposts', {
         method: 'POST', // or GET with query parameters
         body: JSON.stringify({ myposts: myposts }),
-        headers: {
+        headers: csrfHeaders({
             'Content-Type': 'application/json',
-        },
+        }),
     })
     .then(response => response.json())
     .then(data => {
@@ -1,4 +1,5 @@
 import { RegistrationComplete } from "./registered.js";
+import { csrfHeaders } from "/static/csrf.js";
 
 var data = null;
 
@@ -90,9 +91,9 @@ export async function loadRegistrationForm() {
     try {
       const response = await fetch("/register", {
         method: "POST",
-        headers: {
+        headers: csrfHeaders({
           "Content-Type": "application/json",
-        },
+        }),
         body: json,
       });
 
@@ -131,9 +132,9 @@ export async function loginHandler() {
   try {
     const response = await fetch("/login", {
       method: "POST",
-      headers: {
+      headers: csrfHeaders({
         "Content-Type": "application/json",
-      },
+      }),
       body: JSON.stringify({
         "login-username": username,
         "login-password": password,
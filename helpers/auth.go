@@ -0,0 +1,102 @@
+package helpers
+
+import (
+	"context"
+	"forum/store"
+	"log"
+	"net/http"
+)
+
+// Policy is the least privileged role that may use a route. The roles are
+// ranked in the roles table, so a moderator route is also open to admins.
+type Policy string
+
+const (
+	Guest     Policy = "guest"
+	Member    Policy = "user"
+	Moderator Policy = "moderator"
+	Admin     Policy = "admin"
+)
+
+type contextKey int
+
+const userKey contextKey = iota
+
+// Authenticate looks up the user of the session cookie and puts it into the
+// request context. Requests without a valid session pass through as guests.
+func Authenticate(users store.UserStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := currentSession(w, r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := users.ByUsername(session.Username)
+		if err == store.ErrNotFound {
+			// The account is gone, so is the session.
+			sessions.Delete(session.Token)
+			next.ServeHTTP(w, r)
+			return
+		} else if err != nil {
+			log.Println("Failed to get session user:", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey, &user)))
+	})
+}
+
+// CurrentUser returns the user put into the context by Authenticate, or nil
+// for guests.
+func CurrentUser(r *http.Request) *store.User {
+	user, _ := r.Context().Value(userKey).(*store.User)
+	return user
+}
+
+// Require lets the request through only when the current user's role ranks at
+// least as high as policy. Guests get 401 and users without the role 403.
+func Require(policy Policy, roles store.RoleStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if policy == Guest {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user := CurrentUser(r)
+		if user == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		allowed, err := RoleAllows(roles, user.Role, policy)
+		if err != nil {
+			log.Println("Failed to check role:", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RoleAllows reports whether role ranks at least as high as policy. Unknown
+// roles are allowed nothing.
+func RoleAllows(roles store.RoleStore, role string, policy Policy) (bool, error) {
+	have, err := roles.Rank(role)
+	if err == store.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	need, err := roles.Rank(string(policy))
+	if err != nil {
+		return false, err
+	}
+	return have >= need, nil
+}
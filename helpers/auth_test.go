@@ -0,0 +1,105 @@
+package helpers
+
+import (
+	"forum/migrations"
+	"forum/store"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveAs requests a route behind Authenticate and Require as the user with
+// the session token, or as a guest when it is empty, and returns the status.
+func serveAs(st *store.Store, policy Policy, token string) int {
+	route := Authenticate(st.Users, Require(policy, st.Roles, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	}
+	w := httptest.NewRecorder()
+	route.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestRequire(t *testing.T) {
+	st := store.NewMemory()
+	sessionStore := useSessions(t, DefaultSessionConfig)
+	for _, role := range []string{"user", "moderator", "admin", "wizard"} {
+		if _, err := st.Users.Create(store.User{Username: role, Email: role + "@example.com", Role: role}); err != nil {
+			t.Fatal(err)
+		}
+		sessionStore.Create(store.Session{Token: role, Username: role, Expiry: time.Now().Add(time.Hour)})
+	}
+
+	const ok, unauthorized, forbidden = http.StatusOK, http.StatusUnauthorized, http.StatusForbidden
+	tests := []struct {
+		role string
+		// want is the status for the Guest, Member, Moderator and Admin
+		// policies.
+		want [4]int
+	}{
+		{"", [4]int{ok, unauthorized, unauthorized, unauthorized}},
+		{"user", [4]int{ok, ok, forbidden, forbidden}},
+		{"moderator", [4]int{ok, ok, ok, forbidden}},
+		{"admin", [4]int{ok, ok, ok, ok}},
+		// Roles missing from the roles table are allowed nothing.
+		{"wizard", [4]int{ok, forbidden, forbidden, forbidden}},
+	}
+	for _, tt := range tests {
+		for i, policy := range []Policy{Guest, Member, Moderator, Admin} {
+			if got := serveAs(st, policy, tt.role); got != tt.want[i] {
+				t.Errorf("role %q on a %s route: status %d, want %d", tt.role, policy, got, tt.want[i])
+			}
+		}
+	}
+
+	// A session whose user is gone is a guest.
+	sessionStore.Create(store.Session{Token: "ghost", Username: "ghost", Expiry: time.Now().Add(time.Hour)})
+	if got := serveAs(st, Member, "ghost"); got != unauthorized {
+		t.Errorf("session of a missing user: status %d", got)
+	}
+}
+
+// TestRequireUnknownRole checks that users whose role predates the roles
+// table fall back to members once it is added.
+func TestRequireUnknownRole(t *testing.T) {
+	db, err := store.OpenSQLite(filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := migrations.Up(db); err != nil {
+		if strings.Contains(err.Error(), "FTS5") {
+			t.Skip("SQLite lacks FTS5, run the tests with -tags sqlite_fts5")
+		}
+		t.Fatal(err)
+	}
+	for {
+		m, err := migrations.Down(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Version == 4 {
+			break
+		}
+	}
+	if _, err := db.Exec("INSERT INTO users (username, password, email, role, age) VALUES ('old', '', 'old@example.com', 'wizard', 0);"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewSQLite(db)
+	sessionStore := useSessions(t, DefaultSessionConfig)
+	sessionStore.Create(store.Session{Token: "old", Username: "old", Expiry: time.Now().Add(time.Hour)})
+
+	if got := serveAs(st, Member, "old"); got != http.StatusOK {
+		t.Errorf("member route: status %d", got)
+	}
+	if got := serveAs(st, Moderator, "old"); got != http.StatusForbidden {
+		t.Errorf("moderator route: status %d", got)
+	}
+}
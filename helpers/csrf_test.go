@@ -0,0 +1,91 @@
+package helpers
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// multipartBody returns a multipart form holding the CSRF token as a field,
+// and its content type.
+func multipartBody(token string) (string, string) {
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	form.WriteField(csrfFormField, token)
+	file, _ := form.CreateFormFile("file", "a.txt")
+	file.Write([]byte("content"))
+	form.Close()
+	return buf.String(), form.FormDataContentType()
+}
+
+func TestCSRF(t *testing.T) {
+	// bodyRead is how much of the body the handler behind CSRF got to read.
+	var bodyRead int
+	handler := CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		bodyRead = len(data)
+	}))
+
+	// Safe requests pass and get the cookie.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	var token string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == csrfCookieName {
+			token = c.Value
+		}
+	}
+	if w.Code != http.StatusOK || token == "" {
+		t.Fatalf("GET: status %d, token %q", w.Code, token)
+	}
+
+	const formType = "application/x-www-form-urlencoded"
+	form := func(token string) string { return url.Values{csrfFormField: {token}}.Encode() }
+	multipartForm, multipartType := multipartBody(token)
+	tests := []struct {
+		name        string
+		cookie      string
+		header      string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"header", token, token, "application/json", `{}`, http.StatusOK},
+		{"form field", token, "", formType, form(token), http.StatusOK},
+		{"form field with charset", token, "", formType + "; charset=utf-8", form(token), http.StatusOK},
+		{"no token", token, "", formType, "", http.StatusForbidden},
+		{"other token", token, "forged", formType, "", http.StatusForbidden},
+		{"other form field", token, "", formType, form("forged"), http.StatusForbidden},
+		{"no cookie", "", token, "application/json", `{}`, http.StatusForbidden},
+		{"empty cookie", "", "", formType, form(""), http.StatusForbidden},
+		{"form too large", token, "", formType, form(token) + "&pad=" + strings.Repeat("x", csrfMaxFormBytes), http.StatusForbidden},
+		{"JSON field", token, "", "application/json", `{"csrf_token":"` + token + `"}`, http.StatusForbidden},
+		{"multipart field", token, "", multipartType, multipartForm, http.StatusForbidden},
+		{"multipart with header", token, token, multipartType, multipartForm, http.StatusOK},
+	}
+	for _, tt := range tests {
+		bodyRead = -1
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", tt.contentType)
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+		}
+		if tt.header != "" {
+			r.Header.Set(csrfHeaderName, tt.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		// Bodies other than forms reach the handler untouched.
+		if tt.status == http.StatusOK && !strings.HasPrefix(tt.contentType, formType) && bodyRead != len(tt.body) {
+			t.Errorf("%s: handler read %d of %d bytes", tt.name, bodyRead, len(tt.body))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package helpers
+
+import "unicode"
+
+// DiffPart is a run of text that two versions share, or that only the old
+// or the new one has.
+type DiffPart struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Diff operations.
+const (
+	DiffEqual  = "equal"
+	DiffDelete = "delete"
+	DiffInsert = "insert"
+)
+
+// maxDiffCells bounds the table of the longest common subsequence. Longer
+// changes are shown as the old text replaced by the new one.
+const maxDiffCells = 1 << 22
+
+// Diff compares two versions of a text word by word. Joining the parts
+// without the inserted ones gives old back, without the deleted ones new.
+func Diff(old string, new string) []DiffPart {
+	a, b := diffWords(old), diffWords(new)
+
+	// The common start and end need no table.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	parts := []DiffPart{}
+	add := func(op string, word string) {
+		if n := len(parts); n > 0 && parts[n-1].Op == op {
+			parts[n-1].Text += word
+		} else {
+			parts = append(parts, DiffPart{Op: op, Text: word})
+		}
+	}
+	for _, word := range a[:prefix] {
+		add(DiffEqual, word)
+	}
+
+	tail := a[len(a)-suffix:]
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(a)*len(b) > maxDiffCells {
+		for _, word := range a {
+			add(DiffDelete, word)
+		}
+		for _, word := range b {
+			add(DiffInsert, word)
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of
+		// a[i:] and b[j:].
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(a) || j < len(b) {
+			switch {
+			case i < len(a) && j < len(b) && a[i] == b[j]:
+				add(DiffEqual, a[i])
+				i++
+				j++
+			case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+				add(DiffDelete, a[i])
+				i++
+			default:
+				add(DiffInsert, b[j])
+				j++
+			}
+		}
+	}
+
+	for _, word := range tail {
+		add(DiffEqual, word)
+	}
+	return parts
+}
+
+// diffWords splits text into words and the whitespace between them.
+func diffWords(text string) []string {
+	var words []string
+	start, space := 0, false
+	for i, r := range text {
+		if i > start && unicode.IsSpace(r) != space {
+			words = append(words, text[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(r)
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
@@ -0,0 +1,129 @@
+package helpers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+)
+
+// jwks fetches and caches the signing keys of an OIDC issuer and checks
+// token signatures with them, as the oidc.KeySet of its verifier. Keys are
+// looked up by ID and fetched again when an unknown ID shows up, which is how
+// issuers rotate keys.
+type jwks struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]jose.JSONWebKey
+	fetchedAt time.Time
+}
+
+// jwksRefetchInterval limits how often tokens with unknown key IDs can make
+// us download the key set.
+const jwksRefetchInterval = time.Minute
+
+// minRSAKeyBits is the smallest RSA key tokens may be signed with.
+const minRSAKeyBits = 2048
+
+// VerifySignature checks the signature of a compact JWS and returns its
+// payload. The verifier has checked the algorithm already.
+func (j *jwks) VerifySignature(ctx context.Context, token string) ([]byte, error) {
+	jws, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token: %w", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, fmt.Errorf("token must have exactly one signature")
+	}
+	key, err := j.key(ctx, jws.Signatures[0].Header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := jws.Verify(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+	return payload, nil
+}
+
+func (j *jwks) key(ctx context.Context, kid string) (jose.JSONWebKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(j.fetchedAt) < jwksRefetchInterval {
+		return jose.JSONWebKey{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if err := j.fetch(ctx); err != nil {
+		return jose.JSONWebKey{}, err
+	}
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	return jose.JSONWebKey{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// fetch replaces the cached keys; callers must hold mu. Keys of other types
+// or uses, and keys too weak to trust, are left out.
+func (j *jwks) fetch(ctx context.Context) error {
+	j.fetchedAt = time.Now()
+
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := getJSON(ctx, j.client, j.url, &set); err != nil {
+		return fmt.Errorf("failed to get signing keys: %w", err)
+	}
+
+	keys := make(map[string]jose.JSONWebKey)
+	for _, raw := range set.Keys {
+		// One key of a type we do not know must not hide the others.
+		var key jose.JSONWebKey
+		if err := key.UnmarshalJSON(raw); err != nil || !key.IsPublic() || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		switch k := key.Key.(type) {
+		case *rsa.PublicKey:
+			if k.N.BitLen() < minRSAKeyBits {
+				continue
+			}
+		case *ecdsa.PublicKey:
+			if k.Curve != elliptic.P256() {
+				continue
+			}
+		default:
+			continue
+		}
+		keys[key.KeyID] = key
+	}
+	j.keys = keys
+	return nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,354 @@
+package helpers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"forum/store"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeProvider is an OAuth server that logs in whoever it is told to. It
+// checks PKCE like a real provider would.
+type fakeProvider struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	codes   map[string]fakeGrant
+	nextID  int
+	account ProviderIdentity
+}
+
+type fakeGrant struct {
+	challenge string
+	account   ProviderIdentity
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	f := &fakeProvider{codes: make(map[string]fakeGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		f.mu.Lock()
+		grant, ok := f.codes[r.PostFormValue("code")]
+		delete(f.codes, r.PostFormValue("code"))
+		f.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		token, _ := json.Marshal(grant.account)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"access_token": string(token), "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		var account ProviderIdentity
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")), &account); err != nil {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id": account.Subject, "email": account.Email, "name": account.Username})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeProvider) provider() Provider {
+	return &OAuth2Provider{
+		ProviderName: "fake",
+		Config: &oauth2.Config{
+			ClientID:     "client",
+			ClientSecret: "secret",
+			RedirectURL:  "http://forum.test/auth/fake/callback",
+			Endpoint:     oauth2.Endpoint{AuthURL: f.URL + "/authorize", TokenURL: f.URL + "/token"},
+		},
+		UserInfoURL:   f.URL + "/userinfo",
+		ParseUserInfo: parseGoogleUserInfo,
+	}
+}
+
+// authorize plays the user logging in to account at the provider and
+// returns the callback query the provider redirects back with.
+func (f *fakeProvider) authorize(t *testing.T, authURL string, account ProviderIdentity) url.Values {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL without PKCE: %s", authURL)
+	}
+
+	f.mu.Lock()
+	f.nextID++
+	code := fmt.Sprintf("code%d", f.nextID)
+	f.codes[code] = fakeGrant{challenge: q.Get("code_challenge"), account: account}
+	f.mu.Unlock()
+	return url.Values{"code": {code}, "state": {q.Get("state")}}
+}
+
+// authorizer is a test provider that can play a user logging in.
+type authorizer interface {
+	authorize(t *testing.T, authURL string, account ProviderIdentity) url.Values
+}
+
+// browser keeps cookies between requests to the forum handler.
+type browser struct {
+	t       *testing.T
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func (b *browser) do(method string, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for _, c := range b.cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	b.handler.ServeHTTP(rec, req)
+	for _, c := range rec.Result().Cookies() {
+		if c.Value == "" {
+			delete(b.cookies, c.Name)
+		} else {
+			b.cookies[c.Name] = c
+		}
+	}
+	return rec
+}
+
+// login runs the whole OAuth flow started at path and returns the callback
+// response.
+func (b *browser) login(f authorizer, method string, path string, account ProviderIdentity) *httptest.ResponseRecorder {
+	rec := b.do(method, path)
+	if rec.Code != http.StatusSeeOther {
+		b.t.Fatalf("%s %s: got %d, want redirect to provider", method, path, rec.Code)
+	}
+	callback := f.authorize(b.t, rec.Header().Get("Location"), account)
+	return b.do(http.MethodGet, "/auth/fake/callback?"+callback.Encode())
+}
+
+func newOAuthTest(t *testing.T) (*store.Store, *fakeProvider, *browser) {
+	f := newFakeProvider(t)
+	st, b := newOAuthBrowser(t, f.provider())
+	return st, f, b
+}
+
+// newOAuthBrowser serves the OAuth routes of provider, which must be named
+// "fake".
+func newOAuthBrowser(t *testing.T, provider Provider) (*store.Store, *browser) {
+	st := store.NewMemory()
+	// Tests log in to the same account from several browsers.
+	sessionsConfig := DefaultSessionConfig
+	sessionsConfig.MultipleLogins = true
+	ConfigureSessions(st.Sessions, sessionsConfig)
+	o := NewOAuth(st.Users, st.Identities, provider)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/fake", func(w http.ResponseWriter, r *http.Request) { o.Login(w, r, "fake") })
+	mux.HandleFunc("/auth/fake/callback", func(w http.ResponseWriter, r *http.Request) { o.Callback(w, r, "fake") })
+	mux.Handle("/auth/fake/link", Require(Member, st.Roles, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { o.Link(w, r, "fake") })))
+	mux.Handle("/auth/fake/unlink", Require(Member, st.Roles, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { o.Unlink(w, r, "fake") })))
+
+	return st, &browser{t: t, handler: Authenticate(st.Users, mux), cookies: make(map[string]*http.Cookie)}
+}
+
+func (b *browser) sessionUser(st *store.Store) string {
+	c, ok := b.cookies["session_token"]
+	if !ok {
+		return ""
+	}
+	session, err := st.Sessions.Get(c.Value)
+	if err != nil {
+		return ""
+	}
+	return session.Username
+}
+
+func TestOAuthLoginSignsUpOnceBySubject(t *testing.T) {
+	st, f, b := newOAuthTest(t)
+	john := ProviderIdentity{Subject: "1", Email: "john@example.com", Username: "John Smith"}
+
+	rec := b.login(f, http.MethodGet, "/auth/fake", john)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("callback: got %d %s", rec.Code, rec.Body)
+	}
+	if got := b.sessionUser(st); got != "John Smith" {
+		t.Fatalf("logged in as %q, want John Smith", got)
+	}
+
+	// Logging in again finds the same account.
+	b.login(f, http.MethodGet, "/auth/fake", john)
+	identities, _ := st.Identities.ForUser(1)
+	if len(identities) != 1 || identities[0].Subject != "1" {
+		t.Fatalf("identities of user 1 = %+v", identities)
+	}
+	if _, err := st.Users.ByID(2); err != store.ErrNotFound {
+		t.Fatalf("second login created another user")
+	}
+
+	// Another account with the same display name gets its own user.
+	other := &browser{t: t, handler: b.handler, cookies: make(map[string]*http.Cookie)}
+	other.login(f, http.MethodGet, "/auth/fake", ProviderIdentity{Subject: "2", Email: "js@example.com", Username: "John Smith"})
+	if got := other.sessionUser(st); got != "John Smith2" {
+		t.Fatalf("second John Smith logged in as %q, want John Smith2", got)
+	}
+}
+
+func TestOAuthCallbackRejectsForeignAndReusedState(t *testing.T) {
+	st, f, b := newOAuthTest(t)
+	account := ProviderIdentity{Subject: "1", Email: "a@example.com", Username: "a"}
+
+	rec := b.do(http.MethodGet, "/auth/fake")
+	callback := f.authorize(t, rec.Header().Get("Location"), account)
+
+	// A victim's browser does not have the state cookie.
+	victim := &browser{t: t, handler: b.handler, cookies: make(map[string]*http.Cookie)}
+	if rec := victim.do(http.MethodGet, "/auth/fake/callback?"+callback.Encode()); rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback in another browser: got %d, want 400", rec.Code)
+	}
+
+	stateCookie := b.cookies[oauthStateCookie]
+	if rec := b.do(http.MethodGet, "/auth/fake/callback?"+callback.Encode()); rec.Code != http.StatusSeeOther {
+		t.Fatalf("callback: got %d %s", rec.Code, rec.Body)
+	}
+
+	// Replaying the callback with the old cookie fails, the state is used up.
+	b.cookies[oauthStateCookie] = stateCookie
+	delete(b.cookies, "session_token")
+	if rec := b.do(http.MethodGet, "/auth/fake/callback?"+callback.Encode()); rec.Code != http.StatusBadRequest {
+		t.Fatalf("replayed callback: got %d, want 400", rec.Code)
+	}
+	if b.sessionUser(st) != "" {
+		t.Fatalf("replayed callback logged in")
+	}
+}
+
+func TestOAuthRefusesSignUpWithEmailOfExistingUser(t *testing.T) {
+	st, f, b := newOAuthTest(t)
+	st.Users.Create(store.User{Username: "alice", Password: "hash", Email: "alice@example.com", Role: "user"})
+
+	rec := b.login(f, http.MethodGet, "/auth/fake", ProviderIdentity{Subject: "9", Email: "alice@example.com", Username: "Alice"})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("got %d, want 409", rec.Code)
+	}
+	if b.sessionUser(st) != "" {
+		t.Fatalf("logged in to the existing account without its password")
+	}
+}
+
+// TestOAuthSignUpIsAtomic signs up an account whose identity was linked in
+// the meantime, as when two callbacks race.
+func TestOAuthSignUpIsAtomic(t *testing.T) {
+	st := store.NewMemory()
+	o := NewOAuth(st.Users, st.Identities)
+	ownerID, _ := st.Users.Create(store.User{Username: "dave", Email: "dave@example.com", Role: "user"})
+	st.Identities.Link(store.Identity{Provider: "fake", Subject: "9", UserID: ownerID})
+
+	_, err := o.signUp("fake", ProviderIdentity{Subject: "9", Email: "erin@example.com", Username: "erin"})
+	if err != store.ErrConflict {
+		t.Fatalf("got %v, want ErrConflict", err)
+	}
+	if _, err := st.Users.ByUsername("erin"); err != store.ErrNotFound {
+		t.Fatalf("the user was created without the identity: %v", err)
+	}
+}
+
+func TestOAuthLinkAndUnlink(t *testing.T) {
+	st, f, b := newOAuthTest(t)
+	id, _ := st.Users.Create(store.User{Username: "alice", Password: "hash", Email: "alice@example.com", Role: "user"})
+	rec := httptest.NewRecorder()
+	CreateSession(rec, httptest.NewRequest(http.MethodPost, "/login", nil), "alice")
+	for _, c := range rec.Result().Cookies() {
+		b.cookies[c.Name] = c
+	}
+
+	account := ProviderIdentity{Subject: "7", Email: "other@example.com", Username: "Alice A"}
+	if rec := b.login(f, http.MethodPost, "/auth/fake/link", account); rec.Code != http.StatusSeeOther {
+		t.Fatalf("link callback: got %d %s", rec.Code, rec.Body)
+	}
+	identity, err := st.Identities.Get("fake", "7")
+	if err != nil || identity.UserID != id {
+		t.Fatalf("identity = %+v, %v; want linked to user %d", identity, err, id)
+	}
+
+	// Logging in with the provider account now opens alice.
+	other := &browser{t: t, handler: b.handler, cookies: make(map[string]*http.Cookie)}
+	other.login(f, http.MethodGet, "/auth/fake", account)
+	if got := other.sessionUser(st); got != "alice" {
+		t.Fatalf("logged in as %q, want alice", got)
+	}
+
+	if rec := b.do(http.MethodPost, "/auth/fake/unlink"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("unlink: got %d %s", rec.Code, rec.Body)
+	}
+	if _, err := st.Identities.Get("fake", "7"); err != store.ErrNotFound {
+		t.Fatalf("identity still linked after unlink: %v", err)
+	}
+}
+
+func TestOAuthKeepsOnlyLoginOfPasswordlessUser(t *testing.T) {
+	st, f, b := newOAuthTest(t)
+	b.login(f, http.MethodGet, "/auth/fake", ProviderIdentity{Subject: "1", Username: "bob"})
+
+	if rec := b.do(http.MethodPost, "/auth/fake/unlink"); rec.Code != http.StatusConflict {
+		t.Fatalf("unlink: got %d, want 409", rec.Code)
+	}
+	if _, err := st.Identities.Get("fake", "1"); err != nil {
+		t.Fatalf("identity was removed: %v", err)
+	}
+}
+
+// TestOAuth2ProviderTimesOut checks that a provider that stops answering
+// fails the login instead of holding it.
+func TestOAuth2ProviderTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	hang := func(w http.ResponseWriter, r *http.Request) { <-release }
+	token := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token","token_type":"Bearer"}`))
+	}
+
+	for _, tt := range []struct {
+		name     string
+		token    http.HandlerFunc
+		userinfo http.HandlerFunc
+	}{
+		{"token", hang, nil},
+		{"user info", token, hang},
+	} {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/token", tt.token)
+		if tt.userinfo != nil {
+			mux.HandleFunc("/userinfo", tt.userinfo)
+		}
+		server := httptest.NewServer(mux)
+		defer server.Close()
+		p := &OAuth2Provider{
+			ProviderName:  "slow",
+			Config:        &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{TokenURL: server.URL + "/token"}},
+			UserInfoURL:   server.URL + "/userinfo",
+			ParseUserInfo: parseGoogleUserInfo,
+			Client:        &http.Client{Timeout: 50 * time.Millisecond},
+		}
+
+		start := time.Now()
+		_, err := p.Identity(context.Background(), "code", "verifier", "")
+		if err == nil || time.Since(start) > 2*time.Second {
+			t.Errorf("%s: got %v after %v", tt.name, err, time.Since(start))
+		}
+	}
+	close(release)
+}
@@ -0,0 +1,156 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"forum/config"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCProvider logs in with any OpenID Connect issuer. The user is taken from
+// the verified ID token, so no provider specific user info API is needed.
+type OIDCProvider struct {
+	name     string
+	issuer   string
+	config   *oauth2.Config
+	client   *http.Client
+	clockNow func() time.Time
+
+	// The endpoints of config, keys and verifier are set by discover.
+	mu       sync.Mutex
+	keys     *jwks
+	verifier *oidc.IDTokenVerifier
+}
+
+// oidcClockSkew is how far the issuer's clock may be off from ours.
+const oidcClockSkew = 2 * time.Minute
+
+// NewOIDCProvider returns a provider for the issuer. Its discovery document
+// is read on the first login, so that an issuer that is down does not keep
+// the forum from starting.
+func NewOIDCProvider(provider config.OIDCProvider, publicURL string) *OIDCProvider {
+	return &OIDCProvider{
+		name:   provider.Name,
+		issuer: provider.Issuer,
+		config: &oauth2.Config{
+			RedirectURL:  callbackURL(publicURL, provider.Name),
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			Scopes:       append([]string{oidc.ScopeOpenID, "email", "profile"}, provider.Scopes...),
+		},
+		client:   providerHTTPClient,
+		clockNow: time.Now,
+	}
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+// discover reads the discovery document of the issuer unless that was done
+// already. Failures are not remembered, the next login tries again.
+func (p *OIDCProvider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.verifier != nil {
+		return nil
+	}
+
+	// The issuer in the document must be the one we asked, otherwise tokens
+	// would be checked against an issuer that was never configured.
+	// NewProvider makes sure of that.
+	discovered, err := oidc.NewProvider(oidc.ClientContext(ctx, p.client), p.issuer)
+	if err != nil {
+		return fmt.Errorf("failed to discover %s: %w", p.name, err)
+	}
+	var document struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	endpoint := discovered.Endpoint()
+	if err := discovered.Claims(&document); err != nil || endpoint.AuthURL == "" || endpoint.TokenURL == "" || document.JWKSURI == "" {
+		return fmt.Errorf("%s discovery document lacks endpoints", p.name)
+	}
+
+	p.config.Endpoint = endpoint
+	p.keys = &jwks{url: document.JWKSURI, client: p.client}
+	p.verifier = oidc.NewVerifier(p.issuer, p.keys, &oidc.Config{
+		ClientID:             p.config.ClientID,
+		SupportedSigningAlgs: []string{oidc.RS256, oidc.ES256},
+		// Tokens stay valid for oidcClockSkew after they expire.
+		Now: func() time.Time { return p.clockNow().Add(-oidcClockSkew) },
+	})
+	return nil
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state string, codeChallenge string, nonce string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+	return p.config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oidc.Nonce(nonce)), nil
+}
+
+func (p *OIDCProvider) Identity(ctx context.Context, code string, codeVerifier string, nonce string) (ProviderIdentity, error) {
+	if err := p.discover(ctx); err != nil {
+		return ProviderIdentity{}, err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := p.config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
+	if err != nil {
+		return ProviderIdentity{}, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return ProviderIdentity{}, fmt.Errorf("token response has no id_token")
+	}
+	return p.verifyIDToken(ctx, rawIDToken, nonce)
+}
+
+// verifyIDToken checks the ID token as the OpenID Connect Core spec asks of
+// clients using the code flow and returns the account it describes. The
+// verifier checks the signature, issuer, audience and expiry; the checks it
+// leaves to clients are made here.
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, rawIDToken string, nonce string) (ProviderIdentity, error) {
+	token, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return ProviderIdentity{}, fmt.Errorf("invalid ID token: %w", err)
+	}
+	var claims struct {
+		AuthorizedParty   string `json:"azp"`
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+		Name              string `json:"name"`
+	}
+	if err := token.Claims(&claims); err != nil {
+		return ProviderIdentity{}, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+
+	switch {
+	case len(token.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return ProviderIdentity{}, fmt.Errorf("ID token is authorized for another party")
+	case token.IssuedAt.After(p.clockNow().Add(oidcClockSkew)):
+		return ProviderIdentity{}, fmt.Errorf("ID token issued in the future")
+	case nonce == "" || token.Nonce != nonce:
+		return ProviderIdentity{}, fmt.Errorf("ID token nonce does not match")
+	case token.Subject == "":
+		return ProviderIdentity{}, fmt.Errorf("ID token has no subject")
+	}
+
+	identity := ProviderIdentity{Subject: token.Subject, Username: claims.PreferredUsername}
+	// An unverified address could belong to someone else.
+	if claims.EmailVerified {
+		identity.Email = claims.Email
+	}
+	if identity.Username == "" {
+		identity.Username = claims.Name
+	}
+	return identity, nil
+}
@@ -0,0 +1,332 @@
+package helpers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"forum/config"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testIssuer is a minimal OpenID Connect issuer: discovery, JWKS, and a token
+// endpoint that hands out signed ID tokens for codes made by authorize.
+type testIssuer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	kid    string
+	key    *rsa.PrivateKey
+	old    map[string]*rsa.PrivateKey
+	codes  map[string]testGrant
+	nextID int
+}
+
+type testGrant struct {
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	iss := &testIssuer{codes: make(map[string]testGrant), old: make(map[string]*rsa.PrivateKey)}
+	iss.rotate(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 iss.URL,
+			"authorization_endpoint": iss.URL + "/authorize",
+			"token_endpoint":         iss.URL + "/token",
+			"jwks_uri":               iss.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		iss.mu.Lock()
+		defer iss.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": iss.kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(iss.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(iss.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		iss.mu.Lock()
+		grant, ok := iss.codes[r.PostFormValue("code")]
+		delete(iss.codes, r.PostFormValue("code"))
+		iss.mu.Unlock()
+
+		if !ok || pkceChallenge(r.PostFormValue("code_verifier")) != grant.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     iss.sign(t, nil, grant.claims),
+		})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+// rotate switches to a new signing key, which the JWKS then lists instead.
+func (iss *testIssuer) rotate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	if iss.key != nil {
+		iss.old[iss.kid] = iss.key
+	}
+	iss.kid = fmt.Sprintf("key%d", len(iss.old)+1)
+	iss.key = key
+}
+
+// sign makes an RS256 token with the current key. header entries override
+// the default header.
+func (iss *testIssuer) sign(t *testing.T, header map[string]interface{}, claims map[string]interface{}) string {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+
+	h := map[string]interface{}{"alg": "RS256", "typ": "JWT", "kid": iss.kid}
+	for k, v := range header {
+		h[k] = v
+	}
+	headerJSON, _ := json.Marshal(h)
+	claimsJSON, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (iss *testIssuer) claims(account ProviderIdentity, nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                iss.URL,
+		"sub":                account.Subject,
+		"aud":                "forum",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"email":              account.Email,
+		"email_verified":     true,
+		"preferred_username": account.Username,
+	}
+}
+
+func (iss *testIssuer) authorize(t *testing.T, authURL string, account ProviderIdentity) url.Values {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if !strings.Contains(q.Get("scope"), "openid") {
+		t.Fatalf("authorization URL without openid scope: %s", authURL)
+	}
+
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.nextID++
+	code := fmt.Sprintf("code%d", iss.nextID)
+	iss.codes[code] = testGrant{challenge: q.Get("code_challenge"), claims: iss.claims(account, q.Get("nonce"))}
+	return url.Values{"code": {code}, "state": {q.Get("state")}}
+}
+
+func newTestOIDCProvider(t *testing.T, iss *testIssuer) *OIDCProvider {
+	p := NewOIDCProvider(config.OIDCProvider{
+		Name:        "fake",
+		Issuer:      iss.URL,
+		OAuthClient: config.OAuthClient{ClientID: "forum", ClientSecret: "secret"},
+	}, "http://forum.test")
+	if err := p.discover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestOIDCLogin(t *testing.T) {
+	iss := newTestIssuer(t)
+	st, b := newOAuthBrowser(t, newTestOIDCProvider(t, iss))
+
+	rec := b.login(iss, http.MethodGet, "/auth/fake", ProviderIdentity{Subject: "kc-1", Email: "carol@example.com", Username: "carol"})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("callback: got %d %s", rec.Code, rec.Body)
+	}
+	if got := b.sessionUser(st); got != "carol" {
+		t.Fatalf("logged in as %q, want carol", got)
+	}
+	identity, err := st.Identities.Get("fake", "kc-1")
+	if err != nil || identity.Email != "carol@example.com" {
+		t.Fatalf("identity = %+v, %v", identity, err)
+	}
+}
+
+func TestOIDCNonceIsIndependent(t *testing.T) {
+	iss := newTestIssuer(t)
+	p := newTestOIDCProvider(t, iss)
+	authURL, err := p.AuthCodeURL(context.Background(), "state", pkceChallenge("verifier"), "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	if got := u.Query().Get("nonce"); got != "nonce" {
+		t.Fatalf("nonce = %q, want the one of the login", got)
+	}
+	// A token carrying the PKCE challenge as nonce is not for this login.
+	token := iss.sign(t, nil, iss.claims(ProviderIdentity{Subject: "kc-1"}, pkceChallenge("verifier")))
+	if _, err := p.verifyIDToken(context.Background(), token, "nonce"); err == nil {
+		t.Fatal("accepted the PKCE challenge as nonce")
+	}
+}
+
+func TestOIDCDiscoveryMustMatchIssuer(t *testing.T) {
+	iss := newTestIssuer(t)
+	p := NewOIDCProvider(config.OIDCProvider{
+		Name:        "fake",
+		Issuer:      strings.Replace(iss.URL, "127.0.0.1", "localhost", 1),
+		OAuthClient: config.OAuthClient{ClientID: "forum"},
+	}, "http://forum.test")
+	if _, err := p.AuthCodeURL(context.Background(), "state", "challenge", "nonce"); err == nil {
+		t.Fatal("accepted a discovery document of another issuer")
+	}
+}
+
+// TestOIDCDiscoveryRetries starts with the issuer down: logins fail until it
+// is back, and then work without restarting.
+func TestOIDCDiscoveryRetries(t *testing.T) {
+	iss := newTestIssuer(t)
+	up := iss.Config.Handler
+	iss.Config.Handler = http.NotFoundHandler()
+	p := NewOIDCProvider(config.OIDCProvider{
+		Name:        "fake",
+		Issuer:      iss.URL,
+		OAuthClient: config.OAuthClient{ClientID: "forum", ClientSecret: "secret"},
+	}, "http://forum.test")
+	st, b := newOAuthBrowser(t, p)
+
+	rec := b.do(http.MethodGet, "/auth/fake")
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("login with the issuer down: got %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+
+	iss.Config.Handler = up
+	rec = b.login(iss, http.MethodGet, "/auth/fake", ProviderIdentity{Subject: "kc-1", Email: "carol@example.com", Username: "carol"})
+	if rec.Code != http.StatusSeeOther || b.sessionUser(st) != "carol" {
+		t.Fatalf("login once the issuer is back: got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCRejectsWeakKeys(t *testing.T) {
+	iss := newTestIssuer(t)
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss.mu.Lock()
+	iss.key = weak
+	iss.mu.Unlock()
+	p := newTestOIDCProvider(t, iss)
+
+	token := iss.sign(t, nil, iss.claims(ProviderIdentity{Subject: "kc-1"}, "nonce"))
+	if _, err := p.verifyIDToken(context.Background(), token, "nonce"); err == nil {
+		t.Fatal("accepted a token signed with a 1024 bit RSA key")
+	}
+}
+
+func TestOIDCRejectsBadIDTokens(t *testing.T) {
+	iss := newTestIssuer(t)
+	p := newTestOIDCProvider(t, iss)
+	account := ProviderIdentity{Subject: "kc-1", Email: "carol@example.com", Username: "carol"}
+
+	valid := iss.sign(t, nil, iss.claims(account, "nonce"))
+	if _, err := p.verifyIDToken(context.Background(), valid, "nonce"); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := iss.claims(account, "nonce")
+		claims[key] = value
+		return claims
+	}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"other issuer", iss.sign(t, nil, with("iss", "https://evil.example.com"))},
+		{"other audience", iss.sign(t, nil, with("aud", "someone-else"))},
+		{"shared audience without azp", iss.sign(t, nil, with("aud", []string{"forum", "someone-else"}))},
+		{"expired", iss.sign(t, nil, with("exp", time.Now().Add(-time.Hour).Unix()))},
+		{"issued in the future", iss.sign(t, nil, with("iat", time.Now().Add(time.Hour).Unix()))},
+		{"other nonce", iss.sign(t, nil, with("nonce", "replayed"))},
+		{"no subject", iss.sign(t, nil, with("sub", ""))},
+		{"alg none", strings.Join(strings.Split(iss.sign(t, map[string]interface{}{"alg": "none"}, iss.claims(account, "nonce")), ".")[:2], ".") + "."},
+		{"HS256", iss.sign(t, map[string]interface{}{"alg": "HS256"}, iss.claims(account, "nonce"))},
+		{"tampered payload", tamper(valid)},
+	}
+	for _, tt := range tests {
+		if _, err := p.verifyIDToken(context.Background(), tt.token, "nonce"); err == nil {
+			t.Errorf("%s: token accepted", tt.name)
+		}
+	}
+
+	// Addresses the issuer has not verified are not used.
+	unverified := iss.sign(t, nil, with("email_verified", false))
+	if identity, err := p.verifyIDToken(context.Background(), unverified, "nonce"); err != nil || identity.Email != "" {
+		t.Errorf("unverified email: got %+v, %v", identity, err)
+	}
+}
+
+// tamper swaps the payload of token for a different one, keeping the
+// signature.
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	payload = []byte(strings.Replace(string(payload), `"sub":"kc-1"`, `"sub":"kc-2"`, 1))
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	return strings.Join(parts, ".")
+}
+
+func TestOIDCFollowsKeyRotation(t *testing.T) {
+	iss := newTestIssuer(t)
+	p := newTestOIDCProvider(t, iss)
+	account := ProviderIdentity{Subject: "kc-1"}
+
+	if _, err := p.verifyIDToken(context.Background(), iss.sign(t, nil, iss.claims(account, "n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	iss.rotate(t)
+	rotated := iss.sign(t, nil, iss.claims(account, "n"))
+	// Unknown key IDs do not make every request download the key set.
+	if _, err := p.verifyIDToken(context.Background(), rotated, "n"); err == nil {
+		t.Fatal("keys were fetched again right away")
+	}
+
+	p.keys.mu.Lock()
+	p.keys.fetchedAt = time.Now().Add(-jwksRefetchInterval)
+	p.keys.mu.Unlock()
+	if _, err := p.verifyIDToken(context.Background(), rotated, "n"); err != nil {
+		t.Fatalf("token signed with the new key: %v", err)
+	}
+}
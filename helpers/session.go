@@ -2,71 +2,148 @@ package helpers
 
 import (
 	"fmt"
+	"forum/store"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-type Session struct {
-	Username string
-	Expiry   time.Time
+// CookiePolicy holds the cookie attributes that differ between deployments,
+// e.g. Secure is needed behind HTTPS but breaks plain http on localhost.
+type CookiePolicy struct {
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
 }
 
-var sessions = map[string]Session{}
+func (p CookiePolicy) cookie(name string, value string, expires time.Time, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     p.Path,
+		Domain:   p.Domain,
+		Expires:  expires,
+		Secure:   p.Secure,
+		HttpOnly: httpOnly,
+		SameSite: p.SameSite,
+	}
+}
 
-func CreateSession(w http.ResponseWriter, r *http.Request, user string) {
+type SessionConfig struct {
+	// TTL is how long a session stays valid without requests. Every valid
+	// request slides the expiry forward by TTL again.
+	TTL time.Duration
+	// MultipleLogins keeps older sessions of a user alive on login, so the
+	// same account can be used from several browsers at once.
+	MultipleLogins bool
+	// SweepInterval is how often expired sessions are removed from the store.
+	SweepInterval time.Duration
+	Cookie        CookiePolicy
+}
 
-	Check(user) //Check if user cookie already exists, if exists: DELETE old one, make new one.
-	//so only 1 browser can be active at once
+var DefaultSessionConfig = SessionConfig{
+	TTL:            40 * time.Minute,
+	MultipleLogins: false,
+	SweepInterval:  time.Minute,
+	Cookie: CookiePolicy{
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+	},
+}
 
-	sessionToken := uuid.NewString()
-	expiresAt := time.Now().Add(2400 * time.Second)
+var (
+	sessions      store.SessionStore = store.NewMemorySessions()
+	sessionConfig                    = DefaultSessionConfig
+)
 
-	sessions[sessionToken] = Session{
-		Username: user,
-		Expiry:   expiresAt,
-	}
+// ConfigureSessions sets where sessions are kept and how long they live. It
+// must be called before the server starts handling requests.
+func ConfigureSessions(sessionStore store.SessionStore, config SessionConfig) {
+	sessions = sessionStore
+	sessionConfig = config
+}
 
-	// Create a new cookie with the session token
-	cookie := &http.Cookie{
-		Name:    "session_token",
-		Value:   sessionToken,
-		Expires: expiresAt,
-	}
+// StartSessionSweeper removes expired sessions every SweepInterval until the
+// returned stop function is called.
+func StartSessionSweeper() (stop func()) {
+	ticker := time.NewTicker(sessionConfig.SweepInterval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := sessions.DeleteExpired(time.Now()); err != nil {
+					log.Println("Session sweep failed:", err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
 
-	// Set the cookie in the response
-	http.SetCookie(w, cookie)
+func setSessionCookie(w http.ResponseWriter, token string, expiresAt time.Time) {
+	http.SetCookie(w, sessionConfig.Cookie.cookie("session_token", token, expiresAt, true))
+}
+
+func CreateSession(w http.ResponseWriter, r *http.Request, user string) {
 
-	fmt.Println("Session token cookie:", cookie.Value) //Check the cookie value
+	if !sessionConfig.MultipleLogins {
+		//only 1 browser can be active at once, so log out the old ones.
+		if err := sessions.DeleteForUser(user); err != nil {
+			log.Println(err)
+		}
+	}
 
+	session := store.Session{
+		Token:    uuid.NewString(),
+		Username: user,
+		Expiry:   time.Now().Add(sessionConfig.TTL),
+	}
+	if err := sessions.Create(session); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	setSessionCookie(w, session.Token, session.Expiry)
 }
 
-func ValidateSessionFromCookie(w http.ResponseWriter, r *http.Request) (*Session, error) {
-	// this func idea is to check if cookie exists? get the info from the cookie
+// currentSession returns the valid session of the request's cookie and slides
+// its expiry forward.
+func currentSession(w http.ResponseWriter, r *http.Request) (*store.Session, error) {
 	c, err := r.Cookie("session_token")
 	if err != nil {
-		if err == http.ErrNoCookie {
-			w.WriteHeader(http.StatusUnauthorized)
-			return nil, err
-		}
-
-		w.WriteHeader(http.StatusBadRequest)
 		return nil, err
 	}
-	sessionToken := c.Value
 
-	userSession, exists := sessions[sessionToken]
-	if !exists {
+	userSession, err := sessions.Get(c.Value)
+	if err == store.ErrNotFound {
 		return nil, fmt.Errorf("session does not exist")
+	} else if err != nil {
+		return nil, err
 	}
 
 	if userSession.IsExpired() {
-		delete(sessions, sessionToken)
+		sessions.Delete(userSession.Token)
 		return nil, fmt.Errorf("session expired")
 	}
 
-	//	fmt.Println("At the end of OK")
+	// Sliding expiry. Only write when a noticeable part of the TTL has passed
+	// so that every request does not end up updating the store.
+	if time.Until(userSession.Expiry) < sessionConfig.TTL-sessionConfig.TTL/10 {
+		userSession.Expiry = time.Now().Add(sessionConfig.TTL)
+		if err := sessions.SetExpiry(userSession.Token, userSession.Expiry); err != nil {
+			log.Println(err)
+		}
+		setSessionCookie(w, userSession.Token, userSession.Expiry)
+	}
+
 	return &userSession, nil
 }
 
@@ -84,68 +161,44 @@ func Refresh(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	sessionToken := c.Value
 
-	userSession, exists := sessions[sessionToken]
-	if !exists {
+	userSession, err := sessions.Get(c.Value)
+	if err != nil {
 		return
 	}
 
 	if userSession.IsExpired() {
-		delete(sessions, sessionToken)
+		sessions.Delete(userSession.Token)
 		return
 	}
 
-	newSessionToken := uuid.NewString()
-	expiresAt := time.Now().Add(120 * time.Second)
-
 	//if previous session is valid, create new.
 
-	sessions[newSessionToken] = Session{
+	newSession := store.Session{
+		Token:    uuid.NewString(),
 		Username: userSession.Username,
-		Expiry:   expiresAt,
+		Expiry:   time.Now().Add(sessionConfig.TTL),
 	}
-	delete(sessions, sessionToken)
-
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_token",
-		Value:   newSessionToken,
-		Expires: time.Now().Add(120 * time.Second),
-	})
-
-}
-
-func (s Session) IsExpired() bool {
-	return s.Expiry.Before(time.Now())
-}
-
-func Check(user string) {
-	for token, session := range sessions { //check if
-		if session.Username == user {
-			delete(sessions, token)
-			break
-		}
+	if err := sessions.Create(newSession); err != nil {
+		log.Println(err)
+		return
 	}
+	sessions.Delete(userSession.Token)
+
+	setSessionCookie(w, newSession.Token, newSession.Expiry)
 }
 
 func DeleteCookie(w http.ResponseWriter, r *http.Request) {
 	c, err := r.Cookie("session_token")
 	if err != nil {
-		if err == http.ErrNoCookie {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	sessionToken := c.Value
 
-	delete(sessions, sessionToken)
+	if err := sessions.Delete(c.Value); err != nil {
+		log.Println(err)
+	}
 
 	// set cookie to empty value, and set expiry date to now!
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_token",
-		Value:   "",
-		Expires: time.Now(),
-	})
+	setSessionCookie(w, "", time.Unix(0, 0))
 }
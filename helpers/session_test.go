@@ -0,0 +1,132 @@
+package helpers
+
+import (
+	"forum/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useSessions keeps sessions in a new memory store with config for the rest
+// of the test.
+func useSessions(t *testing.T, config SessionConfig) store.SessionStore {
+	oldSessions, oldConfig := sessions, sessionConfig
+	t.Cleanup(func() { ConfigureSessions(oldSessions, oldConfig) })
+	sessionStore := store.NewMemorySessions()
+	ConfigureSessions(sessionStore, config)
+	return sessionStore
+}
+
+// sessionCookie returns the session cookie w sets, or nil.
+func sessionCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "session_token" {
+			return c
+		}
+	}
+	return nil
+}
+
+func logIn(t *testing.T, username string) *http.Cookie {
+	w := httptest.NewRecorder()
+	CreateSession(w, httptest.NewRequest(http.MethodPost, "/login", nil), username)
+	c := sessionCookie(w)
+	if c == nil {
+		t.Fatalf("no session cookie for %s", username)
+	}
+	return c
+}
+
+// visit makes a request with cookie and returns the session it found and the
+// response.
+func visit(cookie *http.Cookie) (*store.Session, *httptest.ResponseRecorder) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	session, _ := currentSession(w, r)
+	return session, w
+}
+
+func TestSlidingExpiry(t *testing.T) {
+	config := DefaultSessionConfig
+	config.TTL = 300 * time.Millisecond
+	sessionStore := useSessions(t, config)
+	cookie := logIn(t, "alice")
+	created, _ := sessionStore.Get(cookie.Value)
+
+	// Right after login too little of the TTL passed to write anything.
+	if session, w := visit(cookie); session == nil || sessionCookie(w) != nil {
+		t.Fatalf("fresh session: %v, cookie %v", session, sessionCookie(w))
+	}
+
+	time.Sleep(config.TTL / 2)
+	session, w := visit(cookie)
+	if session == nil {
+		t.Fatal("session expired halfway through its TTL")
+	}
+	stored, _ := sessionStore.Get(cookie.Value)
+	if !stored.Expiry.After(created.Expiry) || sessionCookie(w) == nil {
+		t.Errorf("expiry did not slide: %v -> %v, cookie %v", created.Expiry, stored.Expiry, sessionCookie(w))
+	}
+
+	// The slid expiry keeps the session beyond its first TTL.
+	time.Sleep(config.TTL * 2 / 3)
+	if session, _ := visit(cookie); session == nil {
+		t.Fatal("session expired although it was used")
+	}
+
+	time.Sleep(config.TTL + 50*time.Millisecond)
+	if session, _ := visit(cookie); session != nil {
+		t.Fatal("unused session did not expire")
+	}
+	if _, err := sessionStore.Get(cookie.Value); err != store.ErrNotFound {
+		t.Errorf("expired session is kept: %v", err)
+	}
+}
+
+func TestMultipleLogins(t *testing.T) {
+	for _, multiple := range []bool{false, true} {
+		config := DefaultSessionConfig
+		config.MultipleLogins = multiple
+		useSessions(t, config)
+
+		first := logIn(t, "alice")
+		bob := logIn(t, "bob")
+		second := logIn(t, "alice")
+
+		if session, _ := visit(first); (session != nil) != multiple {
+			t.Errorf("MultipleLogins %v: first session of alice kept: %v", multiple, session != nil)
+		}
+		if session, _ := visit(second); session == nil {
+			t.Errorf("MultipleLogins %v: latest session of alice lost", multiple)
+		}
+		if session, _ := visit(bob); session == nil {
+			t.Errorf("MultipleLogins %v: logging in alice ended the session of bob", multiple)
+		}
+	}
+}
+
+func TestSessionSweeper(t *testing.T) {
+	config := DefaultSessionConfig
+	config.SweepInterval = 20 * time.Millisecond
+	sessionStore := useSessions(t, config)
+	sessionStore.Create(store.Session{Token: "expired", Username: "alice", Expiry: time.Now().Add(-time.Second)})
+	sessionStore.Create(store.Session{Token: "valid", Username: "bob", Expiry: time.Now().Add(time.Hour)})
+
+	stop := StartSessionSweeper()
+	defer stop()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := sessionStore.Get("expired"); err == store.ErrNotFound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired session was not swept")
+		}
+		time.Sleep(config.SweepInterval)
+	}
+	if _, err := sessionStore.Get("valid"); err != nil {
+		t.Errorf("valid session was swept: %v", err)
+	}
+}
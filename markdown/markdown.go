@@ -0,0 +1,67 @@
+// Package markdown renders what users write in posts, comments and messages.
+// Markdown is converted to HTML without any raw HTML of the source, and the
+// result goes through a strict sanitizer, so it is safe to put into pages.
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.Linkify, extension.Strikethrough),
+	goldmark.WithParserOptions(
+		parser.WithInlineParsers(util.Prioritized(mentionParser{}, 500)),
+	),
+	goldmark.WithRendererOptions(
+		goldmarkhtml.WithHardWraps(),
+		// Ahead of goldmark's own renderer at 1000, which they replace
+		renderer.WithNodeRenderers(
+			util.Prioritized(mentionRenderer{}, 100),
+			util.Prioritized(rawHTMLRenderer{}, 100),
+		),
+	),
+)
+
+// policy allows the formatting Markdown produces and nothing else. Links
+// may only go to http, https and mailto URLs or to pages of the forum, and
+// get rel="nofollow"; links to other sites open in a new tab.
+var policy = func() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "strong", "em", "del", "code", "pre", "blockquote",
+		"ul", "ol", "li", "h1", "h2", "h3", "h4", "h5", "h6", "hr")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^mention$`)).OnElements("span")
+	p.AllowAttrs("data-username").Matching(regexp.MustCompile(`^[\p{L}\p{N}_.-]+$`)).OnElements("span")
+
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.AllowRelativeURLs(true)
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}()
+
+// Render converts Markdown source to sanitized HTML. isUser tells which
+// @mentions name users; the others stay plain text. It may be nil.
+func Render(source string, isUser func(username string) bool) string {
+	ctx := parser.NewContext()
+	ctx.Set(usersKey, isUser)
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(source), &buf, parser.WithContext(ctx)); err != nil {
+		// Converting only fails when writing fails, which buffers do not.
+		return "<p>" + html.EscapeString(source) + "</p>"
+	}
+	return policy.Sanitize(buf.String())
+}
@@ -0,0 +1,105 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func isAlice(username string) bool {
+	return username == "alice"
+}
+
+// unsafe matches markup that must never come out of Render.
+var unsafe = regexp.MustCompile(`(?i)<(script|img|iframe|svg|div|style|form|input)|\son\w+=|(href|src)="?\s*(javascript|data|vbscript):`)
+
+// TestRenderEscapesRawHTML checks that HTML in the source is shown as text
+// instead of being left out or passed on.
+func TestRenderEscapesRawHTML(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"hi <img src=x onerror=alert(1)> there", "<p>hi &lt;img src=x onerror=alert(1)&gt; there</p>\n"},
+		{"<div onclick=\"alert(1)\">\nblock\n</div>", "<p>&lt;div onclick=&#34;alert(1)&#34;&gt;<br>\nblock<br>\n&lt;/div&gt;</p>\n"},
+		{"<!-- comment --> text", "<p>&lt;!-- comment --&gt; text</p>\n"},
+		{"`<b>`", "<p><code>&lt;b&gt;</code></p>\n"},
+		{"```html onload=x\n<b>\n```", "<pre><code class=\"language-html\">&lt;b&gt;\n</code></pre>\n"},
+	}
+	for _, tt := range tests {
+		if got := Render(tt.source, isAlice); got != tt.want {
+			t.Errorf("Render(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
+
+// TestRenderDropsUnsafeLinks checks that links and images only keep URLs of
+// the allowed schemes.
+func TestRenderDropsUnsafeLinks(t *testing.T) {
+	for _, source := range []string{
+		"[x](javascript:alert(1))",
+		"[x](JaVaScRiPt:alert(1))",
+		"[x](java\tscript:alert(1))",
+		"[x](data:text/html;base64,PHNjcmlwdD4=)",
+		"[x](vbscript:msgbox)",
+		"![i](javascript:alert(1))",
+		"![i](https://example.com/i.png)",
+		"<javascript:alert(1)>",
+		"[x](https://example.com \"t\\\" onmouseover=\\\"alert(1)\")",
+	} {
+		got := Render(source, isAlice)
+		if unsafe.MatchString(got) {
+			t.Errorf("Render(%q) = %q", source, got)
+		}
+	}
+
+	want := `<p><a href="https://example.com" rel="nofollow noopener" target="_blank">x</a></p>` + "\n"
+	if got := Render("[x](https://example.com)", isAlice); got != want {
+		t.Errorf("external link = %q, want %q", got, want)
+	}
+	want = `<p><a href="/post?id=1" rel="nofollow">x</a></p>` + "\n"
+	if got := Render("[x](/post?id=1)", isAlice); got != want {
+		t.Errorf("forum link = %q, want %q", got, want)
+	}
+}
+
+func TestRenderMentions(t *testing.T) {
+	got := Render("@alice and @bob, mail alice@example.com or @alice<script>", isAlice)
+	want := `<p><span class="mention" data-username="alice">@alice</span> and @bob, mail <a href="mailto:alice@example.com" rel="nofollow">alice@example.com</a> or <span class="mention" data-username="alice">@alice</span>&lt;script&gt;</p>` + "\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := Render("@alice", nil); strings.Contains(got, "mention") {
+		t.Errorf("mention without isUser: %q", got)
+	}
+}
+
+// TestPolicy checks the sanitizer on its own, as the last line of defence
+// should the renderer ever let markup through.
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{`<script>alert(1)</script>`, ``},
+		{`<img src="x" onerror="alert(1)">`, ``},
+		{`<iframe src="https://example.com"></iframe>`, ``},
+		{`<svg onload="alert(1)"></svg>`, ``},
+		{`<form action="/x"><input name="a"></form>`, ``},
+		{`<a href="javascript:alert(1)">x</a>`, `x`},
+		{`<a href="https://example.com" onclick="alert(1)">x</a>`, `<a href="https://example.com" rel="nofollow noopener" target="_blank">x</a>`},
+		{`<a href="/post?id=1">x</a>`, `<a href="/post?id=1" rel="nofollow">x</a>`},
+		{`<span class="mention" data-username="a&quot; onclick=&quot;x" onclick="alert(1)">@a</span>`, `<span class="mention">@a</span>`},
+		{`<span class="evil">x</span>`, `<span>x</span>`},
+		{`<code class="language-go" style="color:red">x</code>`, `<code class="language-go">x</code>`},
+		{`<code class="language-go onmouseover">x</code>`, `<code>x</code>`},
+		{`<p style="background:url(javascript:alert(1))">x</p>`, `<p>x</p>`},
+		{`<ol start="3" onclick="x"><li>a</li></ol>`, `<ol start="3"><li>a</li></ol>`},
+	}
+	for _, tt := range tests {
+		if got := policy.Sanitize(tt.html); got != tt.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
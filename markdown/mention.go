@@ -0,0 +1,87 @@
+package markdown
+
+import (
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// mentionName matches the username at the start of a mention, without a
+// full stop ending the sentence.
+var mentionName = regexp.MustCompile(`^[\p{L}\p{N}_.-]*[\p{L}\p{N}_-]`)
+
+// usersKey holds the isUser function of the Render call in the parser
+// context.
+var usersKey = parser.NewContextKey()
+
+// Mention is an @username naming a user.
+type Mention struct {
+	ast.BaseInline
+	Username string
+}
+
+var KindMention = ast.NewNodeKind("Mention")
+
+func (n *Mention) Kind() ast.NodeKind {
+	return KindMention
+}
+
+func (n *Mention) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Username": n.Username}, nil)
+}
+
+type mentionParser struct{}
+
+func (mentionParser) Trigger() []byte {
+	return []byte{'@'}
+}
+
+// Parse turns @username into a Mention when the user exists. The @ of
+// e-mail addresses, which follows a letter or digit, is left alone.
+func (mentionParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	if unicode.IsLetter(before) || unicode.IsDigit(before) || before == '_' {
+		return nil
+	}
+	line, _ := block.PeekLine()
+	end := 1
+	for end < len(line) {
+		r, size := utf8.DecodeRune(line[end:])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '.' && r != '-' {
+			break
+		}
+		end += size
+	}
+	name := mentionName.Find(line[1:end])
+	if name == nil {
+		return nil
+	}
+	isUser, _ := pc.Get(usersKey).(func(string) bool)
+	if isUser == nil || !isUser(string(name)) {
+		return nil
+	}
+	block.Advance(1 + len(name))
+	return &Mention{Username: string(name)}
+}
+
+type mentionRenderer struct{}
+
+func (mentionRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMention, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			name := util.EscapeHTML([]byte(n.(*Mention).Username))
+			w.WriteString(`<span class="mention" data-username="`)
+			w.Write(name)
+			w.WriteString(`">@`)
+			w.Write(name)
+			w.WriteString(`</span>`)
+		}
+		return ast.WalkContinue, nil
+	})
+}
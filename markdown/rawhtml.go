@@ -0,0 +1,49 @@
+package markdown
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// rawHTMLRenderer shows HTML in the source as the text it is, where
+// goldmark would leave it out.
+type rawHTMLRenderer struct{}
+
+func (rawHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindRawHTML, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			segments := n.(*ast.RawHTML).Segments
+			for i := 0; i < segments.Len(); i++ {
+				segment := segments.At(i)
+				w.Write(util.EscapeHTML(segment.Value(source)))
+			}
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	reg.Register(ast.KindHTMLBlock, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		block := n.(*ast.HTMLBlock)
+		var lines [][]byte
+		for i := 0; i < block.Lines().Len(); i++ {
+			segment := block.Lines().At(i)
+			lines = append(lines, segment.Value(source))
+		}
+		if block.HasClosure() {
+			lines = append(lines, block.ClosureLine.Value(source))
+		}
+		w.WriteString("<p>")
+		for i, line := range lines {
+			if i > 0 {
+				w.WriteString("<br>\n")
+			}
+			w.Write(util.EscapeHTML(bytes.TrimRight(line, "\r\n")))
+		}
+		w.WriteString("</p>\n")
+		return ast.WalkContinue, nil
+	})
+}
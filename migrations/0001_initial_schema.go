@@ -1,4 +1,16 @@
- CREATE TABLE IF NOT EXISTS users (
+package migrations
+
+import "database/sql"
+
+// initialSchema is the schema that used to live in schema.sql. It only creates
+// missing tables so it can be applied on top of databases that were set up by
+// the old ExecuteSchema.
+var initialSchema = Migration{
+	Version: 1,
+	Name:    "initial_schema",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+CREATE TABLE IF NOT EXISTS users (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     username TEXT NOT NULL UNIQUE,
     password TEXT NOT NULL,
@@ -48,7 +60,6 @@ CREATE TABLE IF NOT EXISTS post_votes (
     PRIMARY KEY (post_id, user_id),
     FOREIGN KEY (post_id) REFERENCES posts(id),
     FOREIGN KEY (user_id) REFERENCES users(id)
-   
 );
 
 CREATE TABLE IF NOT EXISTS comment_votes (
@@ -69,6 +80,19 @@ CREATE TABLE IF NOT EXISTS private_messages (
     read_at TIMESTAMP,
     FOREIGN KEY (sender_id) REFERENCES users(id),
     FOREIGN KEY (receiver_id) REFERENCES users(id)
-);
-
-
+);`)
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+DROP TABLE IF EXISTS private_messages;
+DROP TABLE IF EXISTS comment_votes;
+DROP TABLE IF EXISTS post_votes;
+DROP TABLE IF EXISTS post_categories;
+DROP TABLE IF EXISTS categories;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS posts;
+DROP TABLE IF EXISTS users;`)
+		return err
+	},
+}
@@ -0,0 +1,22 @@
+package migrations
+
+import "database/sql"
+
+// postsFlagged adds the flag moderators set when reporting a post. Older
+// databases got this column by hand, so it is only added when missing.
+var postsFlagged = Migration{
+	Version: 2,
+	Name:    "posts_flagged",
+	Up: func(tx *sql.Tx) error {
+		exists, err := columnExists(tx, "posts", "flagged")
+		if err != nil || exists {
+			return err
+		}
+		_, err = tx.Exec("ALTER TABLE posts ADD COLUMN flagged INT NOT NULL DEFAULT 0;")
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec("ALTER TABLE posts DROP COLUMN flagged;")
+		return err
+	},
+}
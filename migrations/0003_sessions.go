@@ -0,0 +1,25 @@
+package migrations
+
+import "database/sql"
+
+// sessions stores login sessions so they survive a restart.
+var sessions = Migration{
+	Version: 3,
+	Name:    "sessions",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+CREATE TABLE sessions (
+    token TEXT PRIMARY KEY,
+    username TEXT NOT NULL,
+    expires_at INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX sessions_username ON sessions(username);
+CREATE INDEX sessions_expires_at ON sessions(expires_at);`)
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec("DROP TABLE sessions;")
+		return err
+	},
+}
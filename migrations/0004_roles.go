@@ -0,0 +1,24 @@
+package migrations
+
+import "database/sql"
+
+// roles ranks the user roles so that route policies can be checked against
+// the database instead of comparing role names in handlers.
+var roles = Migration{
+	Version: 4,
+	Name:    "roles",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+CREATE TABLE roles (
+    name TEXT PRIMARY KEY,
+    rank INTEGER NOT NULL UNIQUE
+);
+INSERT INTO roles (name, rank) VALUES ('guest', 0), ('user', 1), ('moderator', 2), ('admin', 3);
+UPDATE users SET role = 'user' WHERE role IS NULL OR role NOT IN (SELECT name FROM roles);`)
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec("DROP TABLE roles;")
+		return err
+	},
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import "database/sql"
+
+// providerIdentities links OAuth accounts to users by the provider's stable
+// subject instead of by display name.
+var providerIdentities = Migration{
+	Version: 5,
+	Name:    "provider_identities",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+CREATE TABLE provider_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, subject),
+    UNIQUE (provider, user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);`)
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec("DROP TABLE provider_identities;")
+		return err
+	},
+}
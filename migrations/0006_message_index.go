@@ -0,0 +1,17 @@
+package migrations
+
+import "database/sql"
+
+// messageIndex backs paging through a conversation by (created_at, id).
+var messageIndex = Migration{
+	Version: 6,
+	Name:    "message_index",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec("CREATE INDEX private_messages_conversation ON private_messages (sender_id, receiver_id, created_at);")
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec("DROP INDEX private_messages_conversation;")
+		return err
+	},
+}
@@ -0,0 +1,18 @@
+package migrations
+
+import "database/sql"
+
+// usersLastSeen keeps when users were last connected to the chat, so that
+// presence survives restarts.
+var usersLastSeen = Migration{
+	Version: 7,
+	Name:    "users_last_seen",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec("ALTER TABLE users ADD COLUMN last_seen_at TIMESTAMP;")
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec("ALTER TABLE users DROP COLUMN last_seen_at;")
+		return err
+	},
+}
@@ -0,0 +1,58 @@
+package migrations
+
+import "database/sql"
+
+// conversations adds chats with any number of members next to the private
+// messages between two users: groups people create and one public channel
+// for every category. The categories posts already use are created first,
+// as older databases never had rows for them; they are kept on the way down.
+var conversations = Migration{
+	Version: 8,
+	Name:    "conversations",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+INSERT OR IGNORE INTO categories (id, name) VALUES (1, 'league'), (2, 'runescape'), (3, 'counter-strike');
+
+CREATE TABLE conversations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL CHECK (kind IN ('group', 'channel')),
+    name TEXT NOT NULL,
+    category_id INTEGER UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
+);
+
+CREATE TABLE conversation_members (
+    conversation_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'member')),
+    last_read_id INTEGER NOT NULL DEFAULT 0,
+    joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (conversation_id, user_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE conversation_messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    conversation_id INTEGER NOT NULL,
+    sender_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (sender_id) REFERENCES users(id)
+);
+
+CREATE INDEX conversation_messages_page ON conversation_messages (conversation_id, created_at);
+
+INSERT INTO conversations (kind, name, category_id) SELECT 'channel', name, id FROM categories;`)
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+DROP TABLE conversation_messages;
+DROP TABLE conversation_members;
+DROP TABLE conversations;`)
+		return err
+	},
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import "database/sql"
+
+// messageEdits lets authors edit and delete their private messages. Deleted
+// messages keep their content, and every edit keeps the content it replaced
+// in private_message_revisions, so moderators can see what was said.
+var messageEdits = Migration{
+	Version: 9,
+	Name:    "message_edits",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+ALTER TABLE private_messages ADD COLUMN edited_at TIMESTAMP;
+ALTER TABLE private_messages ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE TABLE private_message_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    replaced_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (message_id) REFERENCES private_messages(id) ON DELETE CASCADE
+);
+
+CREATE INDEX private_message_revisions_message ON private_message_revisions (message_id);`)
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+DROP TABLE private_message_revisions;
+ALTER TABLE private_messages DROP COLUMN deleted_at;
+ALTER TABLE private_messages DROP COLUMN edited_at;`)
+		return err
+	},
+}
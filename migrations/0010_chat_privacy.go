@@ -0,0 +1,30 @@
+package migrations
+
+import "database/sql"
+
+// chatPrivacy lets users block others and choose who may message them:
+// everyone, the people they have chatted with, or nobody.
+var chatPrivacy = Migration{
+	Version: 10,
+	Name:    "chat_privacy",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+ALTER TABLE users ADD COLUMN chat_privacy TEXT NOT NULL DEFAULT 'everyone' CHECK (chat_privacy IN ('everyone', 'contacts', 'nobody'));
+
+CREATE TABLE blocks (
+    blocker_id INTEGER NOT NULL,
+    blocked_id INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (blocker_id, blocked_id),
+    FOREIGN KEY (blocker_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (blocked_id) REFERENCES users(id) ON DELETE CASCADE
+);`)
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+DROP TABLE blocks;
+ALTER TABLE users DROP COLUMN chat_privacy;`)
+		return err
+	},
+}
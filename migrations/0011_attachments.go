@@ -0,0 +1,40 @@
+package migrations
+
+import "database/sql"
+
+// attachments lets chat messages carry an uploaded file. The files are kept
+// in the attachment storage; the table only knows their keys.
+var attachments = Migration{
+	Version: 11,
+	Name:    "attachments",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+CREATE TABLE attachments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uploader_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    storage_key TEXT NOT NULL UNIQUE,
+    thumbnail_key TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (uploader_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+ALTER TABLE private_messages ADD COLUMN attachment_id INTEGER REFERENCES attachments(id);
+ALTER TABLE conversation_messages ADD COLUMN attachment_id INTEGER REFERENCES attachments(id);
+
+CREATE INDEX private_messages_attachment ON private_messages (attachment_id);
+CREATE INDEX conversation_messages_attachment ON conversation_messages (attachment_id);`)
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+DROP INDEX conversation_messages_attachment;
+DROP INDEX private_messages_attachment;
+ALTER TABLE conversation_messages DROP COLUMN attachment_id;
+ALTER TABLE private_messages DROP COLUMN attachment_id;
+DROP TABLE attachments;`)
+		return err
+	},
+}
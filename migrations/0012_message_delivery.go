@@ -0,0 +1,25 @@
+package migrations
+
+import "database/sql"
+
+// messageDelivery records when private messages reached their receiver, so
+// that messages sent to offline users can be delivered when they connect.
+// Existing messages count as delivered.
+var messageDelivery = Migration{
+	Version: 12,
+	Name:    "message_delivery",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+ALTER TABLE private_messages ADD COLUMN delivered_at TIMESTAMP;
+UPDATE private_messages SET delivered_at = COALESCE(read_at, created_at);
+
+CREATE INDEX private_messages_undelivered ON private_messages (receiver_id, id) WHERE delivered_at IS NULL;`)
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+DROP INDEX private_messages_undelivered;
+ALTER TABLE private_messages DROP COLUMN delivered_at;`)
+		return err
+	},
+}
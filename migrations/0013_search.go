@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// search indexes posts, comments and private messages for full-text search.
+// The FTS5 tables only index the content of their table and are kept in sync
+// by triggers, so every way of changing the content updates the index.
+var search = Migration{
+	Version: 13,
+	Name:    "search",
+	Up: func(tx *sql.Tx) error {
+		for _, table := range searchTables {
+			_, err := tx.Exec(strings.ReplaceAll(`
+CREATE VIRTUAL TABLE {table}_search USING fts5(content, content='{table}', content_rowid='id', tokenize='unicode61 remove_diacritics 2');
+INSERT INTO {table}_search ({table}_search) VALUES ('rebuild');
+
+CREATE TRIGGER {table}_search_insert AFTER INSERT ON {table} BEGIN
+    INSERT INTO {table}_search (rowid, content) VALUES (new.id, new.content);
+END;
+CREATE TRIGGER {table}_search_delete AFTER DELETE ON {table} BEGIN
+    INSERT INTO {table}_search ({table}_search, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+CREATE TRIGGER {table}_search_update AFTER UPDATE OF content ON {table} BEGIN
+    INSERT INTO {table}_search ({table}_search, rowid, content) VALUES ('delete', old.id, old.content);
+    INSERT INTO {table}_search (rowid, content) VALUES (new.id, new.content);
+END;`, "{table}", table))
+			if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+				return fmt.Errorf("SQLite lacks FTS5, build the forum with -tags sqlite_fts5: %w", err)
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Down: func(tx *sql.Tx) error {
+		for _, table := range searchTables {
+			_, err := tx.Exec(strings.ReplaceAll(`
+DROP TRIGGER {table}_search_update;
+DROP TRIGGER {table}_search_delete;
+DROP TRIGGER {table}_search_insert;
+DROP TABLE {table}_search;`, "{table}", table))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var searchTables = []string{"posts", "comments", "private_messages"}
@@ -0,0 +1,26 @@
+package migrations
+
+import "database/sql"
+
+// threadedComments lets comments reply to other comments. Deleted comments
+// that have replies stay as placeholders, marked by deleted_at and without
+// content, so that the replies keep their place in the thread.
+var threadedComments = Migration{
+	Version: 14,
+	Name:    "threaded_comments",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+ALTER TABLE comments ADD COLUMN parent_comment_id INTEGER REFERENCES comments(id);
+ALTER TABLE comments ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX comments_parent ON comments (parent_comment_id);`)
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+DROP INDEX comments_parent;
+ALTER TABLE comments DROP COLUMN deleted_at;
+ALTER TABLE comments DROP COLUMN parent_comment_id;`)
+		return err
+	},
+}
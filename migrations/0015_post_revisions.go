@@ -0,0 +1,51 @@
+package migrations
+
+import "database/sql"
+
+// postRevisions gives posts a title and lets authors edit posts and
+// comments. Every edit keeps the version it replaced in post_revisions or
+// comment_revisions.
+var postRevisions = Migration{
+	Version: 15,
+	Name:    "post_revisions",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+ALTER TABLE posts ADD COLUMN title TEXT NOT NULL DEFAULT '';
+ALTER TABLE posts ADD COLUMN edited_at TIMESTAMP;
+ALTER TABLE comments ADD COLUMN edited_at TIMESTAMP;
+
+CREATE TABLE post_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    content TEXT NOT NULL,
+    editor_id INTEGER NOT NULL,
+    replaced_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    FOREIGN KEY (editor_id) REFERENCES users(id)
+);
+
+CREATE TABLE comment_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    comment_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    editor_id INTEGER NOT NULL,
+    replaced_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
+    FOREIGN KEY (editor_id) REFERENCES users(id)
+);
+
+CREATE INDEX post_revisions_post ON post_revisions (post_id);
+CREATE INDEX comment_revisions_comment ON comment_revisions (comment_id);`)
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+DROP TABLE comment_revisions;
+DROP TABLE post_revisions;
+ALTER TABLE comments DROP COLUMN edited_at;
+ALTER TABLE posts DROP COLUMN edited_at;
+ALTER TABLE posts DROP COLUMN title;`)
+		return err
+	},
+}
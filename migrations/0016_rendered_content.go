@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// renderedContent keeps the HTML rendered from the Markdown of posts,
+// comments and messages next to their source. Existing rows are left NULL
+// for `forum render` to fill, so this step does not change with the renderer.
+var renderedContent = Migration{
+	Version: 16,
+	Name:    "rendered_content",
+	Up: func(tx *sql.Tx) error {
+		for _, table := range renderedTables {
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN content_html TEXT;", table)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Down: func(tx *sql.Tx) error {
+		for _, table := range renderedTables {
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN content_html;", table)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var renderedTables = []string{"posts", "comments", "private_messages", "conversation_messages"}
@@ -0,0 +1,54 @@
+package migrations
+
+import "database/sql"
+
+// searchTitles adds post titles, which came after the search index, to the
+// index of posts. The FTS5 table cannot gain a column, so it is built again.
+var searchTitles = Migration{
+	Version: 17,
+	Name:    "search_titles",
+	Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+DROP TRIGGER posts_search_update;
+DROP TRIGGER posts_search_delete;
+DROP TRIGGER posts_search_insert;
+DROP TABLE posts_search;
+
+CREATE VIRTUAL TABLE posts_search USING fts5(content, title, content='posts', content_rowid='id', tokenize='unicode61 remove_diacritics 2');
+INSERT INTO posts_search (posts_search) VALUES ('rebuild');
+
+CREATE TRIGGER posts_search_insert AFTER INSERT ON posts BEGIN
+    INSERT INTO posts_search (rowid, content, title) VALUES (new.id, new.content, new.title);
+END;
+CREATE TRIGGER posts_search_delete AFTER DELETE ON posts BEGIN
+    INSERT INTO posts_search (posts_search, rowid, content, title) VALUES ('delete', old.id, old.content, old.title);
+END;
+CREATE TRIGGER posts_search_update AFTER UPDATE OF content, title ON posts BEGIN
+    INSERT INTO posts_search (posts_search, rowid, content, title) VALUES ('delete', old.id, old.content, old.title);
+    INSERT INTO posts_search (rowid, content, title) VALUES (new.id, new.content, new.title);
+END;`)
+		return err
+	},
+	Down: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+DROP TRIGGER posts_search_update;
+DROP TRIGGER posts_search_delete;
+DROP TRIGGER posts_search_insert;
+DROP TABLE posts_search;
+
+CREATE VIRTUAL TABLE posts_search USING fts5(content, content='posts', content_rowid='id', tokenize='unicode61 remove_diacritics 2');
+INSERT INTO posts_search (posts_search) VALUES ('rebuild');
+
+CREATE TRIGGER posts_search_insert AFTER INSERT ON posts BEGIN
+    INSERT INTO posts_search (rowid, content) VALUES (new.id, new.content);
+END;
+CREATE TRIGGER posts_search_delete AFTER DELETE ON posts BEGIN
+    INSERT INTO posts_search (posts_search, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+CREATE TRIGGER posts_search_update AFTER UPDATE OF content ON posts BEGIN
+    INSERT INTO posts_search (posts_search, rowid, content) VALUES ('delete', old.id, old.content);
+    INSERT INTO posts_search (rowid, content) VALUES (new.id, new.content);
+END;`)
+		return err
+	},
+}
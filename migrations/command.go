@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+)
+
+// Command runs the `migrate` subcommand: up, down or status.
+func Command(db *sql.DB, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: forum migrate up|down|status")
+	}
+
+	switch args[0] {
+	case "up":
+		ran, err := Up(db)
+		for _, m := range ran {
+			fmt.Fprintf(out, "applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(ran) == 0 {
+			fmt.Fprintln(out, "database is up to date")
+		}
+	case "down":
+		m, err := Down(db)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			fmt.Fprintln(out, "nothing to roll back")
+		} else {
+			fmt.Fprintf(out, "rolled back %04d_%s\n", m.Version, m.Name)
+		}
+	case "status":
+		statuses, err := Statuses(db)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.Applied {
+				fmt.Fprintf(out, "applied  %04d_%s  %s\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Fprintf(out, "pending  %04d_%s\n", s.Version, s.Name)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+// Package migrations keeps the database schema in versioned, numbered steps.
+// Every applied step is recorded in the schema_migrations table so the server
+// can tell whether the database it is pointed at is up to date.
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// all lists every migration in version order. New migrations go at the end.
+var all = []Migration{
+	initialSchema,
+	postsFlagged,
+	sessions,
+	roles,
+	providerIdentities,
+	messageIndex,
+	usersLastSeen,
+	conversations,
+	messageEdits,
+	chatPrivacy,
+	attachments,
+	messageDelivery,
+	search,
+	threadedComments,
+	postRevisions,
+	renderedContent,
+	searchTitles,
+}
+
+func ensureTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+func applied(db *sql.DB) (map[int]time.Time, error) {
+	if err := ensureTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations;")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		versions[version] = appliedAt
+	}
+	return versions, rows.Err()
+}
+
+// Statuses returns every known migration together with whether it has been
+// applied to db.
+func Statuses(db *sql.DB) ([]Status, error) {
+	versions, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(all))
+	for i, m := range all {
+		appliedAt, ok := versions[m.Version]
+		statuses[i] = Status{Migration: m, Applied: ok, AppliedAt: appliedAt}
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations that still have to be applied, in order.
+func Pending(db *sql.DB) ([]Migration, error) {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies all pending migrations and returns the ones it ran.
+func Up(db *sql.DB) ([]Migration, error) {
+	pending, err := Pending(db)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range pending {
+		err := inTx(db, func(tx *sql.Tx) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?);", m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return pending[:i], fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+// Down rolls back the most recently applied migration. It returns nil when
+// there is nothing left to roll back.
+func Down(db *sql.DB) (*Migration, error) {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if !statuses[i].Applied {
+			continue
+		}
+		m := statuses[i].Migration
+		err := inTx(db, func(tx *sql.Tx) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?;", m.Version)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		return &m, nil
+	}
+	return nil, nil
+}
+
+// CheckCurrent returns an error when db is missing any known migration.
+func CheckCurrent(db *sql.DB) error {
+	pending, err := Pending(db)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind by %d migration(s), run `forum migrate up`", len(pending))
+	}
+	return nil
+}
+
+func inTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func columnExists(tx *sql.Tx, table string, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			ctype     string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TestUpDown applies every migration, rolls all of them back and applies
+// them again, which catches Down steps that leave something behind.
+func TestUpDown(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ran, err := Up(db)
+	if err != nil {
+		if strings.Contains(err.Error(), "FTS5") {
+			t.Skip("SQLite lacks FTS5, run the tests with -tags sqlite_fts5")
+		}
+		t.Fatal(err)
+	}
+	if len(ran) != len(all) {
+		t.Fatalf("ran %d of %d migrations", len(ran), len(all))
+	}
+	if err := CheckCurrent(db); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := len(all) - 1; i >= 0; i-- {
+		m, err := Down(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m == nil || m.Version != all[i].Version {
+			t.Fatalf("rolled back %v, want version %d", m, all[i].Version)
+		}
+	}
+	if m, err := Down(db); m != nil || err != nil {
+		t.Fatalf("Down with nothing applied = %v, %v", m, err)
+	}
+
+	if ran, err := Up(db); err != nil || len(ran) != len(all) {
+		t.Fatalf("second Up ran %d migrations: %v", len(ran), err)
+	}
+}
+
+func TestVersionsAreInOrder(t *testing.T) {
+	for i, m := range all {
+		if m.Version != i+1 {
+			t.Errorf("migration %s has version %d, want %d", m.Name, m.Version, i+1)
+		}
+	}
+}
@@ -1,20 +1,28 @@
 package main
 
 import (
-	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"forum/attachments"
+	"forum/chat"
+	"forum/config"
 	"forum/helpers"
+	"forum/migrations"
+	"forum/store"
+	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"text/template"
 	"time"
 
 	"github.com/gorilla/websocket"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 var upgrader = websocket.Upgrader{
@@ -22,39 +30,16 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
-type Post struct {
-	ID           int
-	Content      string
-	Username     string
-	Likes        int
-	Dislikes     int
-	CreatedAt    time.Time
-	Comments     []Comment
-	PostedAgo    string
-	CommentCount int
-}
-
 type HomePageData struct {
 	Username           string
 	Usernames          []string
-	Posts              []Post
+	Posts              []store.Post
 	Role               string
 	ModerationRequests []string
 	Moderators         []string
 	ReportedRequests   int
 	UsernameId         int
-	Userlist           []helpers.Userlist // Changed from []string to []UserWithID
-}
-
-type Comment struct {
-	ID        int
-	PostID    int
-	Username  string
-	Content   string
-	Likes     int
-	Dislikes  int
-	CreatedAt time.Time
-	PostedAgo string
+	Userlist           []store.Userlist // Changed from []string to []UserWithID
 }
 
 type Vote struct {
@@ -62,361 +47,1386 @@ type Vote struct {
 	Username string `json:"username"`
 }
 
-var clients = make(map[string]Client) // map username to websocket and additional info
-
-// var clients = make(map[string]*websocket.Conn)
-type Client struct {
-	username string
-	ws       *websocket.Conn
-}
-
 func main() {
 
-	db, err := helpers.GetDbConnection()
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	db, err := store.OpenSQLite(cfg.Database)
 	if err != nil {
 		log.Fatalf("failed to prepare database connection: %v", err)
 	}
 	defer db.Close()
 
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := migrations.Command(db, args[1:], os.Stdout); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
+	if err := migrations.CheckCurrent(db); err != nil {
+		log.Fatalf("refusing to start: %v", err)
+	}
+	st := store.NewSQLite(db)
+
+	if len(args) > 0 && args[0] == "render" {
+		filled, err := store.RenderContent(db)
+		if err != nil {
+			log.Fatalf("render: %v", err)
+		}
+		fmt.Printf("rendered %d posts, comments and messages\n", filled)
+		return
+	}
+
+	if len(args) > 0 && args[0] == "role" {
+		if err := roleCommand(st, args[1:]); err != nil {
+			log.Fatalf("role: %v", err)
+		}
+		return
+	}
+
+	sessionStore := st.Sessions
+	if cfg.Session.Store == "memory" {
+		sessionStore = store.NewMemorySessions()
+	}
+	helpers.ConfigureSessions(sessionStore, sessionConfig(cfg.Session))
+	stopSweeper := helpers.StartSessionSweeper()
+	defer stopSweeper()
+
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 	http.Handle("/dist/", http.StripPrefix("/dist/", http.FileServer(http.Dir("dist"))))
 	http.Handle("/forumpages/", http.StripPrefix("/forumpages/", http.FileServer(http.Dir("forumpages"))))
 
-	http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) { handleCallback(w, r, db) })
-	http.HandleFunc("/callbackgithub", func(w http.ResponseWriter, r *http.Request) { HandleCallbackGithub(w, r, db) })
-	http.HandleFunc("/auth/google", helpers.HandleLogin)
-	http.HandleFunc("/auth/github", handleGithubLogin)
-	http.HandleFunc("/report", func(w http.ResponseWriter, r *http.Request) { reportPostHandler(w, r, db) })
-	http.HandleFunc("/delete", func(w http.ResponseWriter, r *http.Request) { deletePostHandler(w, r, db) })
-	http.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) { admin(w, r, db) })
-	http.HandleFunc("/submitpost", func(w http.ResponseWriter, r *http.Request) { createPost(w, r, db) })
-	http.HandleFunc("/myposts", func(w http.ResponseWriter, r *http.Request) { showMyPostsHandler(w, r, db) })
-	http.HandleFunc("/commentlike", commentLikeHandler)
-	http.HandleFunc("/commentdislike", commentDislikeHandler)
-	http.HandleFunc("/like", likeHandler)
-	http.HandleFunc("/dislike", dislikeHandler)
-	http.HandleFunc("/filterpage", filterPage)
-	http.HandleFunc("/submitcomment", func(w http.ResponseWriter, r *http.Request) { submitComment(w, r, db) })
-	http.HandleFunc("/addcomment", addComment)
-	http.HandleFunc("/register", registerHandler)
-	http.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) { loginHandler(w, r, db) })
-	http.HandleFunc("/homepage", func(w http.ResponseWriter, r *http.Request) { homePageHandler(w, r, db) })
-	http.HandleFunc("/logout", logOutHandler)
-	http.HandleFunc("/createpost", serveCreatePostPage)
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) { handleWebSocket(w, r, db) })
-	http.HandleFunc("/send-message", func(w http.ResponseWriter, r *http.Request) { messageHandler(w, r, db) })
-	http.HandleFunc("/get-message", func(w http.ResponseWriter, r *http.Request) { getMessageHandler(w, r, db) })
-	http.HandleFunc("/", homeHandler)
-
-	fmt.Println("Server started on port 8080.")
-	fmt.Println("http://localhost:8080/")
-	http.ListenAndServe(":8080", nil)
-}
-
-func handleWebSocket(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	upgrader.CheckOrigin = func(r *http.Request) bool { return true }
-	fmt.Println("Websocket got called")
-	ws, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Fatal()
+	var providers []helpers.Provider
+	if cfg.Google.Enabled() {
+		providers = append(providers, helpers.NewGoogleProvider(cfg.Google, cfg.PublicURL))
+	}
+	if cfg.GitHub.Enabled() {
+		providers = append(providers, helpers.NewGithubProvider(cfg.GitHub, cfg.PublicURL))
+	}
+	for _, p := range cfg.OIDC {
+		providers = append(providers, helpers.NewOIDCProvider(p, cfg.PublicURL))
+	}
+	oauth := helpers.NewOAuth(st.Users, st.Identities, providers...)
+
+	files, err := attachments.NewDisk(cfg.Attachments.Dir)
+	if err != nil {
+		log.Fatalf("refusing to start: %v", err)
+	}
+
+	presence := chat.NewPresence(st.Users)
+	go presence.Run()
+	hub := chat.NewHub(presence)
+	go hub.Run()
+	upgrader.CheckOrigin = chat.CheckOrigin(cfg.AllowedOrigins)
+
+	// handle registers a route behind its policy, the least privileged role
+	// allowed to use it.
+	handle := func(pattern string, policy helpers.Policy, handler http.HandlerFunc) {
+		http.Handle(pattern, helpers.Require(policy, st.Roles, handler))
+	}
+
+	handle("/client-config", helpers.Guest, func(w http.ResponseWriter, r *http.Request) { clientConfigHandler(w, r, cfg) })
+	handle("/auth/identities", helpers.Member, oauth.Identities)
+	for _, name := range oauth.Providers() {
+		name := name
+		handle("/auth/"+name, helpers.Guest, func(w http.ResponseWriter, r *http.Request) { oauth.Login(w, r, name) })
+		handle("/auth/"+name+"/callback", helpers.Guest, func(w http.ResponseWriter, r *http.Request) { oauth.Callback(w, r, name) })
+		handle("/auth/"+name+"/link", helpers.Member, func(w http.ResponseWriter, r *http.Request) { oauth.Link(w, r, name) })
+		handle("/auth/"+name+"/unlink", helpers.Member, func(w http.ResponseWriter, r *http.Request) { oauth.Unlink(w, r, name) })
+	}
+	handle("/report", helpers.Moderator, func(w http.ResponseWriter, r *http.Request) { reportPostHandler(w, r, st) })
+	handle("/delete", helpers.Moderator, func(w http.ResponseWriter, r *http.Request) { deletePostHandler(w, r, st) })
+	handle("/admin", helpers.Admin, func(w http.ResponseWriter, r *http.Request) { admin(w, r, st) })
+	handle("/submitpost", helpers.Member, func(w http.ResponseWriter, r *http.Request) { createPost(w, r, st) })
+	handle("/myposts", helpers.Guest, func(w http.ResponseWriter, r *http.Request) { showMyPostsHandler(w, r, st) })
+	handle("/commentlike", helpers.Member, func(w http.ResponseWriter, r *http.Request) { commentLikeHandler(w, r, st) })
+	handle("/commentdislike", helpers.Member, func(w http.ResponseWriter, r *http.Request) { commentDislikeHandler(w, r, st) })
+	handle("/like", helpers.Member, func(w http.ResponseWriter, r *http.Request) { likeHandler(w, r, st) })
+	handle("/dislike", helpers.Member, func(w http.ResponseWriter, r *http.Request) { dislikeHandler(w, r, st) })
+	handle("/filterpage", helpers.Guest, func(w http.ResponseWriter, r *http.Request) { filterPage(w, r, st) })
+	handle("/search", helpers.Guest, func(w http.ResponseWriter, r *http.Request) { searchHandler(w, r, st) })
+	replyHooks := []replyHook{notifyReply(hub)}
+	handle("/submitcomment", helpers.Member, func(w http.ResponseWriter, r *http.Request) {
+		submitComment(w, r, st, cfg.Comments.MaxDepth, replyHooks)
+	})
+	handle("/delete-comment", helpers.Member, func(w http.ResponseWriter, r *http.Request) { deleteCommentHandler(w, r, st) })
+	handle("/edit-comment", helpers.Member, func(w http.ResponseWriter, r *http.Request) { editCommentHandler(w, r, st) })
+	handle("/comment-revisions", helpers.Guest, func(w http.ResponseWriter, r *http.Request) { commentRevisionsHandler(w, r, st) })
+	handle("/edit-post", helpers.Member, func(w http.ResponseWriter, r *http.Request) { editPostHandler(w, r, st) })
+	handle("/post-revisions", helpers.Guest, func(w http.ResponseWriter, r *http.Request) { postRevisionsHandler(w, r, st) })
+	handle("/addcomment", helpers.Guest, addComment)
+	handle("/register", helpers.Guest, func(w http.ResponseWriter, r *http.Request) { registerHandler(w, r, st) })
+	handle("/login", helpers.Guest, func(w http.ResponseWriter, r *http.Request) { loginHandler(w, r, st) })
+	handle("/homepage", helpers.Guest, func(w http.ResponseWriter, r *http.Request) { homePageHandler(w, r, st) })
+	handle("/logout", helpers.Guest, logOutHandler)
+	handle("/createpost", helpers.Guest, serveCreatePostPage)
+	handle("/ws", helpers.Member, func(w http.ResponseWriter, r *http.Request) { handleWebSocket(w, r, st, hub, cfg.Chat.EditWindow) })
+	handle("/send-message", helpers.Member, func(w http.ResponseWriter, r *http.Request) { messageHandler(w, r, st, hub) })
+	handle("/get-message", helpers.Member, func(w http.ResponseWriter, r *http.Request) { getMessageHandler(w, r, st, hub) })
+	handle("/edit-message", helpers.Member, func(w http.ResponseWriter, r *http.Request) {
+		editMessageHandler(w, r, st, hub, cfg.Chat.EditWindow, false)
+	})
+	handle("/delete-message", helpers.Member, func(w http.ResponseWriter, r *http.Request) {
+		editMessageHandler(w, r, st, hub, cfg.Chat.EditWindow, true)
+	})
+	handle("/privacy", helpers.Member, func(w http.ResponseWriter, r *http.Request) { privacyHandler(w, r, st) })
+	handle("/block", helpers.Member, func(w http.ResponseWriter, r *http.Request) { blockHandler(w, r, st, hub, true) })
+	handle("/unblock", helpers.Member, func(w http.ResponseWriter, r *http.Request) { blockHandler(w, r, st, hub, false) })
+	handle("/message-revisions", helpers.Moderator, func(w http.ResponseWriter, r *http.Request) { messageRevisionsHandler(w, r, st) })
+	handle("/attachments", helpers.Member, func(w http.ResponseWriter, r *http.Request) {
+		uploadHandler(w, r, st, files, cfg.Attachments.MaxSize)
+	})
+	handle("/attachment", helpers.Member, func(w http.ResponseWriter, r *http.Request) { attachmentHandler(w, r, st, files) })
+	handle("/conversations", helpers.Member, func(w http.ResponseWriter, r *http.Request) { conversationsHandler(w, r, st, hub) })
+	handle("/conversations/messages", helpers.Member, func(w http.ResponseWriter, r *http.Request) { conversationMessagesHandler(w, r, st) })
+	handle("/conversations/join", helpers.Member, func(w http.ResponseWriter, r *http.Request) { joinConversationHandler(w, r, st) })
+	handle("/conversations/leave", helpers.Member, func(w http.ResponseWriter, r *http.Request) { leaveConversationHandler(w, r, st) })
+	handle("/conversations/members", helpers.Member, func(w http.ResponseWriter, r *http.Request) { conversationMembersHandler(w, r, st, hub) })
+	handle("/", helpers.Guest, homeHandler)
+
+	fmt.Printf("Server started on %s.\n", cfg.Addr)
+	fmt.Println(cfg.PublicURL + "/")
+	if err := http.ListenAndServe(cfg.Addr, helpers.CSRF(helpers.Authenticate(st.Users, http.DefaultServeMux))); err != nil {
+		log.Fatal(err)
 	}
-	defer ws.Close()
+}
 
-	username := r.URL.Query().Get("username")
-	limit := r.URL.Query().Get("limit")
-	offset := r.URL.Query().Get("offset")
-	// fmt.Println("this is username, limit and offset from URL", username, limit, offset)
-	// clients[username] = ws
-	clients[username] = Client{username, ws}
-	
-	offsetLimit, err := convertQueryParams(offset)
+func sessionConfig(c config.Session) helpers.SessionConfig {
+	sameSite := http.SameSiteLaxMode
+	switch c.CookieSameSite {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+	return helpers.SessionConfig{
+		TTL:            c.TTL,
+		MultipleLogins: c.MultipleLogins,
+		SweepInterval:  c.SweepInterval,
+		Cookie: helpers.CookiePolicy{
+			Path:     "/",
+			Domain:   c.CookieDomain,
+			Secure:   c.CookieSecure,
+			SameSite: sameSite,
+		},
+	}
+}
+
+// roleCommand gives a user one of the roles in the roles table, e.g.
+// `forum role Admin admin`. It is how the first admin gets appointed.
+func roleCommand(st *store.Store, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: forum role <username> <role>")
+	}
+	username, role := args[0], args[1]
+	if _, err := st.Roles.Rank(role); err == store.ErrNotFound {
+		return fmt.Errorf("unknown role %q", role)
+	} else if err != nil {
+		return err
+	}
+	if _, err := st.Users.ByUsername(username); err != nil {
+		return err
+	}
+	return st.Users.SetRole(username, role)
+}
+
+// handleWebSocket connects the logged in user to the chat. Who is chatting
+// comes from the session only, never from the client.
+func handleWebSocket(w http.ResponseWriter, r *http.Request, st *store.Store, hub *chat.Hub, editWindow time.Duration) {
+	user := helpers.CurrentUser(r)
+	hidden, err := hiddenUsers(st, user.ID)
 	if err != nil {
-		log.Printf("Conversion failed for limit: %v", err)
+		log.Println("Chat: blocks:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	strLimit, err := convertQueryParams(limit)
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Conversion failed for offset: %v", err)
+		log.Println("Websocket upgrade failed:", err)
 		return
 	}
 
-
-	// Notify all users about the updated status
-	notifyUserStatus(username, true)
-	for {
-		var msg struct {
-			Message          string `json:"message"`
-			SenderUsername   string `json:"senderusername"`
-			ReceiverUsername string `json:"receiverusername"`
-			Status           string `json:"status"`
+	router := chat.NewRouter()
+	router.Handle(chat.TypeSend, func(c *chat.Client, env chat.Envelope) error {
+		return sendFrame(st, hub, c, env)
+	})
+	router.Handle(chat.TypeHistory, func(c *chat.Client, env chat.Envelope) error {
+		return historyFrame(st, hub, c, env)
+	})
+	router.Handle(chat.TypeRead, func(c *chat.Client, env chat.Envelope) error {
+		return readFrame(st, hub, c, env)
+	})
+	router.Handle(chat.TypeEdit, func(c *chat.Client, env chat.Envelope) error {
+		return editFrame(st, hub, editWindow, c, env)
+	})
+	router.Handle(chat.TypeDelete, func(c *chat.Client, env chat.Envelope) error {
+		return editFrame(st, hub, editWindow, c, env)
+	})
+	router.Handle(chat.TypeTyping, func(c *chat.Client, env chat.Envelope) error {
+		var typing chat.TypingPayload
+		if err := chat.DecodePayload(env, &typing); err != nil {
+			return err
 		}
-		type WebSocketResponse struct {
-			Type     string             `json:"type"`
-			Messages any                `json:"messages,omitempty"`
-			Userlist []helpers.Userlist `json:"userlist,omitempty"`
-			Online   bool               `json:"active,omitempty"`
+		// Nobody sees the typing of people who may not message them.
+		receiverID, err := userID(st, typing.To)
+		if err != nil || receiverID == 0 || canMessage(st, c.User().ID, receiverID) != nil {
+			return err
 		}
-		for _, v := range clients {
-			notifyUserStatus(v.username, true)
+		hub.Typing(c.User(), typing.To, typing.Typing)
+		return nil
+	})
+
+	// Messages sent while the user was offline are replayed in batches, the
+	// next one once the client acknowledged the last message of the one
+	// before.
+	replayedUpTo := 0
+	replay := func(c *chat.Client) {
+		var err error
+		if replayedUpTo, err = replayPending(st, c); err != nil {
+			log.Println("Chat: replay:", err)
 		}
-
-		if err := ws.ReadJSON(&msg); err != nil {
-			log.Println("[NotifyUserStatus]read:", err)
-			delete(clients, username)
-			return
+	}
+	router.Handle(chat.TypeDelivered, func(c *chat.Client, env chat.Envelope) error {
+		var delivered chat.DeliveredPayload
+		if err := chat.DecodePayload(env, &delivered); err != nil {
+			return err
 		}
-
-		if err := liteMesssageHandler(msg.Message, msg.SenderUsername, msg.ReceiverUsername, db); err != nil {
-			log.Println("Store message:", err)
-			continue
+		if delivered.MessageID <= 0 {
+			return &chat.Error{Code: chat.CodeBadPayload, Message: "invalid message ID"}
+		}
+		if err := markDelivered(st, hub, c.User(), delivered.MessageID); err != nil {
+			return err
 		}
+		if delivered.MessageID == replayedUpTo {
+			replay(c)
+		}
+		return nil
+	})
+	hub.Serve(ws, chat.User{ID: user.ID, Username: user.Username, Hidden: hidden}, replay, router.Dispatch)
+}
 
-		if senderWs, ok := clients[msg.SenderUsername]; ok {
+// replayBatch is how many undelivered messages are replayed at a time. It
+// is a quarter of the send queue of a connection, so that the presence
+// snapshot and live frames still fit while a batch is on its way.
+const replayBatch = chat.SendQueueSize / 4
 
-			privateMessages, err := helpers.GetPrivateMessages(db, msg.SenderUsername, msg.ReceiverUsername, strLimit, offsetLimit)
+// replayPending sends c the oldest private messages to its user that were
+// not delivered yet and returns the ID of the last one, or 0 when there
+// were none.
+func replayPending(st *store.Store, c *chat.Client) (int, error) {
+	messages, err := st.Messages.Pending(c.User().ID, replayBatch)
+	if err != nil {
+		return 0, err
+	}
+	senders := make(map[int]string)
+	last := 0
+	for _, msg := range messages {
+		if _, ok := senders[msg.SenderID]; !ok {
+			sender, err := st.Users.ByID(msg.SenderID)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+				return last, err
 			}
+			senders[msg.SenderID] = sender.Username
+		}
+		payload := chat.MessagePayload{From: senders[msg.SenderID], To: c.User().Username, Message: msg, Replayed: true}
+		if err := c.Reply(chat.TypeMessage, "", payload); err != nil {
+			return last, err
+		}
+		last = msg.ID
+	}
+	return last, nil
+}
 
-			response := WebSocketResponse{
-				Type:     "message",
-				Messages: privateMessages,
-			}
+// markDelivered marks the private message id to receiver as delivered and
+// sends its sender a delivery receipt, unless it was delivered already.
+func markDelivered(st *store.Store, hub *chat.Hub, receiver chat.User, id int) error {
+	senderID, err := st.Messages.MarkDelivered(receiver.ID, id)
+	if err != nil || senderID == 0 {
+		return err
+	}
+	sender, err := st.Users.ByID(senderID)
+	if err != nil {
+		return err
+	}
+	return hub.SendTo(chat.TypeDelivered, chat.DeliveredPayload{With: receiver.Username, MessageID: id}, sender.Username)
+}
 
-			if err := senderWs.ws.WriteJSON(response); err != nil {
-				log.Println("write confirmation error:", err)
-				//handle errror
-			}
+// sendFrame stores the message of a send frame, acknowledges it with the ID
+// of the stored message and pushes the message to both users.
+func sendFrame(st *store.Store, hub *chat.Hub, c *chat.Client, env chat.Envelope) error {
+	var send chat.SendPayload
+	if err := chat.DecodePayload(env, &send); err != nil {
+		return err
+	}
+	if strings.TrimSpace(send.Content) == "" && send.Attachment == 0 {
+		return &chat.Error{Code: chat.CodeBadPayload, Message: "the message is empty"}
+	}
+
+	sender := c.User()
+	if send.Conversation != 0 {
+		msg, err := postToConversation(st, hub, sender, send.Conversation, send.Content, send.Attachment)
+		if err != nil {
+			return frameError(err)
 		}
+		return c.Reply(chat.TypeAck, env.ID, chat.AckPayload{MessageID: msg.ID})
+	}
+	msg, err := liteMesssageHandler(send.Content, sender.ID, send.To, st, send.Attachment)
+	if err == errUnknownUser {
+		return &chat.Error{Code: chat.CodeUnknownUser, Message: "no such user " + send.To}
+	} else if err != nil {
+		return frameError(err)
+	}
+	if err := c.Reply(chat.TypeAck, env.ID, chat.AckPayload{MessageID: msg.ID}); err != nil {
+		return err
+	}
+	// The message ends whatever the sender was typing.
+	hub.Typing(sender, send.To, false)
+	return hub.SendTo(chat.TypeMessage, chat.MessagePayload{From: sender.Username, To: send.To, Message: msg}, sender.Username, send.To)
+}
 
-		if receiverWs, ok := clients[msg.ReceiverUsername]; ok {
-			privateMessages, err := helpers.GetPrivateMessages(db, msg.SenderUsername, msg.ReceiverUsername, strLimit, offsetLimit)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+// historyFrame answers a history frame with older messages of one of the
+// user's conversations.
+func historyFrame(st *store.Store, hub *chat.Hub, c *chat.Client, env chat.Envelope) error {
+	var history chat.HistoryPayload
+	if err := chat.DecodePayload(env, &history); err != nil {
+		return err
+	}
+	q, err := messageQuery(history.Before, history.After, history.Limit)
+	if err != nil {
+		return &chat.Error{Code: chat.CodeBadPayload, Message: err.Error()}
+	}
 
-			response := WebSocketResponse{
-				Type:     "message",
-				Messages: privateMessages,
-			}
+	if history.Conversation != 0 {
+		page, err := conversationPage(st, c.User(), history.Conversation, q)
+		if err != nil {
+			return frameError(err)
+		}
+		return c.Reply(chat.TypeHistory, env.ID, chat.HistoryPagePayload{Conversation: history.Conversation, MessagePage: page})
+	}
+	page, err := conversation(st, hub, c.User(), history.With, q)
+	if err == errUnknownUser {
+		return &chat.Error{Code: chat.CodeUnknownUser, Message: "no such user " + history.With}
+	} else if err != nil {
+		return err
+	}
+	return c.Reply(chat.TypeHistory, env.ID, chat.HistoryPagePayload{With: history.With, MessagePage: page})
+}
 
-			if err := receiverWs.ws.WriteJSON(response); err != nil {
-				log.Println("write confirmation error:", err)
-				//handle errror
-			}
+// readFrame marks the messages from another user, or of a group or channel,
+// as read.
+func readFrame(st *store.Store, hub *chat.Hub, c *chat.Client, env chat.Envelope) error {
+	var read chat.ReadPayload
+	if err := chat.DecodePayload(env, &read); err != nil {
+		return err
+	}
+	if read.MessageID < 0 {
+		return &chat.Error{Code: chat.CodeBadPayload, Message: "invalid message ID"}
+	}
+	if read.Conversation != 0 {
+		if _, err := openConversation(st, c.User().ID, read.Conversation); err != nil {
+			return frameError(err)
 		}
-		// After message is sent, update user list and broadcast it
-		userId, err := helpers.GetUserID(msg.SenderUsername)
-		if err != nil {
-			log.Println("GetUserID error:", err)
-			continue
+		return st.Conversations.MarkRead(read.Conversation, c.User().ID, read.MessageID)
+	}
+	senderID, err := userID(st, read.With)
+	if err != nil {
+		return err
+	}
+	if senderID == 0 {
+		return &chat.Error{Code: chat.CodeUnknownUser, Message: "no such user " + read.With}
+	}
+	return markRead(st, hub, c.User(), senderID, read.With, read.MessageID)
+}
+
+// markRead marks the messages sender sent to reader up to the message upTo
+// as read and sends the sender a read receipt, unless they were read already.
+func markRead(st *store.Store, hub *chat.Hub, reader chat.User, senderID int, sender string, upTo int) error {
+	newest, err := st.Messages.MarkRead(reader.ID, senderID, upTo)
+	if err != nil || newest == 0 {
+		return err
+	}
+	return hub.SendTo(chat.TypeRead, chat.ReadPayload{With: reader.Username, MessageID: newest}, sender)
+}
+
+var errUnknownUser = errors.New("no such user")
+
+// liteMesssageHandler stores msg, with the attachment attachmentID unless it
+// is 0, from senderID to the user named receiver and returns the stored
+// message.
+func liteMesssageHandler(msg string, senderID int, receiver string, st *store.Store, attachmentID int) (store.PrivateMessage, error) {
+	receiverUserId, err := userID(st, receiver)
+	if err != nil {
+		return store.PrivateMessage{}, err
+	}
+	if receiverUserId == 0 {
+		return store.PrivateMessage{}, errUnknownUser
+	}
+	if err := canMessage(st, senderID, receiverUserId); err != nil {
+		return store.PrivateMessage{}, err
+	}
+	if err := ownAttachment(st, senderID, attachmentID); err != nil {
+		return store.PrivateMessage{}, err
+	}
+
+	return st.Messages.Create(senderID, receiverUserId, msg, attachmentID)
+}
+
+var (
+	errBlocked      = errors.New("you cannot message this user")
+	errBlocking     = errors.New("you blocked this user, unblock them to message them")
+	errNoMessages   = errors.New("this user does not accept messages")
+	errNotContacted = errors.New("this user only accepts messages from people they have chatted with")
+)
+
+// canMessage tells whether senderID may send private messages to
+// receiverID: neither may have blocked the other, and the receiver's privacy
+// setting has to let the sender in.
+func canMessage(st *store.Store, senderID int, receiverID int) error {
+	if blocked, err := st.Privacy.IsBlocked(receiverID, senderID); err != nil || blocked {
+		if err == nil {
+			err = errBlocked
+		}
+		return err
+	}
+	if blocking, err := st.Privacy.IsBlocked(senderID, receiverID); err != nil || blocking {
+		if err == nil {
+			err = errBlocking
 		}
+		return err
+	}
 
-		updatedUserList, err := helpers.GetUsernamesIds(db, userId)
+	setting, err := st.Privacy.Setting(receiverID)
+	if err != nil {
+		return err
+	}
+	switch setting {
+	case store.PrivacyNobody:
+		return errNoMessages
+	case store.PrivacyContacts:
+		chatted, err := st.Messages.HaveChatted(senderID, receiverID)
+		if err == nil && !chatted {
+			err = errNotContacted
+		}
+		return err
+	}
+	return nil
+}
+
+// privacyHandler returns who may message the current user and whom they
+// blocked on GET, and changes who may message them on POST.
+func privacyHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	user := helpers.CurrentUser(r)
+	switch r.Method {
+	case http.MethodGet:
+		setting, err := st.Privacy.Setting(user.ID)
 		if err != nil {
-			log.Println("Error fetching updated user list:", err)
-			continue
+			chatError(w, err)
+			return
 		}
-		broadcastData := WebSocketResponse{
-			Type:     "userlist",
-			Userlist: updatedUserList,
+		blocked, err := st.Privacy.Blocked(user.ID)
+		if err != nil {
+			chatError(w, err)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"setting": setting, "blocked": blocked})
 
-		for _, clientWs := range clients {
-			if err := clientWs.ws.WriteJSON(broadcastData); err != nil {
-				log.Println("Error sending updated user list:", err)
-			}
+	case http.MethodPost:
+		var privacy struct {
+			Setting string `json:"setting"`
 		}
-	}
+		if err := json.NewDecoder(r.Body).Decode(&privacy); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		switch privacy.Setting {
+		case store.PrivacyEveryone, store.PrivacyContacts, store.PrivacyNobody:
+		default:
+			http.Error(w, `The setting must be "everyone", "contacts" or "nobody"`, http.StatusBadRequest)
+			return
+		}
+		if err := st.Privacy.SetSetting(user.ID, privacy.Setting); err != nil {
+			chatError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
 
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
 }
 
-func notifyUserStatus(username string, online bool) {
-	// Create a response struct
-	userID, err := helpers.GetUserID(username)
+// hiddenUsers returns the users userID blocked or was blocked by, who do
+// not see each other in the chat.
+func hiddenUsers(st *store.Store, userID int) ([]string, error) {
+	blocked, err := st.Privacy.Blocked(userID)
+	if err != nil {
+		return nil, err
+	}
+	blockedBy, err := st.Privacy.BlockedBy(userID)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
+	}
+	return append(blocked, blockedBy...), nil
+}
+
+// blockHandler blocks the user named in the body for the current user, or
+// unblocks them. Blocked users are left out of the user list, neither side
+// can message the other, and neither sees the other online.
+func blockHandler(w http.ResponseWriter, r *http.Request, st *store.Store, hub *chat.Hub, block bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
 	}
-	response := struct {
-		Type     string `json:"type"`
+	var req struct {
 		Username string `json:"username"`
-		UserId   int    `json:"userid"`
-		Online   bool   `json:"online"`
-	}{
-		Type:     "status",
-		Username: username,
-		UserId:   userID,
-		Online:   online,
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	user := helpers.CurrentUser(r)
+	otherID, err := userID(st, req.Username)
+	if err == nil && otherID == 0 {
+		err = errUnknownUser
+	}
+	if err != nil {
+		chatError(w, err)
+		return
+	}
+	if otherID == user.ID {
+		http.Error(w, "You cannot block yourself", http.StatusBadRequest)
+		return
+	}
+
+	if block {
+		err = st.Privacy.Block(user.ID, otherID)
+		if err == store.ErrConflict {
+			err = nil
+		}
+	} else {
+		err = st.Privacy.Unblock(user.ID, otherID)
+		if err == store.ErrNotFound {
+			http.Error(w, req.Username+" is not blocked", http.StatusNotFound)
+			return
+		}
+	}
+	// Unblocking leaves the two hidden while the other still blocks.
+	hidden := block
+	if err == nil && !block {
+		hidden, err = st.Privacy.IsBlocked(otherID, user.ID)
+	}
+	if err != nil {
+		chatError(w, err)
+		return
+	}
+	hub.SetHidden(user.Username, req.Username, hidden)
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// userID returns the ID of username, or 0 when there is no such user.
+func userID(st *store.Store, username string) (int, error) {
+	user, err := st.Users.ByUsername(username)
+	if err == store.ErrNotFound {
+		return 0, nil
+	}
+	return user.ID, err
+}
+
+// maxHistoryPage limits how many messages one history request returns.
+const maxHistoryPage = 50
+
+// messageQuery builds the query for a page of history from the cursors and
+// limit a client sent, over HTTP or the websocket alike.
+func messageQuery(before string, after string, limit int) (store.MessageQuery, error) {
+	var q store.MessageQuery
+	var err error
+	if q.Before, err = store.ParseCursor(before); err != nil {
+		return q, errors.New("invalid before cursor")
+	}
+	if q.After, err = store.ParseCursor(after); err != nil {
+		return q, errors.New("invalid after cursor")
+	}
+	if limit < 0 {
+		return q, errors.New("invalid limit")
+	}
+	q.Limit = limit
+	if q.Limit == 0 || q.Limit > maxHistoryPage {
+		q.Limit = maxHistoryPage
+	}
+	return q, nil
+}
+
+// conversation returns a page of the messages between own and the user named
+// other. Opening a conversation at its newest messages marks them read.
+func conversation(st *store.Store, hub *chat.Hub, own chat.User, other string, q store.MessageQuery) (store.MessagePage, error) {
+	otherUserID, err := userID(st, other)
+	if err != nil {
+		return store.MessagePage{}, err
+	}
+	if otherUserID == 0 {
+		return store.MessagePage{}, errUnknownUser
+	}
+	page, err := st.Messages.Conversation(own.ID, otherUserID, q)
+	if err != nil || !q.Before.IsZero() || !q.After.IsZero() || len(page.Messages) == 0 {
+		return page, err
+	}
+	return page, markRead(st, hub, own, otherUserID, other, page.Messages[0].ID)
+}
+
+// getMessageHandler returns a page of the current user's conversation with
+// receiverusername, selected by the before and after cursors. Other people's
+// conversations cannot be read.
+func getMessageHandler(w http.ResponseWriter, r *http.Request, st *store.Store, hub *chat.Hub) {
+	receiverUsername := r.URL.Query().Get("receiverusername")
+
+	limit, err := convertQueryParams(r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	q, err := messageQuery(r.URL.Query().Get("before"), r.URL.Query().Get("after"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Fetch messages
+	user := helpers.CurrentUser(r)
+	page, err := conversation(st, hub, chat.User{ID: user.ID, Username: user.Username}, receiverUsername, q)
+	if err != nil {
+		chatError(w, err)
+		return
+	}
+
+	// Send messages as JSON
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Printf("Encode messages of %s: %v", user.Username, err)
+	}
+}
+
+// messageHandler stores a message like a send frame over the websocket does
+// and pushes it to the chat connections of both users.
+func messageHandler(w http.ResponseWriter, r *http.Request, st *store.Store, hub *chat.Hub) {
+
+	// The sender is always the logged in user, whatever the body says.
+	var msg struct {
+		Message          string `json:"message"`
+		Receiverusername string `json:"receiverusername"`
+		Conversation     int    `json:"conversation"`
+		Attachment       int    `json:"attachment"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Send the status to all connected clients
-	for _, client := range clients {
-		if err := client.ws.WriteJSON(response); err != nil {
-			log.Printf("error: %v", err)
+	sender := helpers.CurrentUser(r)
+	if msg.Conversation != 0 {
+		if strings.TrimSpace(msg.Message) == "" && msg.Attachment == 0 {
+			http.Error(w, "The message is empty", http.StatusBadRequest)
+			return
+		}
+		stored, err := postToConversation(st, hub, chat.User{ID: sender.ID, Username: sender.Username}, msg.Conversation, msg.Message, msg.Attachment)
+		if err != nil {
+			chatError(w, err)
+			return
 		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": stored.ID})
+		return
+	}
+	stored, err := liteMesssageHandler(msg.Message, sender.ID, msg.Receiverusername, st, msg.Attachment)
+	if err == errUnknownUser {
+		http.Error(w, "No such user", http.StatusNotFound)
+		return
+	} else if err == errUnknownAttachment {
+		http.Error(w, "No such attachment", http.StatusNotFound)
+		return
+	} else if err == errBlocked || err == errBlocking || err == errNoMessages || err == errNotContacted {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Println("Store message:", err)
+		http.Error(w, "Failed to store message", http.StatusInternalServerError)
+		return
 	}
+	hub.SendTo(chat.TypeMessage, chat.MessagePayload{From: sender.Username, To: msg.Receiverusername, Message: stored}, sender.Username, msg.Receiverusername)
+
+	// Respond back to the client
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": stored.ID})
 }
 
-func liteMesssageHandler(msg string, senderName string, receiver string, db *sql.DB) (err error) {
+var (
+	errUnknownMessage   = errors.New("no such message")
+	errEditWindow       = errors.New("the message can no longer be changed")
+	errConversationEdit = errors.New("messages of groups and channels cannot be edited or deleted")
+)
 
-	senderUserId, err := helpers.GetUserID(senderName)
+// changeMessage replaces the content of a private message of author, or
+// deletes it, and pushes the changed message to both users. Authors may
+// change their messages for window after sending them. Messages of groups
+// and channels cannot be changed, so naming a conversation fails with
+// errConversationEdit instead of changing the private message that happens
+// to have the same ID.
+func changeMessage(st *store.Store, hub *chat.Hub, window time.Duration, author chat.User, conversationID int, id int, content string, deleting bool) (store.PrivateMessage, error) {
+	if conversationID != 0 {
+		return store.PrivateMessage{}, errConversationEdit
+	}
+	msg, err := st.Messages.ByID(id)
+	if err == store.ErrNotFound || (err == nil && (msg.SenderID != author.ID || msg.DeletedAt != "")) {
+		return store.PrivateMessage{}, errUnknownMessage
+	} else if err != nil {
+		return store.PrivateMessage{}, err
+	}
+	sent, err := time.Parse(time.RFC3339, msg.Timestamp)
 	if err != nil {
-		fmt.Println(err)
+		return store.PrivateMessage{}, err
 	}
-	receiverUserId, err := helpers.GetUserID(receiver)
+	if time.Since(sent) > window {
+		return store.PrivateMessage{}, errEditWindow
+	}
+
+	typ := chat.TypeEdit
+	if deleting {
+		typ = chat.TypeDelete
+		msg, err = st.Messages.Delete(id)
+	} else {
+		msg, err = st.Messages.Edit(id, content)
+	}
+	if err == store.ErrNotFound {
+		// Deleted meanwhile
+		return store.PrivateMessage{}, errUnknownMessage
+	} else if err != nil {
+		return store.PrivateMessage{}, err
+	}
+
+	receiver, err := st.Users.ByID(msg.ReceiverID)
 	if err != nil {
-		fmt.Println(err)
+		return msg, err
 	}
-	log.Printf("Message from %s to %s: %s", senderName, receiver, msg)
+	return msg, hub.SendTo(typ, chat.MessagePayload{From: author.Username, To: receiver.Username, Message: msg}, author.Username, receiver.Username)
+}
 
-	_, err = db.Exec("INSERT INTO private_messages (content, sender_id, receiver_id) VALUES (?, ?, ?)", msg, senderUserId, receiverUserId)
+// editFrame edits or deletes a message like POST /edit-message and
+// /delete-message do, and acknowledges it.
+func editFrame(st *store.Store, hub *chat.Hub, window time.Duration, c *chat.Client, env chat.Envelope) error {
+	var edit chat.EditPayload
+	if err := chat.DecodePayload(env, &edit); err != nil {
+		return err
+	}
+	deleting := env.Type == chat.TypeDelete
+	if !deleting && strings.TrimSpace(edit.Content) == "" {
+		return &chat.Error{Code: chat.CodeBadPayload, Message: "the message is empty"}
+	}
+	msg, err := changeMessage(st, hub, window, c.User(), edit.Conversation, edit.MessageID, edit.Content, deleting)
 	if err != nil {
-		fmt.Println(err)
+		return frameError(err)
+	}
+	return c.Reply(chat.TypeAck, env.ID, chat.AckPayload{MessageID: msg.ID})
+}
+
+// editMessageHandler replaces the content of one of the current user's
+// private messages, or deletes it when deleting is set.
+func editMessageHandler(w http.ResponseWriter, r *http.Request, st *store.Store, hub *chat.Hub, window time.Duration, deleting bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	var edit struct {
+		ID           int    `json:"id"`
+		Conversation int    `json:"conversation"`
+		Message      string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if !deleting && strings.TrimSpace(edit.Message) == "" {
+		http.Error(w, "The message is empty", http.StatusBadRequest)
 		return
 	}
 
+	user := helpers.CurrentUser(r)
+	msg, err := changeMessage(st, hub, window, chat.User{ID: user.ID, Username: user.Username}, edit.Conversation, edit.ID, edit.Message, deleting)
+	if err != nil {
+		chatError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
+
+// messageRevisionsHandler shows moderators what a private message said
+// before it was edited or deleted.
+func messageRevisionsHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	id, err := convertQueryParams(r.URL.Query().Get("id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid message", http.StatusBadRequest)
+		return
+	}
+	msg, err := st.Messages.ByID(id)
+	if err == store.ErrNotFound {
+		err = errUnknownMessage
+	}
+	if err != nil {
+		chatError(w, err)
+		return
+	}
+	revisions, err := st.Messages.Revisions(id)
+	if err != nil {
+		chatError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": msg, "revisions": revisions})
+}
+
+var (
+	errUnknownConversation = errors.New("no such conversation")
+	errNotOwner            = errors.New("only the owner may do this")
+)
+
+var errUnknownAttachment = errors.New("no such attachment")
+
+// ownAttachment checks that userID uploaded the attachment id, so that
+// nobody can pass on files they were not sent. 0 is no attachment.
+func ownAttachment(st *store.Store, userID int, id int) error {
+	if id == 0 {
+		return nil
+	}
+	attachment, err := st.Attachments.ByID(id)
+	if err == store.ErrNotFound || (err == nil && attachment.UploaderID != userID) {
+		return errUnknownAttachment
+	}
 	return err
 }
 
-func getMessageHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	// Parse query parameters
-	senderUsername := r.URL.Query().Get("senderusername")
-	receiverUsername := r.URL.Query().Get("receiverusername")
+// uploadHandler stores a file posted as the "file" field of a multipart
+// form and returns the attachment, whose ID messages can then carry.
+func uploadHandler(w http.ResponseWriter, r *http.Request, st *store.Store, files attachments.Storage, maxSize int64) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Leave room for the rest of the form.
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, attachments.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Expected a multipart form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	upload, err := attachments.Save(files, file, maxSize)
+	switch err {
+	case nil:
+	case attachments.ErrTooLarge:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case attachments.ErrType:
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	default:
+		log.Println("Save attachment:", err)
+		http.Error(w, "Failed to store file", http.StatusInternalServerError)
+		return
+	}
+
+	// Browsers on Windows may send the whole path.
+	name := filepath.Base(strings.ReplaceAll(header.Filename, "\\", "/"))
+	if name == "." || name == "/" {
+		name = "attachment"
+	}
+	attachment := store.Attachment{
+		UploaderID:   helpers.CurrentUser(r).ID,
+		Name:         name,
+		ContentType:  upload.ContentType,
+		Size:         upload.Size,
+		StorageKey:   upload.Key,
+		ThumbnailKey: upload.ThumbnailKey,
+		Thumbnail:    upload.ThumbnailKey != "",
+	}
+	attachment.ID, err = st.Attachments.Create(attachment)
+	if err != nil {
+		files.Delete(upload.Key)
+		if upload.ThumbnailKey != "" {
+			files.Delete(upload.ThumbnailKey)
+		}
+		log.Println("Store attachment:", err)
+		http.Error(w, "Failed to store file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attachment)
+}
 
-	// Convert limit and offset to integers
-	pageStr := r.URL.Query().Get("page")
-	page, err := strconv.Atoi(pageStr)
+// attachmentHandler serves the attachment ?id=, or its thumbnail with
+// &thumbnail=1, to the users who can read a message carrying it.
+func attachmentHandler(w http.ResponseWriter, r *http.Request, st *store.Store, files attachments.Storage) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
 	if err != nil {
-		// handle error
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+	access, err := st.Attachments.CanAccess(id, helpers.CurrentUser(r).ID)
+	if err != nil {
+		chatError(w, err)
+		return
+	}
+	if !access {
+		chatError(w, errUnknownAttachment)
+		return
+	}
+	attachment, err := st.Attachments.ByID(id)
+	if err != nil {
+		chatError(w, err)
+		return
+	}
+
+	key, contentType := attachment.StorageKey, attachment.ContentType
+	if r.URL.Query().Get("thumbnail") == "1" && attachment.ThumbnailKey != "" {
+		key, contentType = attachment.ThumbnailKey, "image/png"
+	}
+	file, err := files.Open(key)
+	if err != nil {
+		chatError(w, err)
+		return
+	}
+	defer file.Close()
+
+	disposition := "attachment"
+	if attachments.IsImage(contentType) {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	io.Copy(w, file)
+}
+
+// maxConversationName limits the names of groups.
+const maxConversationName = 50
+
+// openConversation returns the conversation id as seen by userID. Groups
+// are only there for their members; channels are open to everyone.
+func openConversation(st *store.Store, userID int, id int) (store.Conversation, error) {
+	conv, err := st.Conversations.ByID(id, userID)
+	if err == store.ErrNotFound || (err == nil && conv.Kind == store.ConversationGroup && conv.Role == "") {
+		return store.Conversation{}, errUnknownConversation
+	}
+	return conv, err
+}
+
+// postToConversation stores a message of sender in a group or channel and
+// pushes it to every member. Posting to a channel joins it.
+func postToConversation(st *store.Store, hub *chat.Hub, sender chat.User, id int, content string, attachmentID int) (store.PrivateMessage, error) {
+	conv, err := openConversation(st, sender.ID, id)
+	if err != nil {
+		return store.PrivateMessage{}, err
+	}
+	if err := ownAttachment(st, sender.ID, attachmentID); err != nil {
+		return store.PrivateMessage{}, err
+	}
+	if conv.Role == "" {
+		if err := st.Conversations.AddMember(id, sender.ID, store.MemberRole); err != nil && err != store.ErrConflict {
+			return store.PrivateMessage{}, err
+		}
+	}
+	msg, err := st.Conversations.Post(id, sender.ID, content, attachmentID)
+	if err != nil {
+		return store.PrivateMessage{}, err
+	}
+	members, err := unblockedMembers(st, sender.ID, id)
+	if err != nil {
+		return msg, err
+	}
+	return msg, hub.SendTo(chat.TypeMessage, chat.MessagePayload{From: sender.Username, Conversation: id, Message: msg}, members...)
+}
+
+// unblockedMembers returns the members of a conversation that neither
+// blocked senderID nor were blocked by them, who are the ones its messages
+// are pushed to.
+func unblockedMembers(st *store.Store, senderID int, id int) ([]string, error) {
+	members, err := st.Conversations.Members(id)
+	if err != nil {
+		return nil, err
+	}
+	var reached []string
+	for _, username := range members {
+		memberID, err := userID(st, username)
+		if err != nil {
+			return nil, err
+		}
+		blocked, err := st.Privacy.IsBlocked(memberID, senderID)
+		if err == nil && !blocked {
+			blocked, err = st.Privacy.IsBlocked(senderID, memberID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !blocked {
+			reached = append(reached, username)
+		}
+	}
+	return reached, nil
+}
+
+// conversationPage returns a page of the messages of a group or channel.
+// Opening it at its newest messages marks them read for members.
+func conversationPage(st *store.Store, own chat.User, id int, q store.MessageQuery) (store.MessagePage, error) {
+	conv, err := openConversation(st, own.ID, id)
+	if err != nil {
+		return store.MessagePage{}, err
+	}
+	page, err := st.Conversations.Messages(id, q)
+	if err != nil || conv.Role == "" || !q.Before.IsZero() || !q.After.IsZero() || len(page.Messages) == 0 {
+		return page, err
+	}
+	return page, st.Conversations.MarkRead(id, own.ID, page.Messages[0].ID)
+}
+
+// frameError turns the errors of the chat helpers into error frames.
+func frameError(err error) error {
+	switch err {
+	case errUnknownConversation:
+		return &chat.Error{Code: chat.CodeUnknownConversation, Message: err.Error()}
+	case errUnknownMessage:
+		return &chat.Error{Code: chat.CodeUnknownMessage, Message: err.Error()}
+	case errUnknownAttachment:
+		return &chat.Error{Code: chat.CodeUnknownAttachment, Message: err.Error()}
+	case errUnknownUser:
+		return &chat.Error{Code: chat.CodeUnknownUser, Message: err.Error()}
+	case errNotOwner, errEditWindow:
+		return &chat.Error{Code: chat.CodeForbidden, Message: err.Error()}
+	case errConversationEdit:
+		return &chat.Error{Code: chat.CodeUnsupported, Message: err.Error()}
+	case errBlocked, errBlocking:
+		return &chat.Error{Code: chat.CodeBlocked, Message: err.Error()}
+	case errNoMessages, errNotContacted:
+		return &chat.Error{Code: chat.CodePrivacy, Message: err.Error()}
+	}
+	return err
+}
+
+// chatError answers a chat request that failed with err.
+func chatError(w http.ResponseWriter, err error) {
+	switch err {
+	case errUnknownConversation:
+		http.Error(w, "No such conversation", http.StatusNotFound)
+	case errUnknownMessage:
+		http.Error(w, "No such message", http.StatusNotFound)
+	case errUnknownAttachment:
+		http.Error(w, "No such attachment", http.StatusNotFound)
+	case errUnknownUser:
+		http.Error(w, "No such user", http.StatusNotFound)
+	case errNotOwner:
+		http.Error(w, "Only the owner of the group may do this", http.StatusForbidden)
+	case errEditWindow:
+		http.Error(w, "The message can no longer be changed", http.StatusForbidden)
+	case errConversationEdit:
+		http.Error(w, "Messages of groups and channels cannot be edited or deleted", http.StatusBadRequest)
+	case errBlocked, errBlocking, errNoMessages, errNotContacted:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Println("Chat:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// conversationsHandler lists the current user's groups and every channel on
+// GET, and creates a group of the current user and the named members on
+// POST.
+func conversationsHandler(w http.ResponseWriter, r *http.Request, st *store.Store, hub *chat.Hub) {
+	user := helpers.CurrentUser(r)
+	switch r.Method {
+	case http.MethodGet:
+		conversations, err := st.Conversations.ForUser(user.ID)
+		if err != nil {
+			chatError(w, err)
+			return
+		}
+		if conversations == nil {
+			conversations = []store.Conversation{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conversations)
+
+	case http.MethodPost:
+		var group struct {
+			Name    string   `json:"name"`
+			Members []string `json:"members"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		group.Name = strings.TrimSpace(group.Name)
+		if group.Name == "" || len(group.Name) > maxConversationName {
+			http.Error(w, fmt.Sprintf("The name must have 1 to %d characters", maxConversationName), http.StatusBadRequest)
+			return
+		}
+		var memberIDs []int
+		for _, username := range group.Members {
+			memberID, err := userID(st, username)
+			if err != nil {
+				chatError(w, err)
+				return
+			}
+			if memberID == 0 {
+				http.Error(w, "No such user "+username, http.StatusNotFound)
+				return
+			}
+			// Adding someone to a group lets the owner message them, so
+			// the same rules apply as to private messages.
+			if memberID != user.ID {
+				if err := canMessage(st, user.ID, memberID); err != nil {
+					chatError(w, err)
+					return
+				}
+			}
+			memberIDs = append(memberIDs, memberID)
+		}
+
+		id, err := st.Conversations.CreateGroup(group.Name, user.ID, memberIDs)
+		if err != nil {
+			chatError(w, err)
+			return
+		}
+		for _, memberID := range memberIDs {
+			notifyMember(st, hub, id, memberID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id})
+
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// notifyMember sends a group to a user who was added to it.
+func notifyMember(st *store.Store, hub *chat.Hub, id int, userID int) {
+	conv, err := st.Conversations.ByID(id, userID)
+	if err == nil {
+		var user store.User
+		user, err = st.Users.ByID(userID)
+		if err == nil {
+			err = hub.SendTo(chat.TypeConversation, conv, user.Username)
+		}
+	}
+	if err != nil {
+		log.Printf("Notify user %d of conversation %d: %v", userID, id, err)
+	}
+}
+
+// conversationMessagesHandler returns a page of the messages of a group or
+// channel, selected like GET /get-message.
+func conversationMessagesHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	id, err := convertQueryParams(r.URL.Query().Get("conversation"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid conversation", http.StatusBadRequest)
+		return
+	}
+	limit, err := convertQueryParams(r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
 	}
-
-	const limit = 10
-	offset := (page - 1) * limit
-
-	// Fetch messages
-	privateMessages, err := helpers.GetPrivateMessages(db, senderUsername, receiverUsername, limit, offset)
+	q, err := messageQuery(r.URL.Query().Get("before"), r.URL.Query().Get("after"), limit)
 	if err != nil {
-		fmt.Println("Error fetching messages:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Handle case when no messages are found
-	if len(privateMessages) == 0 {
-		w.Write([]byte("[]")) // Send empty JSON array
+	user := helpers.CurrentUser(r)
+	page, err := conversationPage(st, chat.User{ID: user.ID, Username: user.Username}, id, q)
+	if err != nil {
+		chatError(w, err)
 		return
 	}
-
-	// Send messages as JSON
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(privateMessages); err != nil {
-		fmt.Println("Error encoding messages:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	json.NewEncoder(w).Encode(page)
 }
 
-func messageHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+// membershipRequest is the body of the requests that join, leave or change
+// the members of a conversation.
+type membershipRequest struct {
+	Conversation int    `json:"conversation"`
+	Username     string `json:"username"`
+}
 
-	var msg struct {
-		Message          string `json:"message"`
-		SenderUsername   string `json:"senderusername"`
-		Receiverusername string `json:"receiverusername"`
-		Id               int    `json:"Id"`
+func decodeMembership(w http.ResponseWriter, r *http.Request) (membershipRequest, bool) {
+	var req membershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Conversation <= 0 {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return req, false
 	}
-	err := json.NewDecoder(r.Body).Decode(&msg)
-	fmt.Println("this is msg", msg.Message, "this is user that sent messsage:", msg.SenderUsername, "and receiver", msg.Receiverusername)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	return req, true
+}
+
+// joinConversationHandler makes the current user a member of a channel, so
+// that its messages reach them and count as unread. Groups can only be
+// joined by being added.
+func joinConversationHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
-
-	// Here you would insert the message into your database
-	// db.Query("INSERT INTO messages (content, username) VALUES (?, ?)", msg.Message, msg.Username)
-	senderUserId, err := helpers.GetUserID(msg.SenderUsername)
-	if err != nil {
-		fmt.Println(err)
+	req, ok := decodeMembership(w, r)
+	if !ok {
+		return
 	}
-	receiverUserId, err := helpers.GetUserID(msg.Receiverusername)
+	user := helpers.CurrentUser(r)
+	conv, err := openConversation(st, user.ID, req.Conversation)
 	if err != nil {
-		fmt.Println(err)
+		chatError(w, err)
+		return
 	}
-	_, err = db.Exec("INSERT INTO private_messages (content, sender_id, receiver_id) VALUES (?, ?, ?)", msg.Message, senderUserId, receiverUserId)
-	if err != nil {
-		fmt.Println(err)
+	if conv.Kind != store.ConversationChannel {
+		http.Error(w, "Groups can only be joined by being added", http.StatusForbidden)
+		return
+	}
+	if err := st.Conversations.AddMember(conv.ID, user.ID, store.MemberRole); err != nil && err != store.ErrConflict {
+		chatError(w, err)
 		return
 	}
-	// Respond back to the client
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
-func handleGithubLogin(w http.ResponseWriter, r *http.Request) {
-	githubClientID := "25d7ff2314f58883dc2a"
-
-	// Create the dynamic redirect URL for login
-	redirectURL := fmt.Sprintf(
-		"https://github.com/login/oauth/authorize?client_id=%s&redirect_uri=%s",
-		githubClientID,
-		"http://localhost:8080/callbackgithub",
-	)
-
-	http.Redirect(w, r, redirectURL, http.StatusMovedPermanently)
-
+// leaveConversationHandler removes the current user from a group or channel.
+func leaveConversationHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	req, ok := decodeMembership(w, r)
+	if !ok {
+		return
+	}
+	err := st.Conversations.RemoveMember(req.Conversation, helpers.CurrentUser(r).ID)
+	if err == store.ErrNotFound {
+		http.Error(w, "You are not a member", http.StatusNotFound)
+		return
+	} else if err != nil {
+		chatError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
-func handleCallback(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-
-	if r.FormValue("state") != helpers.RandomState {
-		fmt.Println("State is not valid")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+// conversationMembersHandler lists the members of a conversation on GET. The
+// owner of a group adds a member on POST and removes one on DELETE.
+func conversationMembersHandler(w http.ResponseWriter, r *http.Request, st *store.Store, hub *chat.Hub) {
+	user := helpers.CurrentUser(r)
+	if r.Method == http.MethodGet {
+		id, err := convertQueryParams(r.URL.Query().Get("conversation"))
+		if err != nil || id <= 0 {
+			http.Error(w, "Invalid conversation", http.StatusBadRequest)
+			return
+		}
+		if _, err := openConversation(st, user.ID, id); err != nil {
+			chatError(w, err)
+			return
+		}
+		members, err := st.Conversations.Members(id)
+		if err != nil {
+			chatError(w, err)
+			return
+		}
+		if members == nil {
+			members = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(members)
 		return
 	}
-
-	token, err := helpers.GoogleOauthConfig.Exchange(context.Background(), r.FormValue("code"))
-
-	if err != nil {
-		fmt.Fprintf(w, "Could not get token: %s\n", err.Error())
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
 
-	resp, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + token.AccessToken)
+	req, ok := decodeMembership(w, r)
+	if !ok {
+		return
+	}
+	conv, err := openConversation(st, user.ID, req.Conversation)
+	if err == nil && conv.Role != store.MemberOwner {
+		err = errNotOwner
+	}
 	if err != nil {
-		fmt.Printf("Could not create get request: %s/n", err.Error())
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		chatError(w, err)
 		return
 	}
-
-	defer resp.Body.Close()
-	content, err := ioutil.ReadAll(resp.Body)
+	memberID, err := userID(st, req.Username)
+	if err == nil && memberID == 0 {
+		err = errUnknownUser
+	}
 	if err != nil {
-		fmt.Printf("Could not  parse response : %s/n", err.Error())
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		chatError(w, err)
 		return
 	}
 
-	var GoogleResponse helpers.GoogleResponse
-	if err := json.Unmarshal(content, &GoogleResponse); err != nil {
-		fmt.Printf("Error unmarshalling response: %s\n", err.Error())
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	if r.Method == http.MethodPost {
+		if memberID != user.ID {
+			err = canMessage(st, user.ID, memberID)
+		}
+		if err == nil {
+			err = st.Conversations.AddMember(conv.ID, memberID, store.MemberRole)
+		}
+		if err == store.ErrConflict {
+			http.Error(w, req.Username+" is a member already", http.StatusConflict)
+			return
+		}
+		if err == nil {
+			notifyMember(st, hub, conv.ID, memberID)
+		}
+	} else {
+		if memberID == user.ID {
+			http.Error(w, "The owner cannot be removed", http.StatusConflict)
+			return
+		}
+		err = st.Conversations.RemoveMember(conv.ID, memberID)
+		if err == store.ErrNotFound {
+			http.Error(w, req.Username+" is not a member", http.StatusNotFound)
+			return
+		}
+	}
+	if err != nil {
+		chatError(w, err)
 		return
 	}
-
-	helpers.SQLAuthorize(w, r, db, GoogleResponse.Name, GoogleResponse.Email)
-
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
-func HandleCallbackGithub(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	code := r.URL.Query().Get("code")
-
-	githubAccessToken := helpers.GetGithubAccessToken(code)
-
-	username := helpers.GetGithubData(githubAccessToken)
-
-	helpers.SQLAuthorize(w, r, db, username, "")
 
+// clientConfigHandler tells the frontend the settings it cannot guess from
+// the page URL.
+func clientConfigHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"websocketURL":      cfg.WebSocketURL(),
+		"editWindowSeconds": int(cfg.Chat.EditWindow.Seconds()),
+		"attachmentMaxSize": cfg.Attachments.MaxSize,
+		"commentMaxDepth":   cfg.Comments.MaxDepth,
+	})
 }
 
-func handleVote(w http.ResponseWriter, r *http.Request, voteType string, comment bool) {
+func handleVote(w http.ResponseWriter, r *http.Request, st *store.Store, voteType string, comment bool) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
@@ -429,33 +1439,21 @@ func handleVote(w http.ResponseWriter, r *http.Request, voteType string, comment
 		return
 	}
 
-	db, err := helpers.GetDbConnection()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to get DB connection: %v", err), http.StatusInternalServerError)
-	}
-	defer db.Close()
-
-	userSession, err := helpers.ValidateSessionFromCookie(w, r)
-	if err != nil {
-		http.Error(w, "Invalid username", http.StatusBadRequest)
-		return
-	}
-
-	userID := helpers.SQLSelectUserID(db, userSession.Username)
-
-	err = helpers.SQLinsertVote(vote.PostID, userID, voteType, comment)
+	err = st.Votes.Vote(vote.PostID, helpers.CurrentUser(r).ID, voteType, comment)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	likesCount, err := helpers.SQLGetVotesCount(db, vote.PostID, "like", comment)
+	likesCount, err := st.Votes.Count(vote.PostID, "like", comment)
 	if err != nil {
-		http.Error(w, "failed to get likes count for post ID %d: %w", vote.PostID)
+		http.Error(w, fmt.Sprintf("failed to get likes count for post ID %d: %v", vote.PostID, err), http.StatusInternalServerError)
+		return
 	}
-	dislikesCount, err := helpers.SQLGetVotesCount(db, vote.PostID, "dislike", comment)
+	dislikesCount, err := st.Votes.Count(vote.PostID, "dislike", comment)
 	if err != nil {
-		http.Error(w, "failed to get likes count for post ID %d: %w", vote.PostID)
+		http.Error(w, fmt.Sprintf("failed to get dislikes count for post ID %d: %v", vote.PostID, err), http.StatusInternalServerError)
+		return
 	}
 
 	response := map[string]int{
@@ -466,23 +1464,23 @@ func handleVote(w http.ResponseWriter, r *http.Request, voteType string, comment
 	json.NewEncoder(w).Encode(response)
 }
 
-func likeHandler(w http.ResponseWriter, r *http.Request) {
-	handleVote(w, r, "like", false)
+func likeHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	handleVote(w, r, st, "like", false)
 }
 
-func dislikeHandler(w http.ResponseWriter, r *http.Request) {
-	handleVote(w, r, "dislike", false)
+func dislikeHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	handleVote(w, r, st, "dislike", false)
 }
 
-func commentLikeHandler(w http.ResponseWriter, r *http.Request) {
-	handleVote(w, r, "like", true)
+func commentLikeHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	handleVote(w, r, st, "like", true)
 }
 
-func commentDislikeHandler(w http.ResponseWriter, r *http.Request) {
-	handleVote(w, r, "dislike", true)
+func commentDislikeHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	handleVote(w, r, st, "dislike", true)
 }
 
-func filterPage(w http.ResponseWriter, r *http.Request) {
+func filterPage(w http.ResponseWriter, r *http.Request, st *store.Store) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -511,37 +1509,24 @@ func filterPage(w http.ResponseWriter, r *http.Request) {
 		catergories = append(catergories, categorieToAdd)
 	}
 
-	db, err := helpers.GetDbConnection()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to get DB connection: %v", err), http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
-
-	posts, err := filterPosts(db, catergories)
+	posts, err := st.Posts.ByCategories(catergories)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to filter posts: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	comments, err := getCommentsFromDatabase(db)
+	comments, err := st.Comments.All()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to retrieve comments: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	posts = addCommentsToPost(posts, comments)
-	likesToPostsAndComments(db, posts)
-
-	for _, v := range posts {
-		likesCount, err := helpers.SQLGetVotesCount(db, v.ID, "like", false)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to get likes count for post ID %d: %v", v.ID, err), http.StatusInternalServerError)
-			return
-		}
-		v.Likes = likesCount
+	if err := likesToPostsAndComments(st, posts); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	rawData := parsingHomePageData(w, r, db)
+	rawData := parsingHomePageData(r, st)
 	data := HomePageData{
 		Username:   rawData.Username,
 		Usernames:  rawData.Usernames,
@@ -549,62 +1534,119 @@ func filterPage(w http.ResponseWriter, r *http.Request) {
 		UsernameId: rawData.UsernameId,
 		Userlist:   rawData.Userlist,
 	}
+	writeHomePageData(w, data)
+}
 
-	if err != nil {
-		// if error exists, mean there is no session and show view page only.
-		var rawMessage = "Usersession is not valid! Proceed to registration page!"
-
-		message, err := json.Marshal(rawMessage)
-		if err != nil {
-			fmt.Printf("Could now marshal data %s\n", err)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(message)
-	} else {
-		jsonData, err := json.Marshal(data)
-		if err != nil {
-			fmt.Printf("Could now marshal data %s\n", err)
+// maxSearchPage limits the results of one search request.
+const maxSearchPage = 50
+
+// searchHandler answers GET /search?q=<text>&type=<kind>&author=<username>
+// &category=<id>&page=<n>&limit=<n>. type may be repeated; private messages
+// are only searched for logged in users, among their own messages.
+func searchHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	params := r.URL.Query()
+	q := store.SearchQuery{
+		Text:   params.Get("q"),
+		Kinds:  params["type"],
+		Author: params.Get("author"),
+	}
+	if strings.TrimSpace(q.Text) == "" {
+		http.Error(w, "Missing search text", http.StatusBadRequest)
+		return
+	}
+	for _, kind := range q.Kinds {
+		if kind != store.SearchPost && kind != store.SearchComment && kind != store.SearchMessage {
+			http.Error(w, "Invalid type", http.StatusBadRequest)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(jsonData)
+	}
+	category, err := convertQueryParams(params.Get("category"))
+	if err != nil || category < 0 {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+	page, err := convertQueryParams(params.Get("page"))
+	if err != nil || page < 0 {
+		http.Error(w, "Invalid page", http.StatusBadRequest)
+		return
+	}
+	limit, err := convertQueryParams(params.Get("limit"))
+	if err != nil || limit < 0 {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	if limit == 0 {
+		limit = 20
+	} else if limit > maxSearchPage {
+		limit = maxSearchPage
+	}
+	if page == 0 {
+		page = 1
+	}
+	q.Category = category
+	q.Limit = limit
+	q.Offset = (page - 1) * limit
+	if user := helpers.CurrentUser(r); user != nil {
+		q.UserID = user.ID
+	} else if len(q.Kinds) == 0 {
+		q.Kinds = []string{store.SearchPost, store.SearchComment}
 	}
 
+	results, err := st.Search.Search(q)
+	if err != nil {
+		log.Println("Search:", err)
+		http.Error(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
 }
 
-func addCommentsToPost(posts []Post, comments []Comment) (modPosts []Post) {
-	modPosts = make([]Post, len(posts))
+// addCommentsToPost puts the comments of every post together into threads:
+// a post gets the comments on it, each with its replies nested below.
+func addCommentsToPost(posts []store.Post, comments []store.Comment) (modPosts []store.Post) {
+	ids := make(map[int]bool, len(comments))
+	for _, comment := range comments {
+		ids[comment.ID] = true
+	}
+	replies := make(map[int][]store.Comment)
+	for _, comment := range comments {
+		parentID := comment.ParentID
+		if !ids[parentID] {
+			parentID = 0
+		}
+		// Comments on the post itself are keyed by the negated post ID.
+		if parentID == 0 {
+			parentID = -comment.PostID
+		}
+		replies[parentID] = append(replies[parentID], comment)
+	}
+
+	modPosts = make([]store.Post, len(posts))
 	for i, post := range posts {
 		modPosts[i] = post
-		for _, comment := range comments {
-			if posts[i].ID == comment.PostID {
-				modPosts[i].Comments = append(modPosts[i].Comments, comment)
-			}
-		}
+		modPosts[i].Comments, _ = commentThread(replies, -post.ID, 0)
 	}
 	return modPosts
 }
 
-func admin(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	userSession, error := helpers.ValidateSessionFromCookie(w, r)
-
-	if userSession == nil {
-		helpers.DeleteCookie(w, r)
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
-		return
-	}
-	if userSession != nil {
-		if userSession.Username != "Admin" {
-			http.Redirect(w, r, "/", http.StatusForbidden)
-			return
-		}
-		if error != nil {
-			http.Error(w, "This is error", http.StatusBadRequest)
-			return
+// commentThread returns the replies to parentID at depth, each with its own
+// replies, and how many of them are not deleted.
+func commentThread(replies map[int][]store.Comment, parentID int, depth int) ([]store.Comment, int) {
+	thread := replies[parentID]
+	count := 0
+	for i := range thread {
+		thread[i].Depth = depth
+		thread[i].Replies, thread[i].ReplyCount = commentThread(replies, thread[i].ID, depth+1)
+		count += thread[i].ReplyCount
+		if !thread[i].Deleted {
+			count++
 		}
 	}
+	return thread, count
+}
 
+func admin(w http.ResponseWriter, r *http.Request, st *store.Store) {
 	var moderationRequests []string
 
 	err := r.ParseForm()
@@ -618,20 +1660,20 @@ func admin(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		decline := r.FormValue("decline")
 
 		if accept != "" {
-			helpers.SQLAnswerModerationRequest(db, accept, "SetToModerator")
+			answerModerationRequest(st, accept, "moderator")
 		} else if decline != "" {
-			helpers.SQLAnswerModerationRequest(db, decline, "")
+			answerModerationRequest(st, decline, "")
 		} else {
 			fmt.Println("Excuse-moi?")
 		}
 
 		if remove != "" {
-			helpers.SQLAnswerModerationRequest(db, remove, "RemoveModeration")
+			answerModerationRequest(st, remove, "user")
 		}
 	}
 
-	moderationRequests, _ = helpers.SQLSelectModeratorRequest(db, false)
-	moderators, _ := helpers.SQLSelectModeratorRequest(db, true)
+	moderationRequests, _ = st.Users.ModeratorApplicants()
+	moderators, _ := st.Users.Moderators()
 
 	data := HomePageData{
 		Moderators:         moderators,
@@ -654,49 +1696,283 @@ func admin(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 }
 
-func submitComment(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+// answerModerationRequest closes the moderator application of username and
+// gives them role, unless role is empty.
+func answerModerationRequest(st *store.Store, username string, role string) {
+	if role != "" {
+		if err := st.Users.SetRole(username, role); err != nil {
+			log.Println(err)
+		}
+	}
+	if err := st.Users.ClearModeratorApplication(username); err != nil {
+		log.Println(err)
+	}
+}
+
+// replyHook is called after a reply to parent was stored.
+type replyHook func(parent store.Comment, reply store.Comment)
+
+// notifyReply sends a reply frame to the author of the parent comment,
+// unless they replied to themselves.
+func notifyReply(hub *chat.Hub) replyHook {
+	return func(parent store.Comment, reply store.Comment) {
+		if parent.Username == "" || parent.Username == reply.Username {
+			return
+		}
+		reply.PostedAgo = helpers.PostedAgo(reply.CreatedAt)
+		err := hub.SendTo(chat.TypeReply, chat.ReplyPayload{
+			From:     reply.Username,
+			PostID:   reply.PostID,
+			ParentID: parent.ID,
+			Comment:  reply,
+		}, parent.Username)
+		if err != nil {
+			log.Println("Failed to notify reply:", err)
+		}
+	}
+}
+
+// commentDepth returns how deep comment is nested, 0 for comments on the
+// post itself.
+func commentDepth(st *store.Store, comment store.Comment) (int, error) {
+	depth := 0
+	for comment.ParentID != 0 {
+		parent, err := st.Comments.ByID(comment.ParentID)
+		if err == store.ErrNotFound {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		comment = parent
+		depth++
+	}
+	return depth, nil
+}
+
+func submitComment(w http.ResponseWriter, r *http.Request, st *store.Store, maxDepth int, hooks []replyHook) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	// Parse the request body as JSON
+	// Parse the request body as JSON. ParentID is set for replies.
 	var requestBody struct {
-		PostID  string `json:"postID"`
-		Comment string `json:"comment"`
+		PostID   string `json:"postID"`
+		ParentID int    `json:"parentID"`
+		Comment  string `json:"comment"`
 	}
 	err := json.NewDecoder(r.Body).Decode(&requestBody)
 	if err != nil {
 		http.Error(w, "Error parsing JSON", http.StatusBadRequest)
 		return
 	}
-
-	userSession, _ := helpers.ValidateSessionFromCookie(w, r)
-	if err != nil {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+
+	authorID := helpers.CurrentUser(r).ID
+	// Extract post ID and comment from the JSON request body
+	postID, err := strconv.Atoi(requestBody.PostID)
+	comment := requestBody.Comment
+	if err != nil {
+		http.Error(w, "Missing post ID", http.StatusBadRequest)
+		return
+	}
+
+	if comment == "" {
+		http.Error(w, "Creating empty comment is forbidden.", http.StatusBadRequest)
+		return
+	}
+
+	var parent store.Comment
+	depth := 0
+	if requestBody.ParentID != 0 {
+		parent, err = st.Comments.ByID(requestBody.ParentID)
+		if err == store.ErrNotFound || (err == nil && (parent.Deleted || parent.PostID != postID)) {
+			http.Error(w, "Unknown parent comment", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Error reading comment", http.StatusInternalServerError)
+			return
+		}
+		if depth, err = commentDepth(st, parent); err != nil {
+			http.Error(w, "Error reading comment", http.StatusInternalServerError)
+			return
+		}
+		if depth++; depth > maxDepth {
+			http.Error(w, fmt.Sprintf("Replies may only nest %d deep", maxDepth), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id, err := st.Comments.Create(postID, requestBody.ParentID, authorID, comment)
+	if err != nil {
+		http.Error(w, "Error inserting comment", http.StatusInternalServerError)
+		return
+	}
+	if requestBody.ParentID != 0 {
+		reply, err := st.Comments.ByID(id)
+		if err != nil {
+			log.Println("Failed to read reply:", err)
+			return
+		}
+		reply.Depth = depth
+		for _, hook := range hooks {
+			hook(parent, reply)
+		}
+	}
+
+	// Redirect to the appropriate page, e.g., assuming you have an "homepage.html" page
+	//http.Redirect(w, r, "homepage.html", http.StatusSeeOther)
+}
+
+// deleteCommentHandler deletes a comment of the current user, or any comment
+// for moderators. Comments with replies are left as placeholders.
+func deleteCommentHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	comment, err := st.Comments.ByID(req.ID)
+	if err == store.ErrNotFound || (err == nil && comment.Deleted) {
+		http.Error(w, "Unknown comment", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !authorOrModerator(w, st, helpers.CurrentUser(r), comment.Username) {
+		return
+	}
+
+	err = st.Comments.Delete(req.ID)
+	if err == store.ErrNotFound {
+		http.Error(w, "Unknown comment", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Delete comment:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// authorOrModerator reports whether user wrote what author wrote or is a
+// moderator, and refuses the request otherwise.
+func authorOrModerator(w http.ResponseWriter, st *store.Store, user *store.User, author string) bool {
+	if user.Username == author {
+		return true
+	}
+	allowed, err := helpers.RoleAllows(st.Roles, user.Role, helpers.Moderator)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}
+	return allowed
+}
+
+// editCommentHandler replaces the content of a comment, for its author and
+// moderators.
+func editCommentHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID      int    `json:"id"`
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		http.Error(w, "The comment is empty", http.StatusBadRequest)
+		return
+	}
+
+	comment, err := st.Comments.ByID(req.ID)
+	if err == store.ErrNotFound || (err == nil && comment.Deleted) {
+		http.Error(w, "Unknown comment", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	user := helpers.CurrentUser(r)
+	if !authorOrModerator(w, st, user, comment.Username) {
+		return
+	}
+
+	err = st.Comments.Edit(req.ID, user.ID, req.Content)
+	if err == store.ErrNotFound {
+		http.Error(w, "Unknown comment", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Edit comment:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// commentRevisionsHandler shows what a comment said before its edits.
+func commentRevisionsHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	id, err := convertQueryParams(r.URL.Query().Get("id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid comment", http.StatusBadRequest)
+		return
+	}
+	comment, err := st.Comments.ByID(id)
+	if err == store.ErrNotFound || (err == nil && comment.Deleted) {
+		http.Error(w, "Unknown comment", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	userID := helpers.SQLSelectUserID(db, userSession.Username)
-	// Extract post ID and comment from the JSON request body
-	postID := requestBody.PostID
-	comment := requestBody.Comment
-	if postID == "" {
-		http.Error(w, "Missing post ID", http.StatusBadRequest)
-		fmt.Println("Post ID is missing, post ID is:", postID, "the comment is:", comment)
+	revisions, err := st.Comments.Revisions(id)
+	if err != nil {
+		log.Println("Comment revisions:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"comment":   comment,
+		"revisions": diffRevisions(revisions, store.Revision{Content: comment.Content}),
+	})
+}
 
-	if comment == "" {
-		http.Error(w, "Creating empty comment is forbidden.", http.StatusBadRequest)
-		fmt.Println("comment is empty!")
-	} else {
-		if err := helpers.SQLInsertComment(db, postID, comment, userID); err != nil {
-			http.Error(w, "Error inserting comment", http.StatusInternalServerError)
-			return
+// revisionDiff is a revision with the changes the next version made to it.
+type revisionDiff struct {
+	store.Revision
+	TitleDiff []helpers.DiffPart `json:"titleDiff,omitempty"`
+	Diff      []helpers.DiffPart `json:"diff"`
+}
+
+// diffRevisions compares every revision with the one after it, and the last
+// with current.
+func diffRevisions(revisions []store.Revision, current store.Revision) []revisionDiff {
+	diffs := make([]revisionDiff, len(revisions))
+	for i, revision := range revisions {
+		next := current
+		if i+1 < len(revisions) {
+			next = revisions[i+1]
+		}
+		diffs[i] = revisionDiff{Revision: revision, Diff: helpers.Diff(revision.Content, next.Content)}
+		if revision.Title != next.Title {
+			diffs[i].TitleDiff = helpers.Diff(revision.Title, next.Title)
 		}
 	}
-
-	// Redirect to the appropriate page, e.g., assuming you have an "homepage.html" page
-	//http.Redirect(w, r, "homepage.html", http.StatusSeeOther)
+	return diffs
 }
 
 func addComment(w http.ResponseWriter, r *http.Request) {
@@ -720,18 +1996,15 @@ func addComment(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func createPost(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func createPost(w http.ResponseWriter, r *http.Request, st *store.Store) {
 	fmt.Println("createPost got called")
 
-	(w).Header().Set("Access-Control-Allow-Origin", "*") // or a specific domain
-	(w).Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	(w).Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	type PostData struct {
+		Title       string   `json:"title"`
 		PostContent string   `json:"content"`
 		Categories  []string `json:"categories"`
 	}
@@ -746,18 +2019,14 @@ func createPost(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 	defer r.Body.Close()
 
-	userSession, _ := helpers.ValidateSessionFromCookie(w, r)
-	userID := helpers.SQLSelectUserID(db, userSession.Username)
-	if postData.PostContent != "" {
-		if err := helpers.SQLInsertPost(db, postData.PostContent, userID); err != nil {
-			http.Error(w, "failed to insert post: %w", http.StatusBadRequest)
-		}
-	} else {
+	authorID := helpers.CurrentUser(r).ID
+	if postData.PostContent == "" {
 		http.Error(w, "Creating empty post is forbidden.", http.StatusBadRequest)
+		return
 	}
-	postID, err := helpers.SQLLastPostID(db)
+	title, err := postTitle(postData.Title)
 	if err != nil {
-		http.Error(w, "Failed to get last post ID", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	var catergories []int
@@ -769,11 +2038,110 @@ func createPost(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		}
 		catergories = append(catergories, categorieToAdd)
 	}
-	helpers.SQLInsertCategorie(db, postID, catergories)
+	if _, err := st.Posts.Create(authorID, title, postData.PostContent, catergories); err != nil {
+		log.Println("[CREATEPOST]", err)
+		http.Error(w, "failed to insert post", http.StatusInternalServerError)
+		return
+	}
 
 	// http.Redirect(w, r, "homepage.html", http.StatusSeeOther)
 }
 
+// maxTitleLength is the length of the longest post title, in characters.
+const maxTitleLength = 120
+
+// postTitle returns title without surrounding space, or why posts cannot
+// have it.
+func postTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "", errors.New("posts need a title")
+	}
+	if len([]rune(title)) > maxTitleLength {
+		return "", fmt.Errorf("titles may be at most %d characters long", maxTitleLength)
+	}
+	return title, nil
+}
+
+// editPostHandler replaces the title and content of a post, for its author
+// and moderators.
+func editPostHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID      int    `json:"id"`
+		Title   string `json:"title"`
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		http.Error(w, "The post is empty", http.StatusBadRequest)
+		return
+	}
+	title, err := postTitle(req.Title)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	post, err := st.Posts.ByID(req.ID)
+	if err == store.ErrNotFound {
+		http.Error(w, "Unknown post", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	user := helpers.CurrentUser(r)
+	if !authorOrModerator(w, st, user, post.Username) {
+		return
+	}
+
+	err = st.Posts.Edit(req.ID, user.ID, title, req.Content)
+	if err == store.ErrNotFound {
+		http.Error(w, "Unknown post", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Edit post:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// postRevisionsHandler shows what a post said before its edits.
+func postRevisionsHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	id, err := convertQueryParams(r.URL.Query().Get("id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid post", http.StatusBadRequest)
+		return
+	}
+	post, err := st.Posts.ByID(id)
+	if err == store.ErrNotFound {
+		http.Error(w, "Unknown post", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	revisions, err := st.Posts.Revisions(id)
+	if err != nil {
+		log.Println("Post revisions:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"post":      post,
+		"revisions": diffRevisions(revisions, store.Revision{Title: post.Title, Content: post.Content}),
+	})
+}
+
 func serveCreatePostPage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -800,79 +2168,85 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "templates/newpage.html")
 }
 
-func likesToPostsAndComments(db *sql.DB, posts []Post) error {
+func likesToPostsAndComments(st *store.Store, posts []store.Post) error {
 	for i := range posts {
 		// Added time since post.
 		postAgo := helpers.PostedAgo(posts[i].CreatedAt)
 		posts[i].PostedAgo = postAgo
-		posts[i].CommentCount = helpers.SQLGetCommentCount(db, posts[i].ID)
+		commentCount, err := st.Comments.CountForPost(posts[i].ID)
+		if err != nil {
+			return fmt.Errorf("failed to count comments for post ID %d: %w", posts[i].ID, err)
+		}
+		posts[i].CommentCount = commentCount
 
-		likesCount, err := helpers.SQLGetVotesCount(db, posts[i].ID, "like", false)
+		likesCount, err := st.Votes.Count(posts[i].ID, "like", false)
 		if err != nil {
 			return fmt.Errorf("failed to get likes count for post ID %d: %w", posts[i].ID, err)
 		}
 		posts[i].Likes = likesCount
 
-		dislikesCount, err := helpers.SQLGetVotesCount(db, posts[i].ID, "dislike", false)
+		dislikesCount, err := st.Votes.Count(posts[i].ID, "dislike", false)
 		if err != nil {
 			return fmt.Errorf("failed to get dislikes count for post ID %d: %w", posts[i].ID, err)
 		}
 		posts[i].Dislikes = dislikesCount
 
-		for j := range posts[i].Comments {
+		if err := likesToComments(st, posts[i].Comments); err != nil {
+			return err
+		}
+	}
 
-			// adding time since comment.
-			commentAgo := helpers.PostedAgo(posts[i].Comments[j].CreatedAt)
-			posts[i].Comments[j].PostedAgo = commentAgo
+	return nil
+}
 
-			commentLikesCount, err := helpers.SQLGetVotesCount(db, posts[i].Comments[j].ID, "like", true)
-			if err != nil {
-				return fmt.Errorf("failed to get likes count for comment ID %d: %w", posts[i].Comments[j].ID, err)
-			}
-			posts[i].Comments[j].Likes = commentLikesCount
+// likesToComments fills in the votes and age of comments and their replies.
+func likesToComments(st *store.Store, comments []store.Comment) error {
+	for j := range comments {
 
-			commentDislikesCount, err := helpers.SQLGetVotesCount(db, posts[i].Comments[j].ID, "dislike", true)
-			if err != nil {
-				return fmt.Errorf("failed to get dislikes count for comment ID %d: %w", posts[i].Comments[j].ID, err)
-			}
-			posts[i].Comments[j].Dislikes = commentDislikesCount
+		// adding time since comment.
+		commentAgo := helpers.PostedAgo(comments[j].CreatedAt)
+		comments[j].PostedAgo = commentAgo
+
+		commentLikesCount, err := st.Votes.Count(comments[j].ID, "like", true)
+		if err != nil {
+			return fmt.Errorf("failed to get likes count for comment ID %d: %w", comments[j].ID, err)
 		}
-	}
+		comments[j].Likes = commentLikesCount
+
+		commentDislikesCount, err := st.Votes.Count(comments[j].ID, "dislike", true)
+		if err != nil {
+			return fmt.Errorf("failed to get dislikes count for comment ID %d: %w", comments[j].ID, err)
+		}
+		comments[j].Dislikes = commentDislikesCount
 
+		if err := likesToComments(st, comments[j].Replies); err != nil {
+			return err
+		}
+	}
 	return nil
 }
-func parsingHomePageData(w http.ResponseWriter, r *http.Request, db *sql.DB) (data HomePageData) {
-	userlist, err := helpers.GetUsernamesIds(db, 1)
-	if err != nil {
-		fmt.Println("[ERROR]", err)
-	}
-	userSession, err := helpers.ValidateSessionFromCookie(w, r)
+func parsingHomePageData(r *http.Request, st *store.Store) (data HomePageData) {
 	var username string
 	var role string
 	var moderationRequests []string
 	var count int
-	if userSession != nil {
-		username = userSession.Username
-		role, err = helpers.SQLGetUserRole(db, userSession.Username)
-		if err != nil {
-			fmt.Println("Failed to get role")
-		}
-		moderationRequests, _ = helpers.SQLSelectModeratorRequest(db, false)
-		count, _ = helpers.CountSQL(db, "reportedRequests", "")
-	} else {
-		fmt.Println("This is usersession in dataparse \n", userSession)
-		fmt.Println("This is error", err)
-	}
-
-	usernameId, err := helpers.GetUserID(username)
-	if err != nil {
-		fmt.Println(err)
+	var usernameId int
+	if user := helpers.CurrentUser(r); user != nil {
+		username = user.Username
+		role = user.Role
+		usernameId = user.ID
+		moderationRequests, _ = st.Users.ModeratorApplicants()
+		count, _ = st.Posts.CountFlagged()
 	}
 
-	usernames, err := helpers.GetUsernames(db, usernameId)
+	var usernames []string
+	ownList, err := st.Users.Userlist(usernameId)
 	if err != nil {
 		fmt.Println("This is error:", err)
 	}
+	for _, user := range ownList {
+		usernames = append(usernames, user.Username)
+	}
 	//no posts
 	data = HomePageData{
 		Username:           username,
@@ -881,88 +2255,53 @@ func parsingHomePageData(w http.ResponseWriter, r *http.Request, db *sql.DB) (da
 		ModerationRequests: moderationRequests,
 		ReportedRequests:   count,
 		UsernameId:         usernameId,
-		Userlist:           userlist,
+		Userlist:           ownList,
 	}
 	return
 }
-func homePageHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func homePageHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
 
 	//i had to outcomment it because i am calling this handler from another handler
 
-	posts, _ := getPostsFromDatabase(db, "normal", "")
-	comments, _ := getCommentsFromDatabase(db)
-	posts = addCommentsToPost(posts, comments)
-	likesToPostsAndComments(db, posts)
-	userlist, err := helpers.GetUsernamesIds(db, 1)
+	posts, err := getPostsFromDatabase(st, "normal", "")
 	if err != nil {
-		fmt.Println("[ERROR]", err)
-	}
-
-	userSession, err := helpers.ValidateSessionFromCookie(w, r)
-	var username string
-	var role string
-	var moderationRequests []string
-	var count int
-	if userSession != nil {
-		username = userSession.Username
-		role, err = helpers.SQLGetUserRole(db, userSession.Username)
-		if err != nil {
-			fmt.Println("Failed to get role")
-		}
-		moderationRequests, _ = helpers.SQLSelectModeratorRequest(db, false)
-		count, _ = helpers.CountSQL(db, "reportedRequests", "")
-	} else {
-		fmt.Println("This is usersession in homepagehandler \n", userSession)
-		fmt.Println("This is error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-
-	usernameId, err := helpers.GetUserID(username)
+	comments, err := st.Comments.All()
 	if err != nil {
-		fmt.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-
-	usernames, err := helpers.GetUsernames(db, usernameId)
-	if err != nil {
-		fmt.Println("This is error:", err)
+	posts = addCommentsToPost(posts, comments)
+	if err := likesToPostsAndComments(st, posts); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	// admin pw is Admin123
 
-	data := HomePageData{
-		Username:           username,
-		Usernames:          usernames,
-		Posts:              posts,
-		Role:               role,
-		ModerationRequests: moderationRequests,
-		ReportedRequests:   count,
-		UsernameId:         usernameId,
-		Userlist:           userlist,
-	}
+	// admin pw is Admin123
+	data := parsingHomePageData(r, st)
+	data.Posts = posts
+	writeHomePageData(w, data)
+}
 
+// writeHomePageData sends data as JSON. Guests get it with 401 so that the
+// frontend knows to show the registration page.
+func writeHomePageData(w http.ResponseWriter, data HomePageData) {
+	jsonData, err := json.Marshal(data)
 	if err != nil {
-		// if error exists, mean there is no session and show view page only.
-		var rawMessage = "Usersession is not valid! Proceed to registration page!"
-
-		message, err := json.Marshal(rawMessage)
-		if err != nil {
-			fmt.Printf("Could now marshal data %s\n", err)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(message)
-	} else {
-		//fmt.Println(string(jsonData))
-		jsonData, err := json.Marshal(data)
-		if err != nil {
-			fmt.Printf("Could now marshal data %s\n", err)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(jsonData)
+		log.Printf("Marshal home page data: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
-
+	w.Header().Set("Content-Type", "application/json")
+	if data.Username == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	w.Write(jsonData)
 }
 
-func registerHandler(w http.ResponseWriter, r *http.Request) {
+func registerHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
 	var response struct {
 		Success bool   `json:"success"`
 		Message string `json:"message"`
@@ -1019,20 +2358,22 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 	}
 
-	var apply int
-
-	if appliesForModerator2 != "" {
-		apply = 1
-	} else {
-		apply = 0
-	}
-
 	age, err := strconv.Atoi(ageString)
 	if err != nil {
 		fmt.Println("StrConv failed: ", err)
 	}
-	err = helpers.InitalizeDb(username2, string(cryptedPassword), email2, "user", apply, firstname, lastname, gender, age)
-	
+	_, err = st.Users.Create(store.User{
+		Username:            username2,
+		Password:            string(cryptedPassword),
+		Email:               email2,
+		Role:                "user",
+		AppliesForModerator: appliesForModerator2 != "",
+		FirstName:           firstname,
+		LastName:            lastname,
+		Gender:              gender,
+		Age:                 age,
+	})
+
 	if err != nil {
 
 		errMessage, _ := helpers.ErrorCheck(err)
@@ -1052,7 +2393,7 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func loginHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
 
 	var requestData map[string]string
 	err := json.NewDecoder(r.Body).Decode(&requestData)
@@ -1071,12 +2412,9 @@ func loginHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 
 	usernameOrEmail := requestData["login-username"]
 	password := requestData["login-password"]
-	row := db.QueryRow("SELECT password FROM users WHERE username = ? OR email = ?;", usernameOrEmail, usernameOrEmail)
-
-	var hashedPassword string
-	err = row.Scan(&hashedPassword)
+	user, err := st.Users.ByLogin(usernameOrEmail)
 
-	if err == sql.ErrNoRows {
+	if err == store.ErrNotFound {
 		fmt.Println("Err1")
 		http.Redirect(w, r, "/registration.html?error=Invalid username or password!", http.StatusSeeOther)
 		return
@@ -1086,9 +2424,9 @@ func loginHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	match, _ := helpers.PasswordCheck(password, hashedPassword)
+	match, _ := helpers.PasswordCheck(password, user.Password)
 	if match {
-		helpers.CreateSession(w, r, usernameOrEmail)
+		helpers.CreateSession(w, r, user.Username)
 		http.Redirect(w, r, "/homepage", http.StatusSeeOther) 
 		fmt.Println("Correct password")
 	} else {
@@ -1098,160 +2436,17 @@ func loginHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 }
 
-func getReportedPostsFromDatabase(db *sql.DB) ([]Post, error) {
-	rows, err := db.Query("SELECT posts.id, posts.content, posts.created_at, users.username FROM posts JOIN users ON posts.user_id = users.id WHERE flagged = 1;")
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %v", err)
-	}
-
-	if rows != nil {
-		defer rows.Close()
-
-		var posts []Post
-		for rows.Next() {
-			var post Post
-			if err := rows.Scan(&post.ID, &post.Content, &post.CreatedAt, &post.Username); err != nil {
-				return nil, fmt.Errorf("failed to scan row: %v", err)
-			}
-			posts = append(posts, post)
-		}
-
-		if err := rows.Err(); err != nil {
-			return nil, fmt.Errorf("failed after iterating rows: %v", err)
-		}
-
-		return posts, nil
-	}
-
-	return nil, fmt.Errorf("no rows to return")
-}
-
-func getPostsFromDatabase(db *sql.DB, postsQuery string, username string) ([]Post, error) {
-	var rows *sql.Rows
-	var err error
-
-	if postsQuery == "normal" {
-		rows, err = db.Query("SELECT posts.id, posts.content,  posts.created_at, users.username FROM posts JOIN users ON posts.user_id = users.id;")
-		if err != nil {
-			return nil, fmt.Errorf("failed to execute query: %v", err)
-		}
-	} else if postsQuery == "myposts" {
-		rows, err = db.Query(`SELECT posts.id, posts.content, posts.created_at, users.username 
-		FROM posts 
-		JOIN users ON posts.user_id = users.id 
-		WHERE users.username = ?;`, username)
-		if err != nil {
-			return nil, fmt.Errorf("failed to execute query: %v", err)
-		}
-	} else if postsQuery == "mylikedposts" {
+func getPostsFromDatabase(st *store.Store, postsQuery string, username string) ([]store.Post, error) {
+	switch postsQuery {
+	case "normal":
+		return st.Posts.All()
+	case "myposts":
+		return st.Posts.ByAuthor(username)
+	case "mylikedposts":
 		// Merci
-		rows, err = db.Query(`SELECT 
-		posts.id, 
-		posts.content, 
-		posts.created_at,
-		authors.username 
-		FROM 
-		post_votes 
-		JOIN 
-		users as likers ON post_votes.user_id = likers.id 
-		JOIN 
-		posts ON post_votes.post_id = posts.id 
-		JOIN 
-		users as authors ON posts.user_id = authors.id 
-		WHERE 
-		likers.username = ? 
-		AND post_votes.vote_type = 'like';
-	`, username)
-		if err != nil {
-			return nil, fmt.Errorf("failed to execute query: %v", err)
-		}
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %v", err)
-	}
-
-	if rows != nil {
-		defer rows.Close()
-
-		var posts []Post
-		for rows.Next() {
-			var post Post
-			if err := rows.Scan(&post.ID, &post.Content, &post.CreatedAt, &post.Username); err != nil {
-				return nil, fmt.Errorf("failed to scan row: %v", err)
-			}
-			posts = append(posts, post)
-		}
-
-		if err := rows.Err(); err != nil {
-			return nil, fmt.Errorf("failed after iterating rows: %v", err)
-		}
-
-		return posts, nil
+		return st.Posts.LikedBy(username)
 	}
-
-	return nil, fmt.Errorf("fo rows to return")
-}
-
-func getCommentsFromDatabase(db *sql.DB) ([]Comment, error) {
-	rows, err := db.Query("SELECT comments.id, comments.content, comments.created_at, posts.id AS post_id, users.username FROM comments JOIN posts ON comments.post_id = posts.id JOIN users ON comments.user_id = users.id;")
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %v", err)
-	}
-
-	defer rows.Close()
-	var comments []Comment
-	for rows.Next() {
-		var comment Comment
-		if err := rows.Scan(&comment.ID, &comment.Content, &comment.CreatedAt, &comment.PostID, &comment.Username); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %v", err)
-		}
-		comments = append(comments, comment)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed after iterating rows: %v", err)
-	}
-	return comments, nil
-}
-
-func filterPosts(db *sql.DB, categories []int) (posts []Post, err error) {
-	postMap := make(map[int]Post)
-	for _, category := range categories {
-		if category != 0 {
-			rows, err := db.Query(`SELECT posts.id, posts.content, posts.created_at, users.username 
-			FROM posts 
-			JOIN post_categories 
-			ON posts.id = post_categories.post_id 
-			JOIN users ON posts.user_id = users.id
-			WHERE post_categories.category_id = ?;`, category)
-			if err != nil {
-				return nil, fmt.Errorf("failed to execute query: %v", err)
-			}
-			defer rows.Close()
-
-			for rows.Next() {
-				var post Post
-				if err := rows.Scan(&post.ID, &post.Content, &post.CreatedAt, &post.Username); err != nil {
-					return nil, fmt.Errorf("failed to scan row: %v", err)
-				}
-				// If the post is not in the map, add it.
-				if _, exists := postMap[post.ID]; !exists {
-					postMap[post.ID] = post
-				}
-			}
-
-			if err := rows.Err(); err != nil {
-				return nil, fmt.Errorf("failed after iterating rows: %v", err)
-			}
-		}
-	}
-
-	for _, post := range postMap {
-		posts = append(posts, post)
-	}
-
-	return posts, nil
+	return nil, fmt.Errorf("unknown posts query %q", postsQuery)
 }
 
 func formValue(w http.ResponseWriter, r *http.Request) ([]int, error) {
@@ -1293,7 +2488,7 @@ func formValue(w http.ResponseWriter, r *http.Request) ([]int, error) {
 	return categories, nil
 }
 
-func showMyPostsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func showMyPostsHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
 
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -1315,15 +2510,29 @@ func showMyPostsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	fmt.Println("PostData", postData)
 	fmt.Println("content:", postData.Categories)
 
-	userSession, _ := helpers.ValidateSessionFromCookie(w, r)
-	posts, _ := getPostsFromDatabase(db, postData.Categories, userSession.Username)
-	comments, _ := getCommentsFromDatabase(db)
+	var username string
+	if user := helpers.CurrentUser(r); user != nil {
+		username = user.Username
+	}
+	posts, err := getPostsFromDatabase(st, postData.Categories, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	comments, err := st.Comments.All()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	posts = addCommentsToPost(posts, comments)
 
-	likesToPostsAndComments(db, posts)
+	if err := likesToPostsAndComments(st, posts); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	rawData := parsingHomePageData(w, r, db)
+	rawData := parsingHomePageData(r, st)
 	data := HomePageData{
 		Username:   rawData.Username,
 		Usernames:  rawData.Usernames,
@@ -1331,31 +2540,10 @@ func showMyPostsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		UsernameId: rawData.UsernameId,
 		Userlist:   rawData.Userlist,
 	}
-
-	if err != nil {
-		// if error exists, mean there is no session and show view page only.
-		var rawMessage = "Usersession is not valid! Proceed to registration page!"
-
-		message, err := json.Marshal(rawMessage)
-		if err != nil {
-			fmt.Printf("Could now marshal data %s\n", err)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(message)
-	} else {
-		jsonData, err := json.Marshal(data)
-		if err != nil {
-			fmt.Printf("Could now marshal data %s\n", err)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(jsonData)
-	}
-
+	writeHomePageData(w, data)
 }
 
-func deletePostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func deletePostHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
 	err := r.ParseForm()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -1380,7 +2568,7 @@ func deletePostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	err = helpers.SQLDeletePost(db, postID, status)
+	err = setPostStatus(st, postID, status)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -1393,42 +2581,33 @@ func deletePostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	http.Redirect(w, r, redirectLocation, http.StatusSeeOther)
 }
 
-func reportPostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	userSession, err := helpers.ValidateSessionFromCookie(w, r)
-	if err != nil {
-		http.Error(w, "Failed to validate session from cookie: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	var role string
-	if userSession != nil {
-		role, err = helpers.SQLGetUserRole(db, userSession.Username)
-		if err != nil {
-			http.Error(w, "Failed to get user role: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	if role != "moderator" {
-		helpers.DeleteCookie(w, r)
-		http.Redirect(w, r, "/", http.StatusForbidden)
-		return
+// setPostStatus flags the post when status is "report" and removes it when
+// status is "delete".
+func setPostStatus(st *store.Store, postID int, status string) error {
+	switch status {
+	case "report":
+		return st.Posts.Flag(postID)
+	case "delete":
+		return st.Posts.Delete(postID)
 	}
+	return fmt.Errorf("unknown post status %q", status)
+}
 
-	posts, err := getReportedPostsFromDatabase(db)
+func reportPostHandler(w http.ResponseWriter, r *http.Request, st *store.Store) {
+	posts, err := st.Posts.Flagged()
 	if err != nil {
 		http.Error(w, "Failed to get reported posts from database: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	comments, err := getCommentsFromDatabase(db)
+	comments, err := st.Comments.All()
 	if err != nil {
 		http.Error(w, "Failed to get comments from database: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	posts = addCommentsToPost(posts, comments)
-	err = likesToPostsAndComments(db, posts)
+	err = likesToPostsAndComments(st, posts)
 	if err != nil {
 		http.Error(w, "Failed to add likes to posts and comments: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -1449,7 +2628,7 @@ func reportPostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 			http.Error(w, "Failed to convert report to integer: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = helpers.SQLDeletePost(db, reportInt, "report")
+		err = st.Posts.Flag(reportInt)
 		if err != nil {
 			http.Error(w, "Failed to report post: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -1460,7 +2639,7 @@ func reportPostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 			http.Error(w, "Failed to convert delete to integer: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = helpers.SQLDeletePost(db, deleteInt, "delete")
+		err = st.Posts.Delete(deleteInt)
 		if err != nil {
 			http.Error(w, "Failed to delete post: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -1469,7 +2648,7 @@ func reportPostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 
 	data := HomePageData{
 		Posts: posts,
-		Role:  role,
+		Role:  helpers.CurrentUser(r).Role,
 	}
 
 	t, err := template.ParseFiles("templates/reportedposts.html")
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"forum/attachments"
+	"forum/chat"
+	"forum/helpers"
+	"forum/store"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testForum serves handlers backed by a memory store behind the same session
+// and role checks as the routes of main.
+type testForum struct {
+	t  *testing.T
+	st *store.Store
+}
+
+func newTestForum(t *testing.T) *testForum {
+	st := store.NewMemory()
+	helpers.ConfigureSessions(st.Sessions, helpers.DefaultSessionConfig)
+	return &testForum{t: t, st: st}
+}
+
+// member creates a user with a session and returns the user's ID and
+// session cookie.
+func (f *testForum) member(username string) (int, *http.Cookie) {
+	id, err := f.st.Users.Create(store.User{Username: username, Password: "hash", Email: username + "@example.com", Role: "user"})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	token := "token-" + username
+	if err := f.st.Sessions.Create(store.Session{Token: token, Username: username, Expiry: time.Now().Add(time.Hour)}); err != nil {
+		f.t.Fatal(err)
+	}
+	return id, &http.Cookie{Name: "session_token", Value: token}
+}
+
+// serve sends a request with body to handler as the user of cookie, or as a
+// guest when cookie is nil.
+func (f *testForum) serve(handler func(http.ResponseWriter, *http.Request, *store.Store), cookie *http.Cookie, method string, body string) *httptest.ResponseRecorder {
+	return f.do(handler, cookie, httptest.NewRequest(method, "/", strings.NewReader(body)))
+}
+
+// do is serve for any request.
+func (f *testForum) do(handler func(http.ResponseWriter, *http.Request, *store.Store), cookie *http.Cookie, r *http.Request) *httptest.ResponseRecorder {
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handler(w, r, f.st) })
+	helpers.Authenticate(f.st.Users, helpers.Require(helpers.Member, f.st.Roles, next)).ServeHTTP(w, r)
+	return w
+}
+
+func TestCreatePost(t *testing.T) {
+	tests := []struct {
+		name   string
+		guest  bool
+		method string
+		body   string
+		status int
+	}{
+		{"post", false, http.MethodPost, `{"title":"  Hello  ","content":"Some *text*","categories":["1","2"]}`, http.StatusOK},
+		{"guest", true, http.MethodPost, `{"title":"Hello","content":"text","categories":["1"]}`, http.StatusUnauthorized},
+		{"GET", false, http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"bad JSON", false, http.MethodPost, `{"title":`, http.StatusBadRequest},
+		{"no content", false, http.MethodPost, `{"title":"Hello","content":"","categories":["1"]}`, http.StatusBadRequest},
+		{"no title", false, http.MethodPost, `{"title":"   ","content":"text","categories":["1"]}`, http.StatusBadRequest},
+		{"long title", false, http.MethodPost, `{"title":"` + strings.Repeat("é", maxTitleLength+1) + `","content":"text"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestForum(t)
+			_, cookie := f.member("alice")
+			if tt.guest {
+				cookie = nil
+			}
+			w := f.serve(createPost, cookie, tt.method, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			posts, _ := f.st.Posts.All()
+			if tt.status != http.StatusOK {
+				if len(posts) != 0 {
+					t.Errorf("post stored anyway: %+v", posts)
+				}
+				return
+			}
+			if len(posts) != 1 || posts[0].Title != "Hello" || posts[0].Username != "alice" || posts[0].ContentHTML != "<p>Some <em>text</em></p>\n" {
+				t.Fatalf("stored %+v", posts)
+			}
+			if inCategory, _ := f.st.Posts.ByCategories([]int{2}); len(inCategory) != 1 {
+				t.Errorf("post is not in category 2")
+			}
+		})
+	}
+}
+
+func TestHandleVote(t *testing.T) {
+	f := newTestForum(t)
+	aliceID, alice := f.member("alice")
+	_, bob := f.member("bob")
+	postID, _ := f.st.Posts.Create(aliceID, "Post", "Post", nil)
+	commentID, _ := f.st.Comments.Create(postID, 0, aliceID, "Comment")
+
+	votes := []struct {
+		name     string
+		handler  func(http.ResponseWriter, *http.Request, *store.Store)
+		cookie   *http.Cookie
+		target   int
+		likes    int
+		dislikes int
+	}{
+		{"alice likes the post", likeHandler, alice, postID, 1, 0},
+		{"bob likes the post", likeHandler, bob, postID, 2, 0},
+		{"alice likes the post again", likeHandler, alice, postID, 2, 0},
+		{"bob switches to dislike", dislikeHandler, bob, postID, 1, 1},
+		{"comment votes are apart", commentDislikeHandler, alice, commentID, 0, 1},
+		{"bob likes the comment", commentLikeHandler, bob, commentID, 1, 1},
+	}
+	for _, vote := range votes {
+		w := f.serve(vote.handler, vote.cookie, http.MethodPost, fmt.Sprintf(`{"postID":%d}`, vote.target))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", vote.name, w.Code, w.Body)
+		}
+		var counts map[string]int
+		if err := json.NewDecoder(w.Body).Decode(&counts); err != nil {
+			t.Fatalf("%s: %v", vote.name, err)
+		}
+		if counts["likesCount"] != vote.likes || counts["dislikesCount"] != vote.dislikes {
+			t.Errorf("%s: got %v, want %d likes and %d dislikes", vote.name, counts, vote.likes, vote.dislikes)
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		cookie *http.Cookie
+		method string
+		body   string
+		status int
+	}{
+		{"guest", nil, http.MethodPost, fmt.Sprintf(`{"postID":%d}`, postID), http.StatusUnauthorized},
+		{"GET", alice, http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"bad JSON", alice, http.MethodPost, `{"postID":"one"}`, http.StatusBadRequest},
+	} {
+		if w := f.serve(likeHandler, tt.cookie, tt.method, tt.body); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+	if likes, _ := f.st.Votes.Count(postID, "like", false); likes != 1 {
+		t.Errorf("rejected votes were counted: %d likes", likes)
+	}
+}
+
+func TestEditMessage(t *testing.T) {
+	f := newTestForum(t)
+	aliceID, alice := f.member("alice")
+	bobID, bob := f.member("bob")
+	hub := chat.NewHub(nil)
+	go hub.Run()
+	msg, _ := f.st.Messages.Create(aliceID, bobID, "hi", 0)
+	group, _ := f.st.Conversations.CreateGroup("group", aliceID, []int{bobID})
+	posted, _ := f.st.Conversations.Post(group, aliceID, "to the group", 0)
+
+	edit := func(window time.Duration, deleting bool) func(http.ResponseWriter, *http.Request, *store.Store) {
+		return func(w http.ResponseWriter, r *http.Request, st *store.Store) {
+			editMessageHandler(w, r, st, hub, window, deleting)
+		}
+	}
+	tests := []struct {
+		name     string
+		cookie   *http.Cookie
+		window   time.Duration
+		deleting bool
+		body     string
+		status   int
+	}{
+		{"someone else's", bob, time.Minute, false, fmt.Sprintf(`{"id":%d,"message":"mine"}`, msg.ID), http.StatusNotFound},
+		{"empty", alice, time.Minute, false, fmt.Sprintf(`{"id":%d,"message":" "}`, msg.ID), http.StatusBadRequest},
+		{"after the window", alice, 0, false, fmt.Sprintf(`{"id":%d,"message":"late"}`, msg.ID), http.StatusForbidden},
+		{"group message", alice, time.Minute, false, fmt.Sprintf(`{"id":%d,"conversation":%d,"message":"edited"}`, posted.ID, group), http.StatusBadRequest},
+		{"group message deleted", alice, time.Minute, true, fmt.Sprintf(`{"id":%d,"conversation":%d}`, posted.ID, group), http.StatusBadRequest},
+		{"edit", alice, time.Minute, false, fmt.Sprintf(`{"id":%d,"message":"edited"}`, msg.ID), http.StatusOK},
+		{"delete", alice, time.Minute, true, fmt.Sprintf(`{"id":%d}`, msg.ID), http.StatusOK},
+		{"deleted", alice, time.Minute, false, fmt.Sprintf(`{"id":%d,"message":"again"}`, msg.ID), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := f.serve(edit(tt.window, tt.deleting), tt.cookie, http.MethodPost, tt.body); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+		}
+	}
+
+	if revisions, _ := f.st.Messages.Revisions(msg.ID); len(revisions) != 1 || revisions[0].Content != "hi" {
+		t.Errorf("revisions = %+v", revisions)
+	}
+	if kept, _ := f.st.Messages.ByID(msg.ID); kept.Content != "edited" || kept.DeletedAt == "" {
+		t.Errorf("deleted message = %+v", kept)
+	}
+	page, _ := f.st.Conversations.Messages(group, store.MessageQuery{Limit: 10})
+	if len(page.Messages) != 1 || page.Messages[0].Content != "to the group" {
+		t.Errorf("group message changed: %+v", page.Messages)
+	}
+}
+
+func TestCanMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(st *store.Store, alice int, bob int)
+		aliceTo error
+		bobTo   error
+	}{
+		{"strangers", func(st *store.Store, alice int, bob int) {}, nil, nil},
+		{"bob blocked alice", func(st *store.Store, alice int, bob int) { st.Privacy.Block(bob, alice) }, errBlocked, errBlocking},
+		{"both blocked", func(st *store.Store, alice int, bob int) {
+			st.Privacy.Block(bob, alice)
+			st.Privacy.Block(alice, bob)
+		}, errBlocked, errBlocked},
+		{"bob takes no messages", func(st *store.Store, alice int, bob int) { st.Privacy.SetSetting(bob, store.PrivacyNobody) }, errNoMessages, nil},
+		{"bob takes messages of contacts", func(st *store.Store, alice int, bob int) { st.Privacy.SetSetting(bob, store.PrivacyContacts) }, errNotContacted, nil},
+		{"bob chatted with alice", func(st *store.Store, alice int, bob int) {
+			st.Privacy.SetSetting(bob, store.PrivacyContacts)
+			st.Messages.Create(bob, alice, "hi", 0)
+		}, nil, nil},
+		{"blocks beat contacts", func(st *store.Store, alice int, bob int) {
+			st.Messages.Create(bob, alice, "hi", 0)
+			st.Privacy.Block(alice, bob)
+		}, errBlocking, errBlocked},
+	}
+	for _, tt := range tests {
+		f := newTestForum(t)
+		alice, _ := f.member("alice")
+		bob, _ := f.member("bob")
+		tt.setup(f.st, alice, bob)
+		if err := canMessage(f.st, alice, bob); err != tt.aliceTo {
+			t.Errorf("%s: alice to bob: %v, want %v", tt.name, err, tt.aliceTo)
+		}
+		if err := canMessage(f.st, bob, alice); err != tt.bobTo {
+			t.Errorf("%s: bob to alice: %v, want %v", tt.name, err, tt.bobTo)
+		}
+	}
+}
+
+// TestGroupDelivery checks that group messages are pushed to the members
+// who neither blocked the sender nor were blocked by them.
+func TestGroupDelivery(t *testing.T) {
+	f := newTestForum(t)
+	alice, _ := f.member("alice")
+	bob, _ := f.member("bob")
+	carol, _ := f.member("carol")
+	dave, _ := f.member("dave")
+	f.member("erin")
+	group, _ := f.st.Conversations.CreateGroup("group", alice, []int{bob, carol, dave})
+	f.st.Privacy.Block(bob, alice)
+	f.st.Privacy.Block(alice, carol)
+
+	reached, err := unblockedMembers(f.st, alice, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(reached)
+	if fmt.Sprint(reached) != "[alice dave]" {
+		t.Errorf("messages of alice reach %v", reached)
+	}
+	reached, _ = unblockedMembers(f.st, dave, group)
+	sort.Strings(reached)
+	if fmt.Sprint(reached) != "[alice bob carol dave]" {
+		t.Errorf("messages of dave reach %v", reached)
+	}
+}
+
+func TestAttachmentHandler(t *testing.T) {
+	f := newTestForum(t)
+	aliceID, alice := f.member("alice")
+	bobID, bob := f.member("bob")
+	carolID, carol := f.member("carol")
+	_, dave := f.member("dave")
+
+	disk, err := attachments.NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := func(name string, content string) int {
+		saved, err := attachments.Save(disk, strings.NewReader(content), 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := f.st.Attachments.Create(store.Attachment{UploaderID: aliceID, Name: name, ContentType: saved.ContentType, Size: saved.Size, StorageKey: saved.Key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	private := upload("private.txt", "for bob")
+	f.st.Messages.Create(aliceID, bobID, "", private)
+	grouped := upload("group.txt", "for the group")
+	group, _ := f.st.Conversations.CreateGroup("group", aliceID, []int{carolID})
+	f.st.Conversations.Post(group, aliceID, "", grouped)
+
+	get := func(w http.ResponseWriter, r *http.Request, st *store.Store) {
+		attachmentHandler(w, r, st, disk)
+	}
+	tests := []struct {
+		name       string
+		cookie     *http.Cookie
+		attachment int
+		status     int
+		body       string
+	}{
+		{"uploader", alice, private, http.StatusOK, "for bob"},
+		{"receiver", bob, private, http.StatusOK, "for bob"},
+		{"stranger to a private message", carol, private, http.StatusNotFound, ""},
+		{"group member", carol, grouped, http.StatusOK, "for the group"},
+		{"stranger to a group", dave, grouped, http.StatusNotFound, ""},
+		{"guest", nil, private, http.StatusUnauthorized, ""},
+		{"missing attachment", alice, 12345, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := f.do(get, tt.cookie, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/attachment?id=%d", tt.attachment), nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: body %q", tt.name, w.Body)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" || !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment;") {
+			t.Errorf("%s: headers %v", tt.name, w.Header())
+		}
+	}
+}
+
+// TestReplayPending checks that messages sent while their receiver was
+// offline are replayed a batch at a time, the next once the last message of
+// the batch before was delivered.
+func TestReplayPending(t *testing.T) {
+	f := newTestForum(t)
+	aliceID, _ := f.member("alice")
+	bobID, bob := f.member("bob")
+	const total = 2*replayBatch + 3
+	for i := 0; i < total; i++ {
+		if _, err := f.st.Messages.Create(aliceID, bobID, fmt.Sprint(i), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hub := chat.NewHub(nil)
+	go hub.Run()
+	ws := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handleWebSocket(w, r, f.st, hub, time.Minute) })
+	server := httptest.NewServer(helpers.Authenticate(f.st.Users, helpers.Require(helpers.Member, f.st.Roles, ws)))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), http.Header{"Cookie": {bob.String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	messages := make(chan chat.MessagePayload, total)
+	go func() {
+		for {
+			var env chat.Envelope
+			if err := conn.ReadJSON(&env); err != nil {
+				return
+			}
+			var payload chat.MessagePayload
+			if env.Type == chat.TypeMessage && json.Unmarshal(env.Payload, &payload) == nil {
+				messages <- payload
+			}
+		}
+	}()
+	// next returns the next replayed message, or false when none comes in
+	// time.
+	next := func(wait time.Duration) (chat.MessagePayload, bool) {
+		select {
+		case payload := <-messages:
+			return payload, true
+		case <-time.After(wait):
+			return chat.MessagePayload{}, false
+		}
+	}
+
+	var contents []string
+	for batch := 0; len(contents) < total; batch++ {
+		if batch > total {
+			t.Fatal("replay does not end")
+		}
+		var ids []int
+		for len(ids) < replayBatch && len(contents) < total {
+			payload, ok := next(2 * time.Second)
+			if !ok {
+				t.Fatalf("batch %d ended after %d messages", batch, len(ids))
+			}
+			if !payload.Replayed || payload.From != "alice" {
+				t.Fatalf("replayed %+v", payload)
+			}
+			contents = append(contents, payload.Message.Content)
+			ids = append(ids, payload.Message.ID)
+		}
+		if payload, ok := next(100 * time.Millisecond); ok {
+			t.Fatalf("batch %d goes on with %+v before it was delivered", batch, payload.Message)
+		}
+		for _, id := range ids {
+			frame, _ := chat.Frame(chat.TypeDelivered, "", chat.DeliveredPayload{MessageID: id})
+			if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i, content := range contents {
+		if content != fmt.Sprint(i) {
+			t.Fatalf("replayed %v, want oldest first", contents)
+		}
+	}
+}
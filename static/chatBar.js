@@ -10,7 +10,7 @@
     chatboxToggle.forEach(element => {
       element.addEventListener('click', () => {
           chatbox.classList.toggle('expanded');
-          const username = element.textContent; // This gets the username from the clicked element
+          const username = element.dataset.username || element.textContent; // This gets the username from the clicked element
           openChat(username);
  
         
@@ -1,3 +1,5 @@
+import { csrfHeaders } from "/static/csrf.js";
+
 export function commentsScript() {
         var posts = document.querySelectorAll('.comment');
         posts.forEach(function(post) {
@@ -10,10 +12,10 @@ export function commentsScript() {
                 fetch('/commentlike', {
 
                     method: 'POST',
-                    headers: {
+                    headers: csrfHeaders({
                         'Content-Type': 'application/json',
 
-                    },
+                    }),
 
                     body: JSON.stringify({
                         postID: postID,
@@ -29,9 +31,9 @@ export function commentsScript() {
             commentdislikeButton.addEventListener('click', function() {
                 fetch('/commentdislike', {
                     method: 'POST',
-                    headers: {
+                    headers: csrfHeaders({
                         'Content-Type': 'application/json',
-                    },
+                    }),
                     body: JSON.stringify({
                         postID: postID,
                         username: userID,
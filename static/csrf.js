@@ -0,0 +1,11 @@
+// The server hands out a csrf_token cookie and rejects POST requests that do
+// not send the same value back in the X-CSRF-Token header.
+export function csrfToken() {
+  const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+  return match ? decodeURIComponent(match[1]) : "";
+}
+
+// Adds the CSRF header to a fetch headers object.
+export function csrfHeaders(headers = {}) {
+  return { ...headers, "X-CSRF-Token": csrfToken() };
+}
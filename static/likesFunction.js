@@ -1,3 +1,5 @@
+import { csrfHeaders } from "/static/csrf.js";
+
 export function likesFunction() {
     const IS_VIEW_ONLY = document.body.dataset.viewOnly === 'true';
     if (!IS_VIEW_ONLY) {
@@ -11,9 +13,9 @@ export function likesFunction() {
                 likeButton.addEventListener('click', function() {
                     fetch('/like', {
                         method: 'POST',
-                        headers: {
+                        headers: csrfHeaders({
                             'Content-Type': 'application/json',
-                        },
+                        }),
                         body: JSON.stringify({
                             postID: postID,
                             username: userID,
@@ -27,9 +29,9 @@ export function likesFunction() {
                 dislikeButton.addEventListener('click', function() {
                     fetch('/dislike', {
                         method: 'POST',
-                        headers: {
+                        headers: csrfHeaders({
                             'Content-Type': 'application/json',
-                        },
+                        }),
                         body: JSON.stringify({
                             postID: postID,
                             username: userID,
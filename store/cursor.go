@@ -0,0 +1,112 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBadCursor = errors.New("invalid cursor")
+
+// Cursor is a position in a conversation: the creation time and ID of a
+// message. Messages are ordered by time and, within the same time, by ID,
+// so new messages never shift a cursor.
+type Cursor struct {
+	Time time.Time
+	ID   int
+}
+
+func (c Cursor) IsZero() bool {
+	return c.ID == 0
+}
+
+func (c Cursor) before(other Cursor) bool {
+	if !c.Time.Equal(other.Time) {
+		return c.Time.Before(other.Time)
+	}
+	return c.ID < other.ID
+}
+
+// String encodes c for clients, who should treat it as opaque.
+func (c Cursor) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(c.Time.UnixNano(), 10) + "_" + strconv.Itoa(c.ID)
+}
+
+// ParseCursor decodes a cursor made by String. The empty string is the zero
+// cursor.
+func ParseCursor(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+	nanos, id, ok := strings.Cut(s, "_")
+	if !ok {
+		return Cursor{}, ErrBadCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrBadCursor
+	}
+	i, err := strconv.Atoi(id)
+	if err != nil || i <= 0 {
+		return Cursor{}, ErrBadCursor
+	}
+	return Cursor{Time: time.Unix(0, n).UTC(), ID: i}, nil
+}
+
+// MessageQuery selects messages of a conversation. Only messages between
+// the cursors that are set are returned; without After the newest of them
+// come first, with After the oldest.
+type MessageQuery struct {
+	Before Cursor
+	After  Cursor
+	Limit  int
+}
+
+// MessagePage is part of a conversation, newest message first. Before and
+// After are set when there are older or newer messages to load.
+type MessagePage struct {
+	Messages []PrivateMessage `json:"messages"`
+	Before   string           `json:"before,omitempty"`
+	After    string           `json:"after,omitempty"`
+}
+
+// newMessagePage builds the page for q from messages, which are in the order
+// the query asked for and may hold one message more than q.Limit to tell
+// whether there are more.
+func newMessagePage(q MessageQuery, messages []PrivateMessage) MessagePage {
+	more := len(messages) > q.Limit
+	if more {
+		messages = messages[:q.Limit]
+	}
+	page := MessagePage{Messages: messages}
+	if len(messages) == 0 {
+		page.Messages = []PrivateMessage{}
+		return page
+	}
+
+	first, last := messages[0].Cursor, messages[len(messages)-1].Cursor
+	if q.After.IsZero() {
+		// Newest first: first is the newest message on the page.
+		if more {
+			page.Before = last
+		}
+		if !q.Before.IsZero() {
+			page.After = first
+		}
+		return page
+	}
+
+	// Oldest first: first is the oldest message on the page.
+	page.Before = first
+	if more {
+		page.After = last
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return page
+}
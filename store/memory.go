@@ -0,0 +1,159 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"forum/markdown"
+)
+
+// memory holds every table of the in-memory store. The individual stores
+// share it so that joins, like post authors, work the same as in SQLite.
+type memory struct {
+	mu           sync.RWMutex
+	lastID       map[string]int
+	users        []User
+	posts        []memoryPost
+	comments     []memoryComment
+	postVotes    map[[2]int]string
+	commentVotes map[[2]int]string
+	messages     []memoryMessage
+	identities   []Identity
+	lastSeen     map[int]time.Time
+	// blocks holds blocker and blocked user IDs; privacy the settings that
+	// are not the default.
+	blocks  map[[2]int]bool
+	privacy map[int]string
+	// Conversations, their members and their messages
+	conversations        []Conversation
+	members              []memoryMember
+	conversationMessages []memoryMessage
+	attachments          []Attachment
+}
+
+type memoryPost struct {
+	Post
+	userID     int
+	categories []int
+	flagged    bool
+	// revisions holds the versions edits replaced, oldest first.
+	revisions []Revision
+}
+
+type memoryComment struct {
+	Comment
+	userID    int
+	revisions []Revision
+}
+
+type memoryMessage struct {
+	id          int
+	senderID    int
+	receiverID  int
+	content     string
+	contentHTML string
+	createdAt   time.Time
+	deliveredAt time.Time
+	readAt      time.Time
+	editedAt    time.Time
+	deletedAt   time.Time
+	// revisions holds the contents edits replaced, oldest first.
+	revisions []MessageRevision
+	// conversationID is set instead of receiverID for messages of groups and
+	// channels.
+	conversationID int
+	attachment     *Attachment
+}
+
+type memoryMember struct {
+	conversationID int
+	userID         int
+	role           string
+	lastReadID     int
+}
+
+// NewMemory returns an empty Store that keeps everything in memory.
+func NewMemory() *Store {
+	m := &memory{
+		lastID:       make(map[string]int),
+		postVotes:    make(map[[2]int]string),
+		commentVotes: make(map[[2]int]string),
+		lastSeen:     make(map[int]time.Time),
+		blocks:       make(map[[2]int]bool),
+		privacy:      make(map[int]string),
+	}
+	// The channels of the categories the forum starts with, as in SQLite.
+	for id, name := range []string{"league", "runescape", "counter-strike"} {
+		m.conversations = append(m.conversations, Conversation{
+			ID:         m.nextID("conversations"),
+			Kind:       ConversationChannel,
+			Name:       name,
+			CategoryID: id + 1,
+		})
+	}
+	return &Store{
+		Users:         &memoryUsers{m},
+		Posts:         &memoryPosts{m},
+		Comments:      &memoryComments{m},
+		Votes:         &memoryVotes{m},
+		Messages:      &memoryMessages{m},
+		Sessions:      NewMemorySessions(),
+		Roles:         memoryRoles{"guest": 0, "user": 1, "moderator": 2, "admin": 3},
+		Identities:    &memoryIdentities{m},
+		Conversations: &memoryConversations{m},
+		Privacy:       &memoryPrivacy{m},
+		Attachments:   &memoryAttachments{m},
+		Search:        &memorySearch{m},
+	}
+}
+
+// nextID works like AUTOINCREMENT; callers must hold the write lock.
+func (m *memory) nextID(table string) int {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+func (m *memory) userByID(id int) (User, bool) {
+	for _, user := range m.users {
+		if user.ID == id {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+func (m *memory) userByUsername(username string) (User, bool) {
+	for _, user := range m.users {
+		if user.Username == username {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// render renders Markdown content with mentions of the users of m.
+func (m *memory) render(content string) string {
+	return markdown.Render(content, func(username string) bool {
+		_, ok := m.userByUsername(username)
+		return ok
+	})
+}
+
+// post returns the post with the ID, or nil.
+func (m *memory) post(id int) *memoryPost {
+	for i := range m.posts {
+		if m.posts[i].ID == id {
+			return &m.posts[i]
+		}
+	}
+	return nil
+}
+
+func (m *memory) postExists(postID int) bool {
+	for _, post := range m.posts {
+		if post.ID == postID {
+			return true
+		}
+	}
+	return false
+}
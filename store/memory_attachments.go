@@ -0,0 +1,70 @@
+package store
+
+type memoryAttachments struct {
+	m *memory
+}
+
+func (s *memoryAttachments) Create(attachment Attachment) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, a := range s.m.attachments {
+		if a.StorageKey == attachment.StorageKey {
+			return 0, ErrConflict
+		}
+	}
+	attachment.ID = s.m.nextID("attachments")
+	attachment.Thumbnail = attachment.ThumbnailKey != ""
+	s.m.attachments = append(s.m.attachments, attachment)
+	return attachment.ID, nil
+}
+
+func (s *memoryAttachments) ByID(id int) (Attachment, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	if a := s.m.attachment(id); a != nil {
+		return *a, nil
+	}
+	return Attachment{}, ErrNotFound
+}
+
+// attachment returns the attachment id; callers must hold the lock.
+func (m *memory) attachment(id int) *Attachment {
+	for i := range m.attachments {
+		if m.attachments[i].ID == id {
+			return &m.attachments[i]
+		}
+	}
+	return nil
+}
+
+func (s *memoryAttachments) CanAccess(id int, userID int) (bool, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	a := s.m.attachment(id)
+	if a == nil {
+		return false, nil
+	}
+	if a.UploaderID == userID {
+		return true, nil
+	}
+	for _, msg := range s.m.messages {
+		if msg.attachment != nil && msg.attachment.ID == id && msg.deletedAt.IsZero() &&
+			(msg.senderID == userID || msg.receiverID == userID) {
+			return true, nil
+		}
+	}
+	for _, msg := range s.m.conversationMessages {
+		if msg.attachment == nil || msg.attachment.ID != id {
+			continue
+		}
+		for _, c := range s.m.conversations {
+			if c.ID == msg.conversationID && (c.Kind == ConversationChannel || s.m.member(c.ID, userID) != nil) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
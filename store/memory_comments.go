@@ -0,0 +1,150 @@
+package store
+
+import "time"
+
+type memoryComments struct {
+	m *memory
+}
+
+func (s *memoryComments) Create(postID int, parentID int, userID int, content string) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	user, _ := s.m.userByID(userID)
+	comment := memoryComment{
+		Comment: Comment{
+			ID:          s.m.nextID("comments"),
+			PostID:      postID,
+			ParentID:    parentID,
+			Username:    user.Username,
+			Content:     content,
+			ContentHTML: s.m.render(content),
+			CreatedAt:   time.Now().UTC(),
+		},
+		userID: userID,
+	}
+	s.m.comments = append(s.m.comments, comment)
+	return comment.ID, nil
+}
+
+func (s *memoryComments) ByID(id int) (Comment, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	if i := s.m.commentIndex(id); i >= 0 {
+		return s.m.comments[i].Comment, nil
+	}
+	return Comment{}, ErrNotFound
+}
+
+func (s *memoryComments) All() ([]Comment, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	var comments []Comment
+	for _, comment := range s.m.comments {
+		if s.m.postExists(comment.PostID) {
+			comments = append(comments, comment.Comment)
+		}
+	}
+	return comments, nil
+}
+
+func (s *memoryComments) CountForPost(postID int) (int, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	count := 0
+	for _, comment := range s.m.comments {
+		if comment.PostID == postID && !comment.Deleted {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryComments) Delete(id int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	i := s.m.commentIndex(id)
+	if i < 0 || s.m.comments[i].Deleted {
+		return ErrNotFound
+	}
+	// Walk up from the comment while placeholders lose their last reply.
+	for i >= 0 {
+		comment := &s.m.comments[i]
+		if s.m.hasReplies(comment.ID) {
+			comment.Deleted = true
+			comment.Content = DeletedComment
+			comment.ContentHTML = ""
+			comment.Username = ""
+			return nil
+		}
+		parentID := comment.ParentID
+		s.m.comments = append(s.m.comments[:i], s.m.comments[i+1:]...)
+		for key := range s.m.commentVotes {
+			if key[0] == id {
+				delete(s.m.commentVotes, key)
+			}
+		}
+
+		id, i = parentID, s.m.commentIndex(parentID)
+		if i >= 0 && (!s.m.comments[i].Deleted || s.m.hasReplies(id)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *memoryComments) Edit(id int, editorID int, content string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	i := s.m.commentIndex(id)
+	if i < 0 || s.m.comments[i].Deleted {
+		return ErrNotFound
+	}
+	comment := &s.m.comments[i]
+	editor, _ := s.m.userByID(editorID)
+	now := time.Now().UTC()
+	comment.revisions = append(comment.revisions, Revision{
+		Content:    comment.Content,
+		Editor:     editor.Username,
+		ReplacedAt: now.Format(time.RFC3339),
+	})
+	comment.Content = content
+	comment.ContentHTML = s.m.render(content)
+	comment.EditedAt = &now
+	return nil
+}
+
+func (s *memoryComments) Revisions(id int) ([]Revision, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	revisions := []Revision{}
+	if i := s.m.commentIndex(id); i >= 0 {
+		revisions = append(revisions, s.m.comments[i].revisions...)
+	}
+	return revisions, nil
+}
+
+// commentIndex returns the index of a comment in m.comments, or -1.
+func (m *memory) commentIndex(id int) int {
+	for i, comment := range m.comments {
+		if comment.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *memory) hasReplies(id int) bool {
+	for _, comment := range m.comments {
+		if comment.ParentID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+type memoryConversations struct {
+	m *memory
+}
+
+func (s *memoryConversations) CreateGroup(name string, ownerID int, memberIDs []int) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	id := s.m.nextID("conversations")
+	s.m.conversations = append(s.m.conversations, Conversation{ID: id, Kind: ConversationGroup, Name: name})
+	s.m.members = append(s.m.members, memoryMember{conversationID: id, userID: ownerID, role: MemberOwner})
+	for _, memberID := range memberIDs {
+		if s.m.member(id, memberID) == nil {
+			s.m.members = append(s.m.members, memoryMember{conversationID: id, userID: memberID, role: MemberRole})
+		}
+	}
+	return id, nil
+}
+
+// member returns the membership of userID in a conversation, or nil; callers
+// must hold the lock.
+func (m *memory) member(conversationID int, userID int) *memoryMember {
+	for i := range m.members {
+		if m.members[i].conversationID == conversationID && m.members[i].userID == userID {
+			return &m.members[i]
+		}
+	}
+	return nil
+}
+
+// seenBy fills in the fields of c that depend on userID; callers must hold
+// the lock.
+func (m *memory) seenBy(c Conversation, userID int) (Conversation, time.Time) {
+	membership := m.member(c.ID, userID)
+	if membership != nil {
+		c.Role = membership.role
+	}
+	var last time.Time
+	for _, msg := range m.conversationMessages {
+		if msg.conversationID != c.ID {
+			continue
+		}
+		if msg.createdAt.After(last) {
+			last = msg.createdAt
+		}
+		if membership != nil && msg.id > membership.lastReadID && msg.senderID != userID {
+			c.Unread++
+		}
+	}
+	if !last.IsZero() {
+		c.LastMessageAt = last.Format(time.RFC3339)
+	}
+	return c, last
+}
+
+func (s *memoryConversations) ByID(id int, userID int) (Conversation, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	for _, c := range s.m.conversations {
+		if c.ID == id {
+			c, _ = s.m.seenBy(c, userID)
+			return c, nil
+		}
+	}
+	return Conversation{}, ErrNotFound
+}
+
+func (s *memoryConversations) ForUser(userID int) ([]Conversation, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	var conversations []Conversation
+	lastMessage := make(map[int]time.Time)
+	for _, c := range s.m.conversations {
+		c, last := s.m.seenBy(c, userID)
+		if c.Kind != ConversationChannel && c.Role == "" {
+			continue
+		}
+		conversations = append(conversations, c)
+		lastMessage[c.ID] = last
+	}
+	sort.SliceStable(conversations, func(i, j int) bool {
+		ti, tj := lastMessage[conversations[i].ID], lastMessage[conversations[j].ID]
+		if !ti.Equal(tj) {
+			if ti.IsZero() || tj.IsZero() {
+				return tj.IsZero()
+			}
+			return ti.After(tj)
+		}
+		return strings.ToLower(conversations[i].Name) < strings.ToLower(conversations[j].Name)
+	})
+	return conversations, nil
+}
+
+func (s *memoryConversations) AddMember(conversationID int, userID int, role string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if s.m.member(conversationID, userID) != nil {
+		return ErrConflict
+	}
+	s.m.members = append(s.m.members, memoryMember{conversationID: conversationID, userID: userID, role: role})
+	return nil
+}
+
+func (s *memoryConversations) RemoveMember(conversationID int, userID int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for i, member := range s.m.members {
+		if member.conversationID == conversationID && member.userID == userID {
+			s.m.members = append(s.m.members[:i], s.m.members[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memoryConversations) Members(conversationID int) ([]string, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	var usernames []string
+	for _, member := range s.m.members {
+		if member.conversationID != conversationID {
+			continue
+		}
+		if user, ok := s.m.userByID(member.userID); ok {
+			usernames = append(usernames, user.Username)
+		}
+	}
+	sort.Slice(usernames, func(i, j int) bool { return strings.ToLower(usernames[i]) < strings.ToLower(usernames[j]) })
+	return usernames, nil
+}
+
+func (s *memoryConversations) Post(conversationID int, senderID int, content string, attachmentID int) (PrivateMessage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	msg := memoryMessage{
+		id:             s.m.nextID("conversation_messages"),
+		senderID:       senderID,
+		content:        content,
+		contentHTML:    s.m.render(content),
+		createdAt:      time.Now().UTC(),
+		conversationID: conversationID,
+		attachment:     s.m.attachment(attachmentID),
+	}
+	s.m.conversationMessages = append(s.m.conversationMessages, msg)
+	public := msg.public()
+	if sender, ok := s.m.userByID(senderID); ok {
+		public.SenderName = sender.Username
+	}
+	return public, nil
+}
+
+func (s *memoryConversations) Messages(conversationID int, q MessageQuery) (MessagePage, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	if q.Limit <= 0 {
+		q.Limit = 10
+	}
+	var found []memoryMessage
+	for _, msg := range s.m.conversationMessages {
+		if msg.conversationID == conversationID {
+			found = append(found, msg)
+		}
+	}
+	page := memoryPage(found, q)
+	for i, msg := range page.Messages {
+		if sender, ok := s.m.userByID(msg.SenderID); ok {
+			page.Messages[i].SenderName = sender.Username
+		}
+	}
+	return page, nil
+}
+
+func (s *memoryConversations) MarkRead(conversationID int, userID int, upTo int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	membership := s.m.member(conversationID, userID)
+	if membership == nil {
+		return nil
+	}
+	// Never beyond the newest message, so that later ones count as unread.
+	for _, msg := range s.m.conversationMessages {
+		if msg.conversationID == conversationID && msg.id > membership.lastReadID && (upTo == 0 || msg.id <= upTo) {
+			membership.lastReadID = msg.id
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+type memoryIdentities struct {
+	m *memory
+}
+
+func (s *memoryIdentities) Link(identity Identity) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	return s.link(identity)
+}
+
+// link stores identity; callers must hold the lock.
+func (s *memoryIdentities) link(identity Identity) error {
+	for _, existing := range s.m.identities {
+		if existing.Provider != identity.Provider {
+			continue
+		}
+		if existing.Subject == identity.Subject || existing.UserID == identity.UserID {
+			return ErrConflict
+		}
+	}
+	identity.CreatedAt = time.Now()
+	s.m.identities = append(s.m.identities, identity)
+	return nil
+}
+
+func (s *memoryIdentities) SignUp(user User, identity Identity) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if err := s.m.checkNewUser(user); err != nil {
+		return 0, err
+	}
+	// A new user has no identities, so only the subject can conflict.
+	for _, existing := range s.m.identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return 0, ErrConflict
+		}
+	}
+	user.ID = s.m.nextID("users")
+	s.m.users = append(s.m.users, user)
+	identity.UserID = user.ID
+	return user.ID, s.link(identity)
+}
+
+func (s *memoryIdentities) Get(provider string, subject string) (Identity, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	for _, identity := range s.m.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return Identity{}, ErrNotFound
+}
+
+func (s *memoryIdentities) ForUser(userID int) ([]Identity, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	var identities []Identity
+	for _, identity := range s.m.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Provider < identities[j].Provider })
+	return identities, nil
+}
+
+func (s *memoryIdentities) Unlink(userID int, provider string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for i, identity := range s.m.identities {
+		if identity.UserID == userID && identity.Provider == provider {
+			s.m.identities = append(s.m.identities[:i], s.m.identities[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+type memoryMessages struct {
+	m *memory
+}
+
+func (s *memoryMessages) Create(senderID int, receiverID int, content string, attachmentID int) (PrivateMessage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	msg := memoryMessage{
+		id:          s.m.nextID("private_messages"),
+		senderID:    senderID,
+		receiverID:  receiverID,
+		content:     content,
+		contentHTML: s.m.render(content),
+		createdAt:   time.Now().UTC(),
+		attachment:  s.m.attachment(attachmentID),
+	}
+	s.m.messages = append(s.m.messages, msg)
+	return msg.public(), nil
+}
+
+func (s *memoryMessages) Conversation(userA int, userB int, q MessageQuery) (MessagePage, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	if q.Limit <= 0 {
+		q.Limit = 10
+	}
+	var found []memoryMessage
+	for _, msg := range s.m.messages {
+		if (msg.senderID == userA && msg.receiverID == userB) || (msg.senderID == userB && msg.receiverID == userA) {
+			found = append(found, msg)
+		}
+	}
+	return memoryPage(found, q), nil
+}
+
+// memoryPage returns the page of the messages of a conversation selected by
+// q, like the SQLite stores do.
+func memoryPage(conversation []memoryMessage, q MessageQuery) MessagePage {
+	var found []memoryMessage
+	for _, msg := range conversation {
+		c := msg.cursor()
+		if !q.Before.IsZero() && !c.before(q.Before) {
+			continue
+		}
+		if !q.After.IsZero() && !q.After.before(c) {
+			continue
+		}
+		found = append(found, msg)
+	}
+	// Newest first unless paging forward from After.
+	sort.Slice(found, func(i, j int) bool {
+		if q.After.IsZero() {
+			return found[j].cursor().before(found[i].cursor())
+		}
+		return found[i].cursor().before(found[j].cursor())
+	})
+
+	var messages []PrivateMessage
+	for i := 0; i < len(found) && i <= q.Limit; i++ {
+		messages = append(messages, found[i].public())
+	}
+	return newMessagePage(q, messages)
+}
+
+func (s *memoryMessages) MarkRead(readerID int, senderID int, upTo int) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	newest := 0
+	now := time.Now().UTC()
+	for i := range s.m.messages {
+		msg := &s.m.messages[i]
+		if msg.receiverID != readerID || msg.senderID != senderID || !msg.readAt.IsZero() {
+			continue
+		}
+		if upTo != 0 && msg.id > upTo {
+			continue
+		}
+		msg.readAt = now
+		if msg.deliveredAt.IsZero() {
+			msg.deliveredAt = now
+		}
+		if msg.id > newest {
+			newest = msg.id
+		}
+	}
+	return newest, nil
+}
+
+func (s *memoryMessages) Pending(receiverID int, limit int) ([]PrivateMessage, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	var messages []PrivateMessage
+	for _, msg := range s.m.messages {
+		if len(messages) == limit {
+			break
+		}
+		if msg.receiverID == receiverID && msg.deliveredAt.IsZero() && msg.deletedAt.IsZero() {
+			messages = append(messages, msg.public())
+		}
+	}
+	return messages, nil
+}
+
+func (s *memoryMessages) MarkDelivered(receiverID int, id int) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	msg := s.m.message(id)
+	if msg == nil || msg.receiverID != receiverID || !msg.deliveredAt.IsZero() {
+		return 0, nil
+	}
+	msg.deliveredAt = time.Now().UTC()
+	return msg.senderID, nil
+}
+
+func (msg memoryMessage) cursor() Cursor {
+	return Cursor{Time: msg.createdAt, ID: msg.id}
+}
+
+// message returns the private message id; callers must hold the lock.
+func (m *memory) message(id int) *memoryMessage {
+	for i := range m.messages {
+		if m.messages[i].id == id {
+			return &m.messages[i]
+		}
+	}
+	return nil
+}
+
+func (s *memoryMessages) ByID(id int) (PrivateMessage, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	msg := s.m.message(id)
+	if msg == nil {
+		return PrivateMessage{}, ErrNotFound
+	}
+	public := msg.public()
+	public.Content = msg.content
+	public.ContentHTML = msg.contentHTML
+	public.Attachment = msg.attachment
+	return public, nil
+}
+
+func (s *memoryMessages) Edit(id int, content string) (PrivateMessage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	msg := s.m.message(id)
+	if msg == nil || !msg.deletedAt.IsZero() {
+		return PrivateMessage{}, ErrNotFound
+	}
+	now := time.Now().UTC()
+	msg.revisions = append(msg.revisions, MessageRevision{Content: msg.content, ReplacedAt: now.Format(time.RFC3339Nano)})
+	msg.content = content
+	msg.contentHTML = s.m.render(content)
+	msg.editedAt = now
+	return msg.public(), nil
+}
+
+func (s *memoryMessages) Delete(id int) (PrivateMessage, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	msg := s.m.message(id)
+	if msg == nil || !msg.deletedAt.IsZero() {
+		return PrivateMessage{}, ErrNotFound
+	}
+	msg.deletedAt = time.Now().UTC()
+	return msg.public(), nil
+}
+
+func (s *memoryMessages) Revisions(id int) ([]MessageRevision, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	revisions := []MessageRevision{}
+	if msg := s.m.message(id); msg != nil {
+		revisions = append(revisions, msg.revisions...)
+	}
+	return revisions, nil
+}
+
+func (s *memoryMessages) HaveChatted(userA int, userB int) (bool, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	for _, msg := range s.m.messages {
+		if (msg.senderID == userA && msg.receiverID == userB) || (msg.senderID == userB && msg.receiverID == userA) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (msg memoryMessage) public() PrivateMessage {
+	public := PrivateMessage{
+		ID:          msg.id,
+		Sender:      strconv.Itoa(msg.senderID),
+		Receiver:    strconv.Itoa(msg.receiverID),
+		SenderID:    msg.senderID,
+		ReceiverID:  msg.receiverID,
+		Content:     msg.content,
+		ContentHTML: msg.contentHTML,
+		Timestamp:   msg.createdAt.Format(time.RFC3339Nano),
+		Cursor:      msg.cursor().String(),
+		// Attachments never change once uploaded, so it is safe to share.
+		Attachment: msg.attachment,
+	}
+	if !msg.deliveredAt.IsZero() {
+		public.DeliveredAt = msg.deliveredAt.Format(time.RFC3339Nano)
+	}
+	if !msg.readAt.IsZero() {
+		public.ReadAt = msg.readAt.Format(time.RFC3339Nano)
+	}
+	if !msg.editedAt.IsZero() {
+		public.EditedAt = msg.editedAt.Format(time.RFC3339Nano)
+	}
+	if !msg.deletedAt.IsZero() {
+		public.Content = ""
+		public.ContentHTML = ""
+		public.Attachment = nil
+		public.DeletedAt = msg.deletedAt.Format(time.RFC3339Nano)
+	}
+	if msg.conversationID != 0 {
+		public.Receiver = ""
+		public.Conversation = msg.conversationID
+	} else {
+		public.Status = messageStatus(public.DeliveredAt, public.ReadAt)
+	}
+	return public
+}
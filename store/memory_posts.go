@@ -0,0 +1,180 @@
+package store
+
+import "time"
+
+type memoryPosts struct {
+	m *memory
+}
+
+func (s *memoryPosts) Create(userID int, title string, content string, categories []int) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	user, _ := s.m.userByID(userID)
+	post := memoryPost{
+		Post: Post{
+			ID:          s.m.nextID("posts"),
+			Title:       title,
+			Content:     content,
+			ContentHTML: s.m.render(content),
+			Username:    user.Username,
+			CreatedAt:   time.Now().UTC(),
+		},
+		userID: userID,
+	}
+	for _, categoryID := range categories {
+		if categoryID != 0 {
+			post.categories = append(post.categories, categoryID)
+		}
+	}
+	s.m.posts = append(s.m.posts, post)
+	return post.ID, nil
+}
+
+func (s *memoryPosts) filter(match func(memoryPost) bool) []Post {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	var posts []Post
+	for _, post := range s.m.posts {
+		if match(post) {
+			posts = append(posts, post.Post)
+		}
+	}
+	return posts
+}
+
+func (s *memoryPosts) ByID(postID int) (Post, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	if post := s.m.post(postID); post != nil {
+		return post.Post, nil
+	}
+	return Post{}, ErrNotFound
+}
+
+func (s *memoryPosts) All() ([]Post, error) {
+	return s.filter(func(memoryPost) bool { return true }), nil
+}
+
+func (s *memoryPosts) ByAuthor(username string) ([]Post, error) {
+	return s.filter(func(post memoryPost) bool { return post.Username == username }), nil
+}
+
+func (s *memoryPosts) LikedBy(username string) ([]Post, error) {
+	s.m.mu.RLock()
+	user, ok := s.m.userByUsername(username)
+	s.m.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return s.filter(func(post memoryPost) bool {
+		return s.m.postVotes[[2]int{post.ID, user.ID}] == "like"
+	}), nil
+}
+
+func (s *memoryPosts) ByCategories(categories []int) ([]Post, error) {
+	return s.filter(func(post memoryPost) bool {
+		for _, want := range categories {
+			for _, has := range post.categories {
+				if want != 0 && want == has {
+					return true
+				}
+			}
+		}
+		return false
+	}), nil
+}
+
+func (s *memoryPosts) Flagged() ([]Post, error) {
+	return s.filter(func(post memoryPost) bool { return post.flagged }), nil
+}
+
+func (s *memoryPosts) CountFlagged() (int, error) {
+	posts, _ := s.Flagged()
+	return len(posts), nil
+}
+
+func (s *memoryPosts) Flag(postID int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for i := range s.m.posts {
+		if s.m.posts[i].ID == postID {
+			s.m.posts[i].flagged = true
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memoryPosts) Delete(postID int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for i := range s.m.posts {
+		if s.m.posts[i].ID == postID {
+			s.m.posts = append(s.m.posts[:i], s.m.posts[i+1:]...)
+			s.deleteComments(postID)
+			for key := range s.m.postVotes {
+				if key[0] == postID {
+					delete(s.m.postVotes, key)
+				}
+			}
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// deleteComments removes the comments of the post with their votes.
+func (s *memoryPosts) deleteComments(postID int) {
+	kept := s.m.comments[:0]
+	for _, comment := range s.m.comments {
+		if comment.PostID != postID {
+			kept = append(kept, comment)
+			continue
+		}
+		for key := range s.m.commentVotes {
+			if key[0] == comment.ID {
+				delete(s.m.commentVotes, key)
+			}
+		}
+	}
+	s.m.comments = kept
+}
+
+func (s *memoryPosts) Edit(postID int, editorID int, title string, content string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	post := s.m.post(postID)
+	if post == nil {
+		return ErrNotFound
+	}
+	editor, _ := s.m.userByID(editorID)
+	now := time.Now().UTC()
+	post.revisions = append(post.revisions, Revision{
+		Title:      post.Title,
+		Content:    post.Content,
+		Editor:     editor.Username,
+		ReplacedAt: now.Format(time.RFC3339),
+	})
+	post.Title = title
+	post.Content = content
+	post.ContentHTML = s.m.render(content)
+	post.EditedAt = &now
+	return nil
+}
+
+func (s *memoryPosts) Revisions(postID int) ([]Revision, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	revisions := []Revision{}
+	if post := s.m.post(postID); post != nil {
+		revisions = append(revisions, post.revisions...)
+	}
+	return revisions, nil
+}
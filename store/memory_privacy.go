@@ -0,0 +1,96 @@
+package store
+
+import (
+	"sort"
+	"strings"
+)
+
+type memoryPrivacy struct {
+	m *memory
+}
+
+func (s *memoryPrivacy) Block(blockerID int, blockedID int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	key := [2]int{blockerID, blockedID}
+	if s.m.blocks[key] {
+		return ErrConflict
+	}
+	s.m.blocks[key] = true
+	return nil
+}
+
+func (s *memoryPrivacy) Unblock(blockerID int, blockedID int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	key := [2]int{blockerID, blockedID}
+	if !s.m.blocks[key] {
+		return ErrNotFound
+	}
+	delete(s.m.blocks, key)
+	return nil
+}
+
+func (s *memoryPrivacy) Blocked(blockerID int) ([]string, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	usernames := []string{}
+	for key := range s.m.blocks {
+		if key[0] != blockerID {
+			continue
+		}
+		if user, ok := s.m.userByID(key[1]); ok {
+			usernames = append(usernames, user.Username)
+		}
+	}
+	sort.Slice(usernames, func(i, j int) bool { return strings.ToLower(usernames[i]) < strings.ToLower(usernames[j]) })
+	return usernames, nil
+}
+
+func (s *memoryPrivacy) BlockedBy(blockedID int) ([]string, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	usernames := []string{}
+	for key := range s.m.blocks {
+		if key[1] != blockedID {
+			continue
+		}
+		if user, ok := s.m.userByID(key[0]); ok {
+			usernames = append(usernames, user.Username)
+		}
+	}
+	sort.Slice(usernames, func(i, j int) bool { return strings.ToLower(usernames[i]) < strings.ToLower(usernames[j]) })
+	return usernames, nil
+}
+
+func (s *memoryPrivacy) IsBlocked(blockerID int, blockedID int) (bool, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	return s.m.blocks[[2]int{blockerID, blockedID}], nil
+}
+
+func (s *memoryPrivacy) Setting(userID int) (string, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	if _, ok := s.m.userByID(userID); !ok {
+		return "", ErrNotFound
+	}
+	if setting, ok := s.m.privacy[userID]; ok {
+		return setting, nil
+	}
+	return PrivacyEveryone, nil
+}
+
+func (s *memoryPrivacy) SetSetting(userID int, setting string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	s.m.privacy[userID] = setting
+	return nil
+}
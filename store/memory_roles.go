@@ -0,0 +1,11 @@
+package store
+
+type memoryRoles map[string]int
+
+func (s memoryRoles) Rank(role string) (int, error) {
+	rank, ok := s[role]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return rank, nil
+}
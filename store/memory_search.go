@@ -0,0 +1,136 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+type memorySearch struct {
+	m *memory
+}
+
+// Search finds contents holding every term, ignoring case. Without an index
+// there is no ranking, so the newest results come first, and snippets are
+// the whole content.
+func (s *memorySearch) Search(q SearchQuery) (SearchPage, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	if q.Limit <= 0 {
+		q.Limit = 20
+	}
+	terms := searchTerms(q.Text)
+	if len(terms) == 0 {
+		return newSearchPage(q, nil), nil
+	}
+
+	type found struct {
+		SearchResult
+		createdAt time.Time
+	}
+	var results []found
+	inCategory := func(postID int) bool {
+		if q.Category == 0 {
+			return true
+		}
+		for _, post := range s.m.posts {
+			if post.ID == postID {
+				for _, c := range post.categories {
+					if c == q.Category {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+	add := func(r SearchResult, content string, createdAt time.Time) {
+		if q.Author != "" && r.Author != q.Author {
+			return
+		}
+		if snippet, ok := memorySnippet(content, terms); ok {
+			r.Snippet = snippet
+			r.CreatedAt = createdAt.Format(time.RFC3339)
+			results = append(results, found{r, createdAt})
+		}
+	}
+
+	if q.wants(SearchPost) {
+		for _, post := range s.m.posts {
+			if inCategory(post.ID) {
+				author, _ := s.m.userByID(post.userID)
+				add(SearchResult{Kind: SearchPost, ID: post.ID, PostID: post.ID, Author: author.Username}, post.Title+"\n"+post.Content, post.CreatedAt)
+			}
+		}
+	}
+	if q.wants(SearchComment) {
+		for _, comment := range s.m.comments {
+			if !comment.Deleted && s.m.postExists(comment.PostID) && inCategory(comment.PostID) {
+				author, _ := s.m.userByID(comment.userID)
+				add(SearchResult{Kind: SearchComment, ID: comment.ID, PostID: comment.PostID, Author: author.Username}, comment.Content, comment.CreatedAt)
+			}
+		}
+	}
+	if q.wants(SearchMessage) {
+		for _, msg := range s.m.messages {
+			if !msg.deletedAt.IsZero() || (msg.senderID != q.UserID && msg.receiverID != q.UserID) {
+				continue
+			}
+			sender, _ := s.m.userByID(msg.senderID)
+			other := sender
+			if msg.senderID == q.UserID {
+				other, _ = s.m.userByID(msg.receiverID)
+			}
+			add(SearchResult{Kind: SearchMessage, ID: msg.id, Author: sender.Username, With: other.Username}, msg.content, msg.createdAt)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].createdAt.After(results[j].createdAt) })
+	var page []SearchResult
+	for i := q.Offset; i < len(results) && i <= q.Offset+q.Limit; i++ {
+		page = append(page, results[i].SearchResult)
+	}
+	return newSearchPage(q, page), nil
+}
+
+// memorySnippet returns content with the terms marked, or false when some
+// term is missing from it.
+func memorySnippet(content string, terms []searchTerm) (string, bool) {
+	lower := strings.ToLower(content)
+	for _, term := range terms {
+		if !strings.Contains(lower, strings.ToLower(term.text)) {
+			return "", false
+		}
+	}
+	if len(lower) != len(content) {
+		// Lowering changed the length, so positions would not line up.
+		return snippetHTML(content), true
+	}
+
+	marked := make([]bool, len(content))
+	for _, term := range terms {
+		t := strings.ToLower(term.text)
+		for i := 0; ; {
+			n := strings.Index(lower[i:], t)
+			if n < 0 {
+				break
+			}
+			for j := i + n; j < i+n+len(t); j++ {
+				marked[j] = true
+			}
+			i += n + len(t)
+		}
+	}
+	var b strings.Builder
+	for i := 0; i < len(content); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(markStart)
+		}
+		b.WriteByte(content[i])
+		if marked[i] && (i == len(content)-1 || !marked[i+1]) {
+			b.WriteString(markEnd)
+		}
+	}
+	return snippetHTML(b.String()), true
+}
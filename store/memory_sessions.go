@@ -0,0 +1,81 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+type memorySessions struct {
+	mu       sync.RWMutex
+	sessions map[string]Session
+}
+
+// NewMemorySessions returns a SessionStore that keeps sessions in memory.
+// They are lost when the server restarts.
+func NewMemorySessions() SessionStore {
+	return &memorySessions{sessions: make(map[string]Session)}
+}
+
+func (s *memorySessions) Create(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.Token] = session
+	return nil
+}
+
+func (s *memorySessions) Get(token string) (Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return Session{}, ErrNotFound
+	}
+	return session, nil
+}
+
+func (s *memorySessions) SetExpiry(token string, expiry time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[token]; ok {
+		session.Expiry = expiry
+		s.sessions[token] = session
+	}
+	return nil
+}
+
+func (s *memorySessions) Delete(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, token)
+	return nil
+}
+
+func (s *memorySessions) DeleteForUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, session := range s.sessions {
+		if session.Username == username {
+			delete(s.sessions, token)
+		}
+	}
+	return nil
+}
+
+func (s *memorySessions) DeleteExpired(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for token, session := range s.sessions {
+		if session.Expiry.Before(now) {
+			delete(s.sessions, token)
+			count++
+		}
+	}
+	return count, nil
+}
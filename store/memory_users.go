@@ -0,0 +1,171 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type memoryUsers struct {
+	m *memory
+}
+
+func (s *memoryUsers) Create(user User) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if err := s.m.checkNewUser(user); err != nil {
+		return 0, err
+	}
+	user.ID = s.m.nextID("users")
+	s.m.users = append(s.m.users, user)
+	return user.ID, nil
+}
+
+// checkNewUser fails like SQLite when user could not be inserted.
+func (m *memory) checkNewUser(user User) error {
+	for _, existing := range m.users {
+		if existing.Username == user.Username {
+			return fmt.Errorf("failed to insert user: UNIQUE constraint failed: users.username")
+		}
+		if existing.Email == user.Email {
+			return fmt.Errorf("failed to insert user: UNIQUE constraint failed: users.email")
+		}
+	}
+	return nil
+}
+
+func (s *memoryUsers) ByID(id int) (User, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	user, ok := s.m.userByID(id)
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *memoryUsers) ByUsername(username string) (User, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	user, ok := s.m.userByUsername(username)
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *memoryUsers) ByLogin(usernameOrEmail string) (User, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	for _, user := range s.m.users {
+		if user.Username == usernameOrEmail || user.Email == usernameOrEmail {
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memoryUsers) Userlist(userID int) ([]Userlist, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	lastMessage := make(map[int]time.Time)
+	unread := make(map[int]int)
+	for _, msg := range s.m.messages {
+		if msg.senderID != userID && msg.receiverID != userID {
+			continue
+		}
+		if msg.receiverID == userID && msg.readAt.IsZero() && msg.deletedAt.IsZero() {
+			unread[msg.senderID]++
+		}
+		for _, id := range []int{msg.senderID, msg.receiverID} {
+			if msg.createdAt.After(lastMessage[id]) {
+				lastMessage[id] = msg.createdAt
+			}
+		}
+	}
+
+	users := make([]User, len(s.m.users))
+	copy(users, s.m.users)
+	sort.SliceStable(users, func(i, j int) bool {
+		ti, tj := lastMessage[users[i].ID], lastMessage[users[j].ID]
+		if !ti.Equal(tj) {
+			if ti.IsZero() || tj.IsZero() {
+				return tj.IsZero()
+			}
+			return ti.After(tj)
+		}
+		return strings.ToLower(users[i].Username) < strings.ToLower(users[j].Username)
+	})
+
+	var list []Userlist
+	for _, user := range users {
+		if s.m.blocks[[2]int{userID, user.ID}] {
+			continue
+		}
+		entry := Userlist{ID: user.ID, Username: user.Username, Unread: unread[user.ID]}
+		if seen, ok := s.m.lastSeen[user.ID]; ok {
+			entry.LastSeen = seen.Format(time.RFC3339)
+		}
+		list = append(list, entry)
+	}
+	return list, nil
+}
+
+func (s *memoryUsers) SetLastSeen(userID int, at time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	s.m.lastSeen[userID] = at.UTC()
+	return nil
+}
+
+func (s *memoryUsers) SetRole(username string, role string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for i := range s.m.users {
+		if s.m.users[i].Username == username {
+			s.m.users[i].Role = role
+		}
+	}
+	return nil
+}
+
+func (s *memoryUsers) ClearModeratorApplication(username string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for i := range s.m.users {
+		if s.m.users[i].Username == username {
+			s.m.users[i].AppliesForModerator = false
+		}
+	}
+	return nil
+}
+
+func (s *memoryUsers) ModeratorApplicants() ([]string, error) {
+	return s.usernames(func(user User) bool { return user.AppliesForModerator }), nil
+}
+
+func (s *memoryUsers) Moderators() ([]string, error) {
+	return s.usernames(func(user User) bool { return user.Role == "moderator" }), nil
+}
+
+func (s *memoryUsers) usernames(match func(User) bool) []string {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	var usernames []string
+	for _, user := range s.m.users {
+		if match(user) {
+			usernames = append(usernames, user.Username)
+		}
+	}
+	return usernames
+}
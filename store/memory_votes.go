@@ -0,0 +1,33 @@
+package store
+
+type memoryVotes struct {
+	m *memory
+}
+
+func (s *memoryVotes) votes(comment bool) map[[2]int]string {
+	if comment {
+		return s.m.commentVotes
+	}
+	return s.m.postVotes
+}
+
+func (s *memoryVotes) Vote(targetID int, userID int, voteType string, comment bool) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	s.votes(comment)[[2]int{targetID, userID}] = voteType
+	return nil
+}
+
+func (s *memoryVotes) Count(targetID int, voteType string, comment bool) (int, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	count := 0
+	for key, vote := range s.votes(comment) {
+		if key[0] == targetID && vote == voteType {
+			count++
+		}
+	}
+	return count, nil
+}
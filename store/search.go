@@ -0,0 +1,122 @@
+package store
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// What a search result is.
+const (
+	SearchPost    = "post"
+	SearchComment = "comment"
+	SearchMessage = "message"
+)
+
+// SearchQuery is a full-text search. Text holds words, which all have to
+// match, and "quoted phrases"; a word ending in * matches every word it
+// starts. Kinds limits the results to some of SearchPost, SearchComment and
+// SearchMessage, Author to one user and Category to the posts of a category
+// and their comments. Private messages are only found for UserID, the user
+// searching.
+type SearchQuery struct {
+	Text     string
+	Kinds    []string
+	Author   string
+	Category int
+	UserID   int
+	Limit    int
+	Offset   int
+}
+
+// SearchResult is a post, comment or private message matching a search.
+// Snippet is HTML: the matching part of the content, escaped, with the
+// matches in <mark> elements.
+type SearchResult struct {
+	Kind string `json:"kind"`
+	ID   int    `json:"id"`
+	// PostID is the post of comments, and the post itself for posts.
+	PostID int    `json:"postId,omitempty"`
+	Author string `json:"author"`
+	// With is the other user of private messages.
+	With      string `json:"with,omitempty"`
+	Snippet   string `json:"snippet"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// SearchPage holds the results of a search, best match first. More tells
+// whether there are results after Offset+Limit.
+type SearchPage struct {
+	Results []SearchResult `json:"results"`
+	More    bool           `json:"more"`
+}
+
+func (q SearchQuery) wants(kind string) bool {
+	if q.Category != 0 && kind == SearchMessage {
+		return false
+	}
+	if len(q.Kinds) == 0 {
+		return true
+	}
+	for _, k := range q.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// searchTerm is a word or phrase of a search.
+type searchTerm struct {
+	text   string
+	prefix bool
+}
+
+// searchTerms splits the text of a search into its words and phrases.
+func searchTerms(text string) []searchTerm {
+	var terms []searchTerm
+	for i, part := range strings.Split(text, `"`) {
+		if i%2 == 1 {
+			// Inside quotes
+			if phrase := strings.Join(strings.Fields(part), " "); hasWord(phrase) {
+				terms = append(terms, searchTerm{text: phrase})
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			term := searchTerm{text: strings.TrimRight(word, "*")}
+			term.prefix = term.text != word
+			if hasWord(term.text) {
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
+
+// hasWord tells whether s has anything the index would keep.
+func hasWord(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) >= 0
+}
+
+// Snippets mark matches with these until they are turned into HTML.
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// snippetHTML escapes a snippet and turns its marks into <mark> elements.
+func snippetHTML(snippet string) string {
+	return strings.NewReplacer(markStart, "<mark>", markEnd, "</mark>").Replace(html.EscapeString(snippet))
+}
+
+func newSearchPage(q SearchQuery, results []SearchResult) SearchPage {
+	page := SearchPage{Results: results, More: len(results) > q.Limit}
+	if page.More {
+		page.Results = results[:q.Limit]
+	}
+	if page.Results == nil {
+		page.Results = []SearchResult{}
+	}
+	return page
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	"forum/markdown"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// OpenSQLite opens the SQLite database file at path.
+func OpenSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare database connection: %w", err)
+	}
+	return db, nil
+}
+
+// NewSQLite returns a Store backed by db. The schema is expected to be
+// migrated already.
+func NewSQLite(db *sql.DB) *Store {
+	return &Store{
+		Users:         &sqliteUsers{db},
+		Posts:         &sqlitePosts{db},
+		Comments:      &sqliteComments{db},
+		Votes:         &sqliteVotes{db},
+		Messages:      &sqliteMessages{db},
+		Sessions:      &sqliteSessions{db},
+		Roles:         &sqliteRoles{db},
+		Identities:    &sqliteIdentities{db},
+		Conversations: &sqliteConversations{db},
+		Privacy:       &sqlitePrivacy{db},
+		Attachments:   &sqliteAttachments{db},
+		Search:        &sqliteSearch{db},
+	}
+}
+
+// renderSQLite renders Markdown content with mentions of the users in db.
+func renderSQLite(db *sql.DB, content string) string {
+	return markdown.Render(content, func(username string) bool {
+		var exists bool
+		db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE username = ?);", username).Scan(&exists)
+		return exists
+	})
+}
+
+// storedHTML returns the content_html of a row, rendering content when it is
+// NULL. Rows from before the column was added stay NULL until RenderContent
+// fills them, so that the migration does not depend on how Markdown is
+// rendered today.
+func storedHTML(db *sql.DB, html sql.NullString, content string) string {
+	if html.Valid {
+		return html.String
+	}
+	return renderSQLite(db, content)
+}
+
+// renderedTables are the tables with a content_html column.
+var renderedTables = []string{"posts", "comments", "private_messages", "conversation_messages"}
+
+// RenderContent stores the HTML of the rows whose content_html is NULL, so
+// that reading them stops rendering them every time. It returns how many
+// rows it filled.
+func RenderContent(db *sql.DB) (int, error) {
+	filled := 0
+	for _, table := range renderedTables {
+		html, err := renderMissing(db, table)
+		if err != nil {
+			return filled, fmt.Errorf("failed to render %s: %w", table, err)
+		}
+		if err := storeRendered(db, table, html); err != nil {
+			return filled, fmt.Errorf("failed to store %s: %w", table, err)
+		}
+		filled += len(html)
+	}
+	return filled, nil
+}
+
+// renderMissing renders the rows of table whose content_html is NULL, by ID.
+// All rows are read before rendering, which queries the users.
+func renderMissing(db *sql.DB, table string) (map[int]string, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT id, content FROM %s WHERE content_html IS NULL;", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	content := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var c string
+		if err := rows.Scan(&id, &c); err != nil {
+			return nil, err
+		}
+		content[id] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	html := make(map[int]string, len(content))
+	for id, c := range content {
+		html[id] = renderSQLite(db, c)
+	}
+	return html, nil
+}
+
+// storeRendered sets the content_html of the rows of table that are still
+// NULL, in one transaction.
+func storeRendered(db *sql.DB, table string, html map[int]string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("UPDATE %s SET content_html = ? WHERE id = ? AND content_html IS NULL;", table)
+	for id, h := range html {
+		if _, err := tx.Exec(query, h, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
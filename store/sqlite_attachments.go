@@ -0,0 +1,79 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type sqliteAttachments struct {
+	db *sql.DB
+}
+
+// attachmentColumn selects the attachment of a message as JSON, or NULL, for
+// scanAttachment.
+const attachmentColumn = `(SELECT json_object('id', a.id, 'name', a.name, 'contentType', a.content_type, 'size', a.size,
+        'thumbnail', json(CASE WHEN a.thumbnail_key != '' THEN 'true' ELSE 'false' END))
+    FROM attachments a WHERE a.id = attachment_id)`
+
+func scanAttachment(column sql.NullString) (*Attachment, error) {
+	if !column.Valid {
+		return nil, nil
+	}
+	var attachment Attachment
+	if err := json.Unmarshal([]byte(column.String), &attachment); err != nil {
+		return nil, fmt.Errorf("failed to decode attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+// attachmentParam stores attachment ID 0 as no attachment.
+func attachmentParam(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func (s *sqliteAttachments) Create(attachment Attachment) (int, error) {
+	res, err := s.db.Exec(`INSERT INTO attachments (uploader_id, name, content_type, size, storage_key, thumbnail_key)
+VALUES (?, ?, ?, ?, ?, ?);`, attachment.UploaderID, attachment.Name, attachment.ContentType, attachment.Size,
+		attachment.StorageKey, attachment.ThumbnailKey)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return 0, ErrConflict
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to insert attachment: %w", err)
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func (s *sqliteAttachments) ByID(id int) (Attachment, error) {
+	var a Attachment
+	err := s.db.QueryRow(`SELECT id, uploader_id, name, content_type, size, storage_key, thumbnail_key
+FROM attachments WHERE id = ?;`, id).Scan(&a.ID, &a.UploaderID, &a.Name, &a.ContentType, &a.Size, &a.StorageKey, &a.ThumbnailKey)
+	if err == sql.ErrNoRows {
+		return Attachment{}, ErrNotFound
+	} else if err != nil {
+		return Attachment{}, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	a.Thumbnail = a.ThumbnailKey != ""
+	return a, nil
+}
+
+func (s *sqliteAttachments) CanAccess(id int, userID int) (bool, error) {
+	var access bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM attachments WHERE id = ? AND uploader_id = ?)
+OR EXISTS (SELECT 1 FROM private_messages
+    WHERE attachment_id = ? AND deleted_at IS NULL AND (sender_id = ? OR receiver_id = ?))
+OR EXISTS (SELECT 1 FROM conversation_messages cm
+    JOIN conversations c ON c.id = cm.conversation_id
+    WHERE cm.attachment_id = ? AND (c.kind = 'channel' OR EXISTS (
+        SELECT 1 FROM conversation_members m WHERE m.conversation_id = c.id AND m.user_id = ?)));`,
+		id, userID, id, userID, userID, id, userID).Scan(&access)
+	if err != nil {
+		return false, fmt.Errorf("failed to check attachment access: %w", err)
+	}
+	return access, nil
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type sqliteComments struct {
+	db *sql.DB
+}
+
+func (s *sqliteComments) Create(postID int, parentID int, userID int, content string) (int, error) {
+	var parent interface{}
+	if parentID != 0 {
+		parent = parentID
+	}
+	res, err := s.db.Exec("INSERT INTO comments(post_id, parent_comment_id, user_id, content, content_html) VALUES (?, ?, ?, ?, ?)",
+		postID, parent, userID, content, renderSQLite(s.db, content))
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert comment: %w", err)
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+const commentColumns = `comments.id, comments.content, comments.content_html, comments.created_at, comments.edited_at, comments.post_id,
+    COALESCE(comments.parent_comment_id, 0), users.username, comments.deleted_at IS NOT NULL`
+
+func scanComment(db *sql.DB, scanner interface{ Scan(...interface{}) error }) (Comment, error) {
+	var comment Comment
+	var html sql.NullString
+	var editedAt sql.NullTime
+	err := scanner.Scan(&comment.ID, &comment.Content, &html, &comment.CreatedAt, &editedAt, &comment.PostID, &comment.ParentID, &comment.Username, &comment.Deleted)
+	if err != nil {
+		return Comment{}, err
+	}
+	comment.EditedAt = nullTimePtr(editedAt)
+	if comment.Deleted {
+		comment.Content = DeletedComment
+		comment.Username = ""
+	} else {
+		comment.ContentHTML = storedHTML(db, html, comment.Content)
+	}
+	return comment, nil
+}
+
+func (s *sqliteComments) ByID(id int) (Comment, error) {
+	comment, err := scanComment(s.db, s.db.QueryRow(`SELECT `+commentColumns+`
+FROM comments
+JOIN users ON comments.user_id = users.id
+WHERE comments.id = ?;`, id))
+	if err == sql.ErrNoRows {
+		return Comment{}, ErrNotFound
+	} else if err != nil {
+		return Comment{}, fmt.Errorf("failed to get comment: %w", err)
+	}
+	return comment, nil
+}
+
+func (s *sqliteComments) All() ([]Comment, error) {
+	rows, err := s.db.Query(`SELECT ` + commentColumns + `
+FROM comments
+JOIN posts ON comments.post_id = posts.id
+JOIN users ON comments.user_id = users.id
+ORDER BY comments.id;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		comment, err := scanComment(s.db, rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed after iterating rows: %w", err)
+	}
+	return comments, nil
+}
+
+func (s *sqliteComments) CountForPost(postID int) (count int, err error) {
+	err = s.db.QueryRow("SELECT COUNT(*) FROM comments WHERE post_id = ? AND deleted_at IS NULL", postID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count comments: %w", err)
+	}
+	return count, nil
+}
+
+func (s *sqliteComments) Delete(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Walk up from the comment while placeholders lose their last reply.
+	for first := true; id != 0; first = false {
+		var parentID, replies int
+		var deleted bool
+		err := tx.QueryRow(`SELECT COALESCE(parent_comment_id, 0), deleted_at IS NOT NULL,
+    (SELECT COUNT(*) FROM comments r WHERE r.parent_comment_id = comments.id)
+FROM comments WHERE id = ?;`, id).Scan(&parentID, &deleted, &replies)
+		if err == sql.ErrNoRows || (err == nil && first && deleted) {
+			return ErrNotFound
+		} else if err != nil {
+			return fmt.Errorf("failed to get comment: %w", err)
+		}
+		if !first && (!deleted || replies > 0) {
+			break
+		}
+
+		if replies > 0 {
+			_, err = tx.Exec("UPDATE comments SET content = '', content_html = '', deleted_at = CURRENT_TIMESTAMP WHERE id = ?;", id)
+		} else {
+			for _, query := range []string{
+				"DELETE FROM comment_votes WHERE comment_id = ?;",
+				"DELETE FROM comment_revisions WHERE comment_id = ?;",
+				"DELETE FROM comments WHERE id = ?;",
+			} {
+				if _, err = tx.Exec(query, id); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete comment: %w", err)
+		}
+		if replies > 0 {
+			break
+		}
+		id = parentID
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteComments) Edit(id int, editorID int, content string) error {
+	contentHTML := renderSQLite(s.db, content)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO comment_revisions (comment_id, content, editor_id)
+SELECT id, content, ? FROM comments WHERE id = ? AND deleted_at IS NULL;`, editorID, id)
+	if err != nil {
+		return fmt.Errorf("failed to keep revision: %w", err)
+	}
+	if count, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	} else if count == 0 {
+		return ErrNotFound
+	}
+	_, err = tx.Exec("UPDATE comments SET content = ?, content_html = ?, edited_at = CURRENT_TIMESTAMP WHERE id = ?;", content, contentHTML, id)
+	if err != nil {
+		return fmt.Errorf("failed to edit comment: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteComments) Revisions(id int) ([]Revision, error) {
+	return scanRevisions(s.db.Query(`SELECT '', comment_revisions.content, users.username, comment_revisions.replaced_at
+FROM comment_revisions
+JOIN users ON comment_revisions.editor_id = users.id
+WHERE comment_revisions.comment_id = ?
+ORDER BY comment_revisions.id;`, id))
+}
@@ -0,0 +1,210 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type sqliteConversations struct {
+	db *sql.DB
+}
+
+// conversationColumns selects a conversation as seen by the user bound to
+// the first two parameters, joined as m.
+const conversationColumns = `c.id, c.kind, c.name, COALESCE(c.category_id, 0), COALESCE(m.role, ''),
+    (SELECT COUNT(*) FROM conversation_messages cm
+        WHERE cm.conversation_id = c.id AND m.user_id IS NOT NULL AND cm.id > m.last_read_id AND cm.sender_id != ?),
+    (SELECT MAX(cm.created_at) FROM conversation_messages cm WHERE cm.conversation_id = c.id) AS last_message_at
+FROM conversations c
+LEFT JOIN conversation_members m ON m.conversation_id = c.id AND m.user_id = ?`
+
+func scanConversation(scanner interface{ Scan(...interface{}) error }) (Conversation, error) {
+	var c Conversation
+	var lastMessageAt sql.NullString
+	if err := scanner.Scan(&c.ID, &c.Kind, &c.Name, &c.CategoryID, &c.Role, &c.Unread, &lastMessageAt); err != nil {
+		return Conversation{}, err
+	}
+	// MAX loses the column type, so the time comes back as stored.
+	if t, err := time.Parse(sqliteTime, lastMessageAt.String); err == nil {
+		c.LastMessageAt = t.Format(time.RFC3339)
+	}
+	return c, nil
+}
+
+func (s *sqliteConversations) CreateGroup(name string, ownerID int, memberIDs []int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO conversations (kind, name) VALUES (?, ?);", ConversationGroup, name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create group: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT INTO conversation_members (conversation_id, user_id, role) VALUES (?, ?, ?);", id, ownerID, MemberOwner); err != nil {
+		return 0, fmt.Errorf("failed to add owner: %w", err)
+	}
+	for _, memberID := range memberIDs {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO conversation_members (conversation_id, user_id, role) VALUES (?, ?, ?);", id, memberID, MemberRole); err != nil {
+			return 0, fmt.Errorf("failed to add member: %w", err)
+		}
+	}
+	return int(id), tx.Commit()
+}
+
+func (s *sqliteConversations) ByID(id int, userID int) (Conversation, error) {
+	row := s.db.QueryRow("SELECT "+conversationColumns+" WHERE c.id = ?;", userID, userID, id)
+	c, err := scanConversation(row)
+	if err == sql.ErrNoRows {
+		return Conversation{}, ErrNotFound
+	} else if err != nil {
+		return Conversation{}, fmt.Errorf("failed to get conversation: %w", err)
+	}
+	return c, nil
+}
+
+func (s *sqliteConversations) ForUser(userID int) ([]Conversation, error) {
+	rows, err := s.db.Query("SELECT "+conversationColumns+`
+WHERE c.kind = 'channel' OR m.user_id IS NOT NULL
+ORDER BY last_message_at IS NULL, last_message_at DESC, LOWER(c.name);`, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+	for rows.Next() {
+		c, err := scanConversation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed after iterating rows: %w", err)
+	}
+	return conversations, nil
+}
+
+func (s *sqliteConversations) AddMember(conversationID int, userID int, role string) error {
+	_, err := s.db.Exec("INSERT INTO conversation_members (conversation_id, user_id, role) VALUES (?, ?, ?);", conversationID, userID, role)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrConflict
+	} else if err != nil {
+		return fmt.Errorf("failed to add member: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteConversations) RemoveMember(conversationID int, userID int) error {
+	res, err := s.db.Exec("DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?;", conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteConversations) Members(conversationID int) ([]string, error) {
+	rows, err := s.db.Query(`SELECT u.username FROM conversation_members m
+JOIN users u ON u.id = m.user_id
+WHERE m.conversation_id = ? ORDER BY LOWER(u.username);`, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var usernames []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		usernames = append(usernames, username)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed after iterating rows: %w", err)
+	}
+	return usernames, nil
+}
+
+// conversationMessageColumns selects a message of a group or channel for
+// scanConversationMessage.
+const conversationMessageColumns = "id, sender_id, (SELECT username FROM users WHERE users.id = sender_id), content, content_html, created_at, " + attachmentColumn
+
+func scanConversationMessage(db *sql.DB, scanner interface{ Scan(...interface{}) error }, conversationID int) (PrivateMessage, error) {
+	msg := PrivateMessage{Conversation: conversationID}
+	var createdAt time.Time
+	var html, attachment sql.NullString
+	if err := scanner.Scan(&msg.ID, &msg.SenderID, &msg.SenderName, &msg.Content, &html, &createdAt, &attachment); err != nil {
+		return PrivateMessage{}, err
+	}
+	msg.ContentHTML = storedHTML(db, html, msg.Content)
+	msg.Sender = strconv.Itoa(msg.SenderID)
+	setTime(&msg, createdAt)
+	var err error
+	msg.Attachment, err = scanAttachment(attachment)
+	return msg, err
+}
+
+func (s *sqliteConversations) Post(conversationID int, senderID int, content string, attachmentID int) (PrivateMessage, error) {
+	msg, err := scanConversationMessage(s.db, s.db.QueryRow(`INSERT INTO conversation_messages (conversation_id, sender_id, content, content_html, attachment_id) VALUES (?, ?, ?, ?, ?)
+RETURNING `+conversationMessageColumns, conversationID, senderID, content, renderSQLite(s.db, content), attachmentParam(attachmentID)), conversationID)
+	if err != nil {
+		return PrivateMessage{}, fmt.Errorf("failed to insert message: %w", err)
+	}
+	return msg, nil
+}
+
+func (s *sqliteConversations) Messages(conversationID int, q MessageQuery) (MessagePage, error) {
+	if q.Limit <= 0 {
+		q.Limit = 10
+	}
+	query, args := pageQuery("SELECT "+conversationMessageColumns+" FROM conversation_messages WHERE conversation_id = ?",
+		[]interface{}{conversationID}, q)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return MessagePage{}, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []PrivateMessage
+	for rows.Next() {
+		msg, err := scanConversationMessage(s.db, rows, conversationID)
+		if err != nil {
+			return MessagePage{}, fmt.Errorf("failed to scan row: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return MessagePage{}, fmt.Errorf("failed after iterating rows: %w", err)
+	}
+	return newMessagePage(q, messages), nil
+}
+
+func (s *sqliteConversations) MarkRead(conversationID int, userID int, upTo int) error {
+	if upTo == 0 {
+		upTo = math.MaxInt64
+	}
+	// Never beyond the newest message, so that later ones count as unread.
+	_, err := s.db.Exec(`UPDATE conversation_members
+SET last_read_id = MAX(last_read_id, MIN(?, (SELECT COALESCE(MAX(id), 0) FROM conversation_messages WHERE conversation_id = ?)))
+WHERE conversation_id = ? AND user_id = ?;`, upTo, conversationID, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to mark messages read: %w", err)
+	}
+	return nil
+}
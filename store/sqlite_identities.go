@@ -0,0 +1,86 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type sqliteIdentities struct {
+	db *sql.DB
+}
+
+func (s *sqliteIdentities) Link(identity Identity) error {
+	_, err := s.db.Exec("INSERT INTO provider_identities (provider, subject, user_id, email) VALUES (?, ?, ?, ?);",
+		identity.Provider, identity.Subject, identity.UserID, identity.Email)
+	return linkError(err)
+}
+
+func linkError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrConflict
+	} else if err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteIdentities) SignUp(user User, identity Identity) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	id, err := insertUser(tx, user)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("INSERT INTO provider_identities (provider, subject, user_id, email) VALUES (?, ?, ?, ?);",
+		identity.Provider, identity.Subject, id, identity.Email)
+	if err := linkError(err); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *sqliteIdentities) Get(provider string, subject string) (Identity, error) {
+	identity := Identity{Provider: provider, Subject: subject}
+	err := s.db.QueryRow("SELECT user_id, email, created_at FROM provider_identities WHERE provider = ? AND subject = ?;", provider, subject).
+		Scan(&identity.UserID, &identity.Email, &identity.CreatedAt)
+	if err == sql.ErrNoRows {
+		return Identity{}, ErrNotFound
+	} else if err != nil {
+		return Identity{}, fmt.Errorf("failed to get identity: %w", err)
+	}
+	return identity, nil
+}
+
+func (s *sqliteIdentities) ForUser(userID int) ([]Identity, error) {
+	rows, err := s.db.Query("SELECT provider, subject, user_id, email, created_at FROM provider_identities WHERE user_id = ? ORDER BY provider;", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identities: %w", err)
+	}
+	defer rows.Close()
+
+	var identities []Identity
+	for rows.Next() {
+		var identity Identity
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan identity: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (s *sqliteIdentities) Unlink(userID int, provider string) error {
+	res, err := s.db.Exec("DELETE FROM provider_identities WHERE user_id = ? AND provider = ?;", userID, provider)
+	if err != nil {
+		return fmt.Errorf("failed to unlink identity: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
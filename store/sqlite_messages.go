@@ -0,0 +1,268 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// sqliteTime is how CURRENT_TIMESTAMP stores times, so that cursors compare
+// with created_at as text.
+const sqliteTime = "2006-01-02 15:04:05"
+
+type sqliteMessages struct {
+	db *sql.DB
+}
+
+// messageColumns selects a private message for scanMessage. Deleted messages
+// come without content or attachment, use rawMessageColumns to keep them.
+const (
+	messageColumns    = "id, sender_id, receiver_id, CASE WHEN deleted_at IS NULL THEN content ELSE '' END, CASE WHEN deleted_at IS NULL THEN content_html ELSE '' END, created_at, delivered_at, read_at, edited_at, deleted_at, CASE WHEN deleted_at IS NULL THEN " + attachmentColumn + " END"
+	rawMessageColumns = "id, sender_id, receiver_id, content, content_html, created_at, delivered_at, read_at, edited_at, deleted_at, " + attachmentColumn
+)
+
+func scanMessage(db *sql.DB, scanner interface{ Scan(...interface{}) error }) (PrivateMessage, error) {
+	var msg PrivateMessage
+	var createdAt time.Time
+	var deliveredAt, readAt, editedAt, deletedAt sql.NullTime
+	var html, attachment sql.NullString
+	if err := scanner.Scan(&msg.ID, &msg.SenderID, &msg.ReceiverID, &msg.Content, &html, &createdAt, &deliveredAt, &readAt, &editedAt, &deletedAt, &attachment); err != nil {
+		return PrivateMessage{}, err
+	}
+	msg.ContentHTML = storedHTML(db, html, msg.Content)
+	msg.Sender = strconv.Itoa(msg.SenderID)
+	msg.Receiver = strconv.Itoa(msg.ReceiverID)
+	var err error
+	if msg.Attachment, err = scanAttachment(attachment); err != nil {
+		return PrivateMessage{}, err
+	}
+	setTime(&msg, createdAt)
+	msg.DeliveredAt = formatNullTime(deliveredAt)
+	msg.ReadAt = formatNullTime(readAt)
+	msg.Status = messageStatus(msg.DeliveredAt, msg.ReadAt)
+	msg.EditedAt = formatNullTime(editedAt)
+	msg.DeletedAt = formatNullTime(deletedAt)
+	return msg, nil
+}
+
+// messageStatus tells how far a private message got from when it was
+// delivered and read.
+func messageStatus(deliveredAt string, readAt string) string {
+	switch {
+	case readAt != "":
+		return MessageRead
+	case deliveredAt != "":
+		return MessageDelivered
+	}
+	return MessagePending
+}
+
+func formatNullTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format(time.RFC3339)
+}
+
+func (s *sqliteMessages) Create(senderID int, receiverID int, content string, attachmentID int) (PrivateMessage, error) {
+	msg, err := scanMessage(s.db, s.db.QueryRow("INSERT INTO private_messages (content, content_html, sender_id, receiver_id, attachment_id) VALUES (?, ?, ?, ?, ?) RETURNING "+messageColumns,
+		content, renderSQLite(s.db, content), senderID, receiverID, attachmentParam(attachmentID)))
+	if err != nil {
+		return PrivateMessage{}, fmt.Errorf("failed to insert message: %w", err)
+	}
+	return msg, nil
+}
+
+func (s *sqliteMessages) Conversation(userA int, userB int, q MessageQuery) (MessagePage, error) {
+	if q.Limit <= 0 {
+		q.Limit = 10
+	}
+	query, args := pageQuery(`SELECT `+messageColumns+` FROM private_messages
+WHERE ((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?))`, []interface{}{userA, userB, userB, userA}, q)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return MessagePage{}, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []PrivateMessage
+	for rows.Next() {
+		msg, err := scanMessage(s.db, rows)
+		if err != nil {
+			return MessagePage{}, fmt.Errorf("failed to scan row: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return MessagePage{}, fmt.Errorf("failed after iterating rows: %w", err)
+	}
+	return newMessagePage(q, messages), nil
+}
+
+// pageQuery adds the conditions, order and limit for q to query, which must
+// select messages with a WHERE clause, and returns it with its arguments.
+// One message more than q.Limit is asked for, see newMessagePage.
+func pageQuery(query string, args []interface{}, q MessageQuery) (string, []interface{}) {
+	if !q.Before.IsZero() {
+		query += " AND (created_at, id) < (?, ?)"
+		args = append(args, q.Before.Time.UTC().Format(sqliteTime), q.Before.ID)
+	}
+	if !q.After.IsZero() {
+		query += " AND (created_at, id) > (?, ?)"
+		args = append(args, q.After.Time.UTC().Format(sqliteTime), q.After.ID)
+	}
+	if q.After.IsZero() {
+		query += " ORDER BY created_at DESC, id DESC"
+	} else {
+		query += " ORDER BY created_at, id"
+	}
+	return query + " LIMIT ?;", append(args, q.Limit+1)
+}
+
+func (s *sqliteMessages) MarkRead(readerID int, senderID int, upTo int) (int, error) {
+	if upTo == 0 {
+		upTo = math.MaxInt64
+	}
+	rows, err := s.db.Query(`UPDATE private_messages SET read_at = CURRENT_TIMESTAMP, delivered_at = COALESCE(delivered_at, CURRENT_TIMESTAMP)
+WHERE receiver_id = ? AND sender_id = ? AND id <= ? AND read_at IS NULL
+RETURNING id;`, readerID, senderID, upTo)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark messages read: %w", err)
+	}
+	defer rows.Close()
+
+	newest := 0
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if id > newest {
+			newest = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to mark messages read: %w", err)
+	}
+	return newest, nil
+}
+
+func (s *sqliteMessages) Pending(receiverID int, limit int) ([]PrivateMessage, error) {
+	rows, err := s.db.Query(`SELECT `+messageColumns+` FROM private_messages
+WHERE receiver_id = ? AND delivered_at IS NULL AND deleted_at IS NULL
+ORDER BY id LIMIT ?;`, receiverID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []PrivateMessage
+	for rows.Next() {
+		msg, err := scanMessage(s.db, rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed after iterating rows: %w", err)
+	}
+	return messages, nil
+}
+
+func (s *sqliteMessages) MarkDelivered(receiverID int, id int) (int, error) {
+	var senderID int
+	err := s.db.QueryRow(`UPDATE private_messages SET delivered_at = CURRENT_TIMESTAMP
+WHERE id = ? AND receiver_id = ? AND delivered_at IS NULL
+RETURNING sender_id;`, id, receiverID).Scan(&senderID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to mark message delivered: %w", err)
+	}
+	return senderID, nil
+}
+
+func (s *sqliteMessages) ByID(id int) (PrivateMessage, error) {
+	msg, err := scanMessage(s.db, s.db.QueryRow("SELECT "+rawMessageColumns+" FROM private_messages WHERE id = ?;", id))
+	if err == sql.ErrNoRows {
+		return PrivateMessage{}, ErrNotFound
+	} else if err != nil {
+		return PrivateMessage{}, fmt.Errorf("failed to get message: %w", err)
+	}
+	return msg, nil
+}
+
+func (s *sqliteMessages) Edit(id int, content string) (PrivateMessage, error) {
+	contentHTML := renderSQLite(s.db, content)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return PrivateMessage{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO private_message_revisions (message_id, content)
+SELECT id, content FROM private_messages WHERE id = ? AND deleted_at IS NULL;`, id)
+	if err != nil {
+		return PrivateMessage{}, fmt.Errorf("failed to keep revision: %w", err)
+	}
+	msg, err := scanMessage(s.db, tx.QueryRow(`UPDATE private_messages SET content = ?, content_html = ?, edited_at = CURRENT_TIMESTAMP
+WHERE id = ? AND deleted_at IS NULL RETURNING `+messageColumns+`;`, content, contentHTML, id))
+	if err == sql.ErrNoRows {
+		return PrivateMessage{}, ErrNotFound
+	} else if err != nil {
+		return PrivateMessage{}, fmt.Errorf("failed to edit message: %w", err)
+	}
+	return msg, tx.Commit()
+}
+
+func (s *sqliteMessages) Delete(id int) (PrivateMessage, error) {
+	msg, err := scanMessage(s.db, s.db.QueryRow(`UPDATE private_messages SET deleted_at = CURRENT_TIMESTAMP
+WHERE id = ? AND deleted_at IS NULL RETURNING `+messageColumns+`;`, id))
+	if err == sql.ErrNoRows {
+		return PrivateMessage{}, ErrNotFound
+	} else if err != nil {
+		return PrivateMessage{}, fmt.Errorf("failed to delete message: %w", err)
+	}
+	return msg, nil
+}
+
+func (s *sqliteMessages) Revisions(id int) ([]MessageRevision, error) {
+	rows, err := s.db.Query("SELECT content, replaced_at FROM private_message_revisions WHERE message_id = ? ORDER BY id;", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := []MessageRevision{}
+	for rows.Next() {
+		var revision MessageRevision
+		var replacedAt time.Time
+		if err := rows.Scan(&revision.Content, &replacedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		revision.ReplacedAt = replacedAt.Format(time.RFC3339)
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed after iterating rows: %w", err)
+	}
+	return revisions, nil
+}
+
+func (s *sqliteMessages) HaveChatted(userA int, userB int) (bool, error) {
+	var chatted bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM private_messages
+WHERE (sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?));`, userA, userB, userB, userA).Scan(&chatted)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up messages: %w", err)
+	}
+	return chatted, nil
+}
+
+func setTime(msg *PrivateMessage, createdAt time.Time) {
+	msg.Timestamp = createdAt.Format(time.RFC3339)
+	msg.Cursor = Cursor{Time: createdAt, ID: msg.ID}.String()
+}
@@ -0,0 +1,256 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type sqlitePosts struct {
+	db *sql.DB
+}
+
+const postColumns = "posts.id, posts.title, posts.content, posts.content_html, posts.created_at, posts.edited_at, users.username"
+
+func scanPost(db *sql.DB, scanner interface{ Scan(...interface{}) error }) (Post, error) {
+	var post Post
+	var html sql.NullString
+	var editedAt sql.NullTime
+	err := scanner.Scan(&post.ID, &post.Title, &post.Content, &html, &post.CreatedAt, &editedAt, &post.Username)
+	if err != nil {
+		return Post{}, err
+	}
+	post.ContentHTML = storedHTML(db, html, post.Content)
+	post.EditedAt = nullTimePtr(editedAt)
+	return post, nil
+}
+
+// nullTimePtr returns nil for NULL.
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (s *sqlitePosts) scanPosts(rows *sql.Rows, err error) ([]Post, error) {
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		post, err := scanPost(s.db, rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed after iterating rows: %w", err)
+	}
+	return posts, nil
+}
+
+func (s *sqlitePosts) Create(userID int, title string, content string, categories []int) (int, error) {
+	contentHTML := renderSQLite(s.db, content)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO posts(title, content, content_html, user_id) VALUES (?, ?, ?, ?)", title, content, contentHTML, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert post: %w", err)
+	}
+	postID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, categoryID := range categories {
+		if categoryID == 0 {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO post_categories (post_id, category_id) VALUES (?, ?);", postID, categoryID); err != nil {
+			return 0, fmt.Errorf("failed to insert post category: %w", err)
+		}
+	}
+	return int(postID), tx.Commit()
+}
+
+func (s *sqlitePosts) ByID(postID int) (Post, error) {
+	post, err := scanPost(s.db, s.db.QueryRow("SELECT "+postColumns+" FROM posts JOIN users ON posts.user_id = users.id WHERE posts.id = ?;", postID))
+	if err == sql.ErrNoRows {
+		return Post{}, ErrNotFound
+	} else if err != nil {
+		return Post{}, fmt.Errorf("failed to get post: %w", err)
+	}
+	return post, nil
+}
+
+func (s *sqlitePosts) All() ([]Post, error) {
+	return s.scanPosts(s.db.Query("SELECT " + postColumns + " FROM posts JOIN users ON posts.user_id = users.id ORDER BY posts.id;"))
+}
+
+func (s *sqlitePosts) ByAuthor(username string) ([]Post, error) {
+	return s.scanPosts(s.db.Query("SELECT "+postColumns+" FROM posts JOIN users ON posts.user_id = users.id WHERE users.username = ? ORDER BY posts.id;", username))
+}
+
+func (s *sqlitePosts) LikedBy(username string) ([]Post, error) {
+	return s.scanPosts(s.db.Query(`SELECT `+postColumns+`
+FROM post_votes
+JOIN users AS likers ON post_votes.user_id = likers.id
+JOIN posts ON post_votes.post_id = posts.id
+JOIN users ON posts.user_id = users.id
+WHERE likers.username = ? AND post_votes.vote_type = 'like'
+ORDER BY posts.id;`, username))
+}
+
+func (s *sqlitePosts) ByCategories(categories []int) ([]Post, error) {
+	var placeholders []string
+	var args []any
+	for _, categoryID := range categories {
+		if categoryID != 0 {
+			placeholders = append(placeholders, "?")
+			args = append(args, categoryID)
+		}
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return s.scanPosts(s.db.Query(`SELECT DISTINCT `+postColumns+`
+FROM posts
+JOIN post_categories ON posts.id = post_categories.post_id
+JOIN users ON posts.user_id = users.id
+WHERE post_categories.category_id IN (`+strings.Join(placeholders, ", ")+`)
+ORDER BY posts.id;`, args...))
+}
+
+func (s *sqlitePosts) Flagged() ([]Post, error) {
+	return s.scanPosts(s.db.Query("SELECT " + postColumns + " FROM posts JOIN users ON posts.user_id = users.id WHERE flagged = 1 ORDER BY posts.id;"))
+}
+
+func (s *sqlitePosts) CountFlagged() (count int, err error) {
+	err = s.db.QueryRow("SELECT COUNT(*) FROM posts WHERE flagged = 1;").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count reported posts: %w", err)
+	}
+	return count, nil
+}
+
+func (s *sqlitePosts) Flag(postID int) error {
+	return s.execOne("UPDATE posts SET flagged = 1 WHERE id = ?;", postID)
+}
+
+// postChildren are the statements that delete what belongs to a post, in
+// the order Delete runs them.
+var postChildren = []struct{ what, query string }{
+	{"revisions", "DELETE FROM post_revisions WHERE post_id = ?;"},
+	{"comment revisions", "DELETE FROM comment_revisions WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?);"},
+	{"comment votes", "DELETE FROM comment_votes WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?);"},
+	{"comments", "DELETE FROM comments WHERE post_id = ?;"},
+	{"votes", "DELETE FROM post_votes WHERE post_id = ?;"},
+	{"categories", "DELETE FROM post_categories WHERE post_id = ?;"},
+}
+
+// Delete removes the post with its revisions, comments, votes and category
+// links. Foreign keys are not enforced, so ON DELETE CASCADE does not do that
+// for us.
+func (s *sqlitePosts) Delete(postID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, child := range postChildren {
+		if _, err := tx.Exec(child.query, postID); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", child.what, err)
+		}
+	}
+	res, err := tx.Exec("DELETE FROM posts WHERE id = ?;", postID)
+	if err != nil {
+		return fmt.Errorf("execution failed: %w", err)
+	}
+	if count, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	} else if count == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (s *sqlitePosts) Edit(postID int, editorID int, title string, content string) error {
+	contentHTML := renderSQLite(s.db, content)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO post_revisions (post_id, title, content, editor_id)
+SELECT id, title, content, ? FROM posts WHERE id = ?;`, editorID, postID)
+	if err != nil {
+		return fmt.Errorf("failed to keep revision: %w", err)
+	}
+	if count, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	} else if count == 0 {
+		return ErrNotFound
+	}
+	_, err = tx.Exec("UPDATE posts SET title = ?, content = ?, content_html = ?, edited_at = CURRENT_TIMESTAMP WHERE id = ?;", title, content, contentHTML, postID)
+	if err != nil {
+		return fmt.Errorf("failed to edit post: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (s *sqlitePosts) Revisions(postID int) ([]Revision, error) {
+	return scanRevisions(s.db.Query(`SELECT post_revisions.title, post_revisions.content, users.username, post_revisions.replaced_at
+FROM post_revisions
+JOIN users ON post_revisions.editor_id = users.id
+WHERE post_revisions.post_id = ?
+ORDER BY post_revisions.id;`, postID))
+}
+
+// scanRevisions reads the title, content, editor and replacement time of
+// revisions.
+func scanRevisions(rows *sql.Rows, err error) ([]Revision, error) {
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		var revision Revision
+		var replacedAt time.Time
+		if err := rows.Scan(&revision.Title, &revision.Content, &revision.Editor, &replacedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		revision.ReplacedAt = replacedAt.Format(time.RFC3339)
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed after iterating rows: %w", err)
+	}
+	return revisions, nil
+}
+
+func (s *sqlitePosts) execOne(query string, postID int) error {
+	res, err := s.db.Exec(query, postID)
+	if err != nil {
+		return fmt.Errorf("execution failed: %w", err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	} else if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
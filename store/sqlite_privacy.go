@@ -0,0 +1,95 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type sqlitePrivacy struct {
+	db *sql.DB
+}
+
+func (s *sqlitePrivacy) Block(blockerID int, blockedID int) error {
+	_, err := s.db.Exec("INSERT INTO blocks (blocker_id, blocked_id) VALUES (?, ?);", blockerID, blockedID)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrConflict
+	} else if err != nil {
+		return fmt.Errorf("failed to block user: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlitePrivacy) Unblock(blockerID int, blockedID int) error {
+	res, err := s.db.Exec("DELETE FROM blocks WHERE blocker_id = ? AND blocked_id = ?;", blockerID, blockedID)
+	if err != nil {
+		return fmt.Errorf("failed to unblock user: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlitePrivacy) Blocked(blockerID int) ([]string, error) {
+	return s.usernames(s.db.Query(`SELECT u.username FROM blocks b
+JOIN users u ON u.id = b.blocked_id
+WHERE b.blocker_id = ? ORDER BY LOWER(u.username);`, blockerID))
+}
+
+func (s *sqlitePrivacy) BlockedBy(blockedID int) ([]string, error) {
+	return s.usernames(s.db.Query(`SELECT u.username FROM blocks b
+JOIN users u ON u.id = b.blocker_id
+WHERE b.blocked_id = ? ORDER BY LOWER(u.username);`, blockedID))
+}
+
+// usernames reads the single username column of rows.
+func (s *sqlitePrivacy) usernames(rows *sql.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	usernames := []string{}
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		usernames = append(usernames, username)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed after iterating rows: %w", err)
+	}
+	return usernames, nil
+}
+
+func (s *sqlitePrivacy) IsBlocked(blockerID int, blockedID int) (bool, error) {
+	var blocked bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM blocks WHERE blocker_id = ? AND blocked_id = ?);", blockerID, blockedID).Scan(&blocked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check block: %w", err)
+	}
+	return blocked, nil
+}
+
+func (s *sqlitePrivacy) Setting(userID int) (string, error) {
+	var setting string
+	err := s.db.QueryRow("SELECT chat_privacy FROM users WHERE id = ?;", userID).Scan(&setting)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get privacy setting: %w", err)
+	}
+	return setting, nil
+}
+
+func (s *sqlitePrivacy) SetSetting(userID int, setting string) error {
+	_, err := s.db.Exec("UPDATE users SET chat_privacy = ? WHERE id = ?;", setting, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set privacy setting: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type sqliteRoles struct {
+	db *sql.DB
+}
+
+func (s *sqliteRoles) Rank(role string) (rank int, err error) {
+	err = s.db.QueryRow("SELECT rank FROM roles WHERE name = ?;", role).Scan(&rank)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to get role rank: %w", err)
+	}
+	return rank, nil
+}
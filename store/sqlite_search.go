@@ -0,0 +1,114 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type sqliteSearch struct {
+	db *sql.DB
+}
+
+// ftsQuery turns the terms of a search into an FTS5 query. Every term is
+// quoted so that nothing users type is taken for query syntax.
+func ftsQuery(terms []searchTerm) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term.text, `"`, `""`) + `"`
+		if term.prefix {
+			quoted[i] += "*"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// searchSnippet picks the snippet from whichever column matches best, the
+// title or the content of posts.
+func searchSnippet(table string) string {
+	return "snippet(" + table + ", -1, char(2), char(3), '…', 16)"
+}
+
+func (s *sqliteSearch) Search(q SearchQuery) (SearchPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = 20
+	}
+	terms := searchTerms(q.Text)
+	if len(terms) == 0 {
+		return newSearchPage(q, nil), nil
+	}
+	match := ftsQuery(terms)
+
+	var parts []string
+	var args []interface{}
+	// filter adds the author and category conditions to a part, given its
+	// columns with the author and the post ID.
+	filter := func(query string, author string, post string) string {
+		if q.Author != "" {
+			query += " AND " + author + " = ?"
+			args = append(args, q.Author)
+		}
+		if q.Category != 0 {
+			query += " AND " + post + " IN (SELECT post_id FROM post_categories WHERE category_id = ?)"
+			args = append(args, q.Category)
+		}
+		return query
+	}
+	if q.wants(SearchPost) {
+		args = append(args, match)
+		parts = append(parts, filter(`SELECT 'post' AS kind, p.id, p.id AS post_id, u.username AS author, '' AS with_name,
+    `+searchSnippet("posts_search")+` AS snippet, p.created_at, bm25(posts_search) AS rank
+FROM posts_search
+JOIN posts p ON p.id = posts_search.rowid
+JOIN users u ON u.id = p.user_id
+WHERE posts_search MATCH ?`, "u.username", "p.id"))
+	}
+	if q.wants(SearchComment) {
+		args = append(args, match)
+		parts = append(parts, filter(`SELECT 'comment' AS kind, c.id, c.post_id, u.username AS author, '' AS with_name,
+    `+searchSnippet("comments_search")+` AS snippet, c.created_at, bm25(comments_search) AS rank
+FROM comments_search
+JOIN comments c ON c.id = comments_search.rowid
+JOIN posts p ON p.id = c.post_id
+JOIN users u ON u.id = c.user_id
+WHERE comments_search MATCH ?`, "u.username", "c.post_id"))
+	}
+	if q.wants(SearchMessage) {
+		args = append(args, q.UserID, match, q.UserID, q.UserID)
+		parts = append(parts, filter(`SELECT 'message' AS kind, m.id, 0 AS post_id, sender.username AS author,
+    CASE WHEN m.sender_id = ? THEN receiver.username ELSE sender.username END AS with_name,
+    `+searchSnippet("private_messages_search")+` AS snippet, m.created_at, bm25(private_messages_search) AS rank
+FROM private_messages_search
+JOIN private_messages m ON m.id = private_messages_search.rowid
+JOIN users sender ON sender.id = m.sender_id
+JOIN users receiver ON receiver.id = m.receiver_id
+WHERE private_messages_search MATCH ? AND m.deleted_at IS NULL AND (m.sender_id = ? OR m.receiver_id = ?)`, "sender.username", ""))
+	}
+	if len(parts) == 0 {
+		return newSearchPage(q, nil), nil
+	}
+
+	// The parts lose the column types, so times are formatted in SQL.
+	query := `SELECT kind, id, post_id, author, with_name, snippet, strftime('%Y-%m-%dT%H:%M:%SZ', created_at)
+FROM (` + strings.Join(parts, "\nUNION ALL\n") + `)
+ORDER BY rank, created_at DESC, id DESC LIMIT ? OFFSET ?;`
+	rows, err := s.db.Query(query, append(args, q.Limit+1, q.Offset)...)
+	if err != nil {
+		return SearchPage{}, fmt.Errorf("failed to search: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.Kind, &r.ID, &r.PostID, &r.Author, &r.With, &r.Snippet, &r.CreatedAt); err != nil {
+			return SearchPage{}, fmt.Errorf("failed to scan row: %w", err)
+		}
+		r.Snippet = snippetHTML(r.Snippet)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return SearchPage{}, fmt.Errorf("failed after iterating rows: %w", err)
+	}
+	return newSearchPage(q, results), nil
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type sqliteSessions struct {
+	db *sql.DB
+}
+
+func (s *sqliteSessions) Create(session Session) error {
+	_, err := s.db.Exec("INSERT INTO sessions (token, username, expires_at) VALUES (?, ?, ?);", session.Token, session.Username, session.Expiry.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteSessions) Get(token string) (Session, error) {
+	session := Session{Token: token}
+	var expiresAt int64
+	err := s.db.QueryRow("SELECT username, expires_at FROM sessions WHERE token = ?;", token).Scan(&session.Username, &expiresAt)
+	if err == sql.ErrNoRows {
+		return Session{}, ErrNotFound
+	} else if err != nil {
+		return Session{}, fmt.Errorf("failed to get session: %w", err)
+	}
+	session.Expiry = time.Unix(expiresAt, 0)
+	return session, nil
+}
+
+func (s *sqliteSessions) SetExpiry(token string, expiry time.Time) error {
+	_, err := s.db.Exec("UPDATE sessions SET expires_at = ? WHERE token = ?;", expiry.Unix(), token)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteSessions) Delete(token string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE token = ?;", token)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteSessions) DeleteForUser(username string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE username = ?;", username)
+	if err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteSessions) DeleteExpired(now time.Time) (int, error) {
+	res, err := s.db.Exec("DELETE FROM sessions WHERE expires_at < ?;", now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
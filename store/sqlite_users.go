@@ -0,0 +1,144 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type sqliteUsers struct {
+	db *sql.DB
+}
+
+const userColumns = "id, username, password, email, COALESCE(role, 'user'), appliesformoderator, COALESCE(first_name, ''), COALESCE(last_name, ''), COALESCE(gender, ''), COALESCE(age, 0)"
+
+func scanUser(row *sql.Row) (User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Email, &user.Role, &user.AppliesForModerator, &user.FirstName, &user.LastName, &user.Gender, &user.Age)
+	if err == sql.ErrNoRows {
+		return User{}, ErrNotFound
+	} else if err != nil {
+		return User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+func (s *sqliteUsers) Create(user User) (int, error) {
+	return insertUser(s.db, user)
+}
+
+// insertUser inserts user with db, which is a database or a transaction.
+func insertUser(db interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, user User) (int, error) {
+	res, err := db.Exec("INSERT INTO users(username, password, email, role, appliesformoderator, first_name, last_name, gender, age) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		user.Username, user.Password, user.Email, user.Role, user.AppliesForModerator, user.FirstName, user.LastName, user.Gender, user.Age)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert user: %w", err)
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func (s *sqliteUsers) ByID(id int) (User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?;", id))
+}
+
+func (s *sqliteUsers) ByUsername(username string) (User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?;", username))
+}
+
+func (s *sqliteUsers) ByLogin(usernameOrEmail string) (User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ? OR email = ?;", usernameOrEmail, usernameOrEmail))
+}
+
+func (s *sqliteUsers) Userlist(userID int) ([]Userlist, error) {
+	rows, err := s.db.Query(`SELECT 
+    u.id,
+    u.username,
+    COUNT(CASE WHEN pm.sender_id = u.id AND pm.receiver_id = ? AND pm.read_at IS NULL AND pm.deleted_at IS NULL THEN 1 END),
+    u.last_seen_at
+FROM 
+    users u
+LEFT JOIN 
+    private_messages pm ON (u.id = pm.sender_id OR u.id = pm.receiver_id) AND (pm.sender_id = ? OR pm.receiver_id = ?)
+WHERE 
+    u.id NOT IN (SELECT blocked_id FROM blocks WHERE blocker_id = ?)
+GROUP BY 
+    u.id
+ORDER BY 
+    CASE WHEN MAX(pm.created_at) IS NULL THEN 1 ELSE 0 END, 
+    MAX(pm.created_at) DESC NULLS LAST,  
+    LOWER(u.username) ASC;`, userID, userID, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var users []Userlist
+	for rows.Next() {
+		var user Userlist
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&user.ID, &user.Username, &user.Unread, &lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if lastSeen.Valid {
+			user.LastSeen = lastSeen.Time.Format(time.RFC3339)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed after iterating rows: %w", err)
+	}
+	return users, nil
+}
+
+func (s *sqliteUsers) SetLastSeen(userID int, at time.Time) error {
+	_, err := s.db.Exec("UPDATE users SET last_seen_at = ? WHERE id = ?;", at.UTC().Format(sqliteTime), userID)
+	if err != nil {
+		return fmt.Errorf("failed to set last seen: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteUsers) SetRole(username string, role string) error {
+	_, err := s.db.Exec("UPDATE users SET role = ? WHERE username = ?;", role, username)
+	if err != nil {
+		return fmt.Errorf("failed to set role: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteUsers) ClearModeratorApplication(username string) error {
+	_, err := s.db.Exec("UPDATE users SET appliesformoderator = 0 WHERE username = ?;", username)
+	if err != nil {
+		return fmt.Errorf("failed to clear moderator application: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteUsers) ModeratorApplicants() ([]string, error) {
+	return s.usernames("SELECT username FROM users WHERE appliesformoderator = 1;")
+}
+
+func (s *sqliteUsers) Moderators() ([]string, error) {
+	return s.usernames("SELECT username FROM users WHERE role = 'moderator';")
+}
+
+func (s *sqliteUsers) usernames(query string, args ...any) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query database: %w", err)
+	}
+	defer rows.Close()
+
+	var usernames []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		usernames = append(usernames, username)
+	}
+	return usernames, rows.Err()
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type sqliteVotes struct {
+	db *sql.DB
+}
+
+func voteTable(comment bool) (table string, idField string) {
+	if comment {
+		return "comment_votes", "comment_id"
+	}
+	return "post_votes", "post_id"
+}
+
+func (s *sqliteVotes) Vote(targetID int, userID int, voteType string, comment bool) error {
+	table, idField := voteTable(comment)
+	query := fmt.Sprintf(`INSERT INTO %s(%s, user_id, vote_type) VALUES(?, ?, ?) ON CONFLICT(%s, user_id) DO UPDATE SET vote_type = excluded.vote_type`, table, idField, idField)
+	if _, err := s.db.Exec(query, targetID, userID, voteType); err != nil {
+		return fmt.Errorf("failed to store vote: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteVotes) Count(targetID int, voteType string, comment bool) (count int, err error) {
+	table, idField := voteTable(comment)
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s = ? AND vote_type = ?;`, table, idField)
+	if err := s.db.QueryRow(query, targetID, voteType).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to get vote count: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,400 @@
+// Package store is the data access layer of the forum. Handlers only talk to
+// the interfaces below, which have a SQLite implementation used in production
+// and an in-memory one that needs no database file.
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a row would violate a uniqueness rule.
+	ErrConflict = errors.New("already exists")
+)
+
+type User struct {
+	ID                  int
+	Username            string
+	Password            string
+	Email               string
+	Role                string
+	AppliesForModerator bool
+	FirstName           string
+	LastName            string
+	Gender              string
+	Age                 int
+}
+
+type Post struct {
+	ID      int
+	Title   string
+	Content string
+	// ContentHTML is Content rendered from Markdown, safe to put into pages.
+	ContentHTML  string
+	Username     string
+	Likes        int
+	Dislikes     int
+	CreatedAt    time.Time
+	EditedAt     *time.Time
+	Comments     []Comment
+	PostedAgo    string
+	CommentCount int
+}
+
+type Comment struct {
+	ID     int
+	PostID int
+	// ParentID is the comment this one replies to, 0 for comments on the
+	// post itself.
+	ParentID int
+	Username string
+	Content  string
+	// ContentHTML is Content rendered from Markdown, empty for deleted
+	// comments.
+	ContentHTML string
+	Likes       int
+	Dislikes    int
+	CreatedAt   time.Time
+	EditedAt    *time.Time
+	PostedAgo   string
+	// Deleted comments are placeholders kept for their replies, with
+	// DeletedComment as content and no author.
+	Deleted bool
+	// Depth, ReplyCount and Replies are filled in when comments are put
+	// together into threads. Depth is 0 for comments on the post, and
+	// ReplyCount counts the replies at every depth below, placeholders not
+	// included.
+	Depth      int
+	ReplyCount int
+	Replies    []Comment
+}
+
+// DeletedComment is the content of deleted comments that have replies.
+const DeletedComment = "[deleted]"
+
+type PrivateMessage struct {
+	ID       int    `json:"id"`
+	Cursor   string `json:"cursor"`
+	Sender   string `json:"sender"`
+	Receiver string `json:"receiver"`
+	// SenderID and ReceiverID are Sender and Receiver as numbers, for the
+	// server; the JSON keeps the strings the frontend expects.
+	SenderID   int    `json:"-"`
+	ReceiverID int    `json:"-"`
+	Content    string `json:"content"`
+	// ContentHTML is Content rendered from Markdown.
+	ContentHTML string `json:"contentHtml"`
+	// Status is MessagePending, MessageDelivered or MessageRead for private
+	// messages.
+	Status      string `json:"status"`
+	Timestamp   string `json:"timestamp"`
+	DeliveredAt string `json:"deliveredAt,omitempty"`
+	ReadAt      string `json:"readAt,omitempty"`
+	EditedAt    string `json:"editedAt,omitempty"`
+	// DeletedAt is set for deleted messages, which are tombstones without
+	// content.
+	DeletedAt string `json:"deletedAt,omitempty"`
+	// Conversation and SenderName are set, and Receiver is empty, for
+	// messages of groups and channels.
+	Conversation int         `json:"conversation,omitempty"`
+	SenderName   string      `json:"senderName,omitempty"`
+	Attachment   *Attachment `json:"attachment,omitempty"`
+}
+
+// Attachment is a file uploaded to the chat. Its content is kept in the
+// attachment storage under StorageKey, and a thumbnail of images under
+// ThumbnailKey.
+type Attachment struct {
+	ID           int    `json:"id"`
+	UploaderID   int    `json:"-"`
+	Name         string `json:"name"`
+	ContentType  string `json:"contentType"`
+	Size         int64  `json:"size"`
+	StorageKey   string `json:"-"`
+	ThumbnailKey string `json:"-"`
+	// Thumbnail tells clients whether there is a thumbnail.
+	Thumbnail bool `json:"thumbnail"`
+}
+
+// MessageRevision is content an edit replaced.
+type MessageRevision struct {
+	Content    string `json:"content"`
+	ReplacedAt string `json:"replacedAt"`
+}
+
+// Revision is a version of a post or comment that an edit replaced, and
+// the user who made the edit. Comments have no title.
+type Revision struct {
+	Title      string `json:"title,omitempty"`
+	Content    string `json:"content"`
+	Editor     string `json:"editor"`
+	ReplacedAt string `json:"replacedAt"`
+}
+
+type Session struct {
+	Token    string
+	Username string
+	Expiry   time.Time
+}
+
+func (s Session) IsExpired() bool {
+	return s.Expiry.Before(time.Now())
+}
+
+// Kinds of conversations and roles of their members.
+const (
+	ConversationGroup   = "group"
+	ConversationChannel = "channel"
+	MemberOwner         = "owner"
+	MemberRole          = "member"
+)
+
+// Conversation is a chat with any number of members: a group people create,
+// or the public channel of a category. Role, Unread and LastMessageAt are
+// as seen by the user the conversation was read for; Role is empty when
+// they are not a member.
+type Conversation struct {
+	ID            int    `json:"id"`
+	Kind          string `json:"kind"`
+	Name          string `json:"name"`
+	CategoryID    int    `json:"categoryId,omitempty"`
+	Role          string `json:"role,omitempty"`
+	Unread        int    `json:"unread"`
+	LastMessageAt string `json:"lastMessageAt,omitempty"`
+}
+
+// Identity is an account at an OAuth provider linked to a user. Subject is
+// the provider's stable ID of the account.
+type Identity struct {
+	Provider  string
+	Subject   string
+	UserID    int
+	Email     string
+	CreatedAt time.Time
+}
+
+type Userlist struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	// Unread counts the messages from this user that were not read yet.
+	Unread int `json:"unread"`
+	// LastSeen is when the user was last connected to the chat, if ever.
+	LastSeen string `json:"lastSeen,omitempty"`
+}
+
+type UserStore interface {
+	// Create inserts a new user and returns its ID.
+	Create(user User) (int, error)
+	ByID(id int) (User, error)
+	ByUsername(username string) (User, error)
+	// ByLogin finds a user by username or email.
+	ByLogin(usernameOrEmail string) (User, error)
+	// Userlist returns all users but those userID blocked, the ones userID
+	// last chatted with first, with the number of messages userID has not
+	// read from each.
+	Userlist(userID int) ([]Userlist, error)
+	SetRole(username string, role string) error
+	// SetLastSeen records when a user was last connected to the chat.
+	SetLastSeen(userID int, at time.Time) error
+	// ClearModeratorApplication marks the moderator application of username
+	// as answered.
+	ClearModeratorApplication(username string) error
+	ModeratorApplicants() ([]string, error)
+	Moderators() ([]string, error)
+}
+
+type PostStore interface {
+	// Create inserts a post with its categories and returns the new post ID.
+	Create(userID int, title string, content string, categories []int) (int, error)
+	ByID(postID int) (Post, error)
+	All() ([]Post, error)
+	ByAuthor(username string) ([]Post, error)
+	LikedBy(username string) ([]Post, error)
+	ByCategories(categories []int) ([]Post, error)
+	Flagged() ([]Post, error)
+	CountFlagged() (int, error)
+	Flag(postID int) error
+	Delete(postID int) error
+	// Edit replaces the title and content of a post, keeping the old ones
+	// as a revision by editorID.
+	Edit(postID int, editorID int, title string, content string) error
+	// Revisions returns the versions edits replaced, oldest first.
+	Revisions(postID int) ([]Revision, error)
+}
+
+type CommentStore interface {
+	// Create stores a comment on a post, or a reply to the comment parentID
+	// unless it is 0, and returns the new comment ID.
+	Create(postID int, parentID int, userID int, content string) (int, error)
+	// ByID returns a comment, deleted ones included.
+	ByID(id int) (Comment, error)
+	// All returns the comments of every post, oldest first.
+	All() ([]Comment, error)
+	// CountForPost counts the comments of a post but the placeholders.
+	CountForPost(postID int) (int, error)
+	// Delete removes a comment, or turns it into a placeholder if it has
+	// replies. A placeholder losing its last reply is removed too. Deleting
+	// a placeholder again returns ErrNotFound.
+	Delete(id int) error
+	// Edit replaces the content of a comment, keeping the old one as a
+	// revision by editorID. Placeholders cannot be edited.
+	Edit(id int, editorID int, content string) error
+	// Revisions returns the versions edits replaced, oldest first.
+	Revisions(id int) ([]Revision, error)
+}
+
+type VoteStore interface {
+	// Vote stores the vote of userID on a post, or on a comment when comment
+	// is true, replacing any earlier vote of that user.
+	Vote(targetID int, userID int, voteType string, comment bool) error
+	Count(targetID int, voteType string, comment bool) (int, error)
+}
+
+// How far a private message got.
+const (
+	MessagePending   = "pending"
+	MessageDelivered = "delivered"
+	MessageRead      = "read"
+)
+
+type MessageStore interface {
+	// Create stores a message, with the attachment attachmentID unless it
+	// is 0, and returns it with its ID and timestamp.
+	Create(senderID int, receiverID int, content string, attachmentID int) (PrivateMessage, error)
+	// Conversation returns a page of the messages between two users.
+	Conversation(userA int, userB int, q MessageQuery) (MessagePage, error)
+	// MarkRead marks the messages senderID sent to readerID up to the
+	// message upTo as read. It returns the ID of the newest message it
+	// marked, or 0 when there was nothing left to mark. Read messages are
+	// delivered too.
+	MarkRead(readerID int, senderID int, upTo int) (int, error)
+	// Pending returns up to limit messages to receiverID that were not
+	// delivered yet and are not deleted, oldest first.
+	Pending(receiverID int, limit int) ([]PrivateMessage, error)
+	// MarkDelivered marks the message id to receiverID as delivered and
+	// returns its sender, or 0 when it was delivered already.
+	MarkDelivered(receiverID int, id int) (int, error)
+	// ByID returns a message with its content, even when it was deleted.
+	ByID(id int) (PrivateMessage, error)
+	// Edit replaces the content of a message that is not deleted, keeping
+	// the old content as a revision, and returns the edited message.
+	Edit(id int, content string) (PrivateMessage, error)
+	// Delete turns a message into a tombstone and returns it. The content
+	// is kept for ByID.
+	Delete(id int) (PrivateMessage, error)
+	// Revisions returns the contents edits replaced, oldest first.
+	Revisions(id int) ([]MessageRevision, error)
+	// HaveChatted reports whether either user ever messaged the other.
+	HaveChatted(userA int, userB int) (bool, error)
+}
+
+type AttachmentStore interface {
+	// Create stores an uploaded attachment and returns its ID.
+	Create(attachment Attachment) (int, error)
+	ByID(id int) (Attachment, error)
+	// CanAccess reports whether userID uploaded the attachment or can read
+	// a message carrying it: as one of the two users of a private message,
+	// a member of the group or anyone for channels.
+	CanAccess(id int, userID int) (bool, error)
+}
+
+// Who may send private messages to a user.
+const (
+	PrivacyEveryone = "everyone"
+	PrivacyContacts = "contacts"
+	PrivacyNobody   = "nobody"
+)
+
+type PrivacyStore interface {
+	// Block returns ErrConflict when blockedID is blocked already.
+	Block(blockerID int, blockedID int) error
+	// Unblock returns ErrNotFound when blockedID is not blocked.
+	Unblock(blockerID int, blockedID int) error
+	// Blocked returns the usernames blockerID blocked.
+	Blocked(blockerID int) ([]string, error)
+	// BlockedBy returns the usernames of the users who blocked blockedID.
+	BlockedBy(blockedID int) ([]string, error)
+	IsBlocked(blockerID int, blockedID int) (bool, error)
+	// Setting returns who may message userID, one of the Privacy constants.
+	Setting(userID int) (string, error)
+	SetSetting(userID int, setting string) error
+}
+
+type SessionStore interface {
+	Create(session Session) error
+	Get(token string) (Session, error)
+	SetExpiry(token string, expiry time.Time) error
+	Delete(token string) error
+	// DeleteForUser removes every session of username.
+	DeleteForUser(username string) error
+	// DeleteExpired removes sessions that expired before now and returns how
+	// many were removed.
+	DeleteExpired(now time.Time) (int, error)
+}
+
+type RoleStore interface {
+	// Rank returns how privileged role is; higher ranks include everything
+	// lower ranks may do.
+	Rank(role string) (int, error)
+}
+
+type IdentityStore interface {
+	// Link stores identity. It returns ErrConflict when the provider account
+	// is linked already or the user has another account at that provider.
+	Link(identity Identity) error
+	// SignUp creates user and links identity to them, both or neither, and
+	// returns the ID of the user. It returns ErrConflict like Link.
+	SignUp(user User, identity Identity) (int, error)
+	Get(provider string, subject string) (Identity, error)
+	ForUser(userID int) ([]Identity, error)
+	Unlink(userID int, provider string) error
+}
+
+type ConversationStore interface {
+	// CreateGroup creates a group owned by ownerID with the other members
+	// and returns its ID.
+	CreateGroup(name string, ownerID int, memberIDs []int) (int, error)
+	// ByID returns a conversation as seen by userID.
+	ByID(id int, userID int) (Conversation, error)
+	// ForUser lists the groups of userID and every channel, the ones with
+	// the latest messages first.
+	ForUser(userID int) ([]Conversation, error)
+	// AddMember returns ErrConflict when userID is a member already.
+	AddMember(conversationID int, userID int, role string) error
+	// RemoveMember returns ErrNotFound when userID is not a member.
+	RemoveMember(conversationID int, userID int) error
+	// Members returns the usernames of the members.
+	Members(conversationID int) ([]string, error)
+	// Post stores a message like MessageStore.Create.
+	Post(conversationID int, senderID int, content string, attachmentID int) (PrivateMessage, error)
+	// Messages returns a page of the messages, like MessageStore.Conversation.
+	Messages(conversationID int, q MessageQuery) (MessagePage, error)
+	// MarkRead marks the messages up to upTo as read for the member
+	// userID, 0 meaning all of them.
+	MarkRead(conversationID int, userID int, upTo int) error
+}
+
+type SearchStore interface {
+	// Search returns a page of the posts, comments and private messages
+	// matching q.
+	Search(q SearchQuery) (SearchPage, error)
+}
+
+type Store struct {
+	Users      UserStore
+	Posts      PostStore
+	Comments   CommentStore
+	Votes      VoteStore
+	Messages   MessageStore
+	Sessions   SessionStore
+	Roles      RoleStore
+	Identities IdentityStore
+	// Conversations holds groups and channels; two person chats are in
+	// Messages.
+	Conversations ConversationStore
+	Privacy       PrivacyStore
+	Attachments   AttachmentStore
+	Search        SearchStore
+}
@@ -0,0 +1,915 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"forum/migrations"
+)
+
+// eachStore runs test against an empty memory store and a freshly migrated
+// SQLite database, which must behave the same.
+func eachStore(t *testing.T, test func(t *testing.T, st *Store)) {
+	t.Run("memory", func(t *testing.T) { test(t, NewMemory()) })
+	t.Run("sqlite", func(t *testing.T) { test(t, newTestSQLite(t)) })
+}
+
+func newTestSQLite(t *testing.T) *Store {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrations.Up(db); err != nil {
+		if strings.Contains(err.Error(), "FTS5") {
+			t.Skip("SQLite lacks FTS5, run the tests with -tags sqlite_fts5")
+		}
+		t.Fatal(err)
+	}
+	return NewSQLite(db)
+}
+
+// createUsers creates a user for each name and returns their IDs.
+func createUsers(t *testing.T, st *Store, names ...string) []int {
+	ids := make([]int, len(names))
+	for i, name := range names {
+		id, err := st.Users.Create(User{Username: name, Password: "hash", Email: name + "@example.com", Role: "user"})
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+func postIDs(posts []Post) []int {
+	ids := []int{}
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	return ids
+}
+
+func TestPosts(t *testing.T) {
+	eachStore(t, func(t *testing.T, st *Store) {
+		users := createUsers(t, st, "alice", "bob")
+		first, err := st.Posts.Create(users[0], "First", "Hello **world**", []int{1, 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := st.Posts.Create(users[1], "Second", "Reply", []int{2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		post, err := st.Posts.ByID(first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if post.Title != "First" || post.Content != "Hello **world**" || post.Username != "alice" {
+			t.Errorf("ByID = %+v", post)
+		}
+		if _, err := st.Posts.ByID(second + 1); err != ErrNotFound {
+			t.Errorf("ByID of a missing post: %v", err)
+		}
+
+		lists := []struct {
+			name  string
+			posts func() ([]Post, error)
+			want  []int
+		}{
+			{"All", st.Posts.All, []int{first, second}},
+			{"ByAuthor", func() ([]Post, error) { return st.Posts.ByAuthor("bob") }, []int{second}},
+			{"ByCategories", func() ([]Post, error) { return st.Posts.ByCategories([]int{1}) }, []int{first}},
+			{"ByCategories many", func() ([]Post, error) { return st.Posts.ByCategories([]int{1, 2}) }, []int{first, second}},
+			{"ByCategories none", func() ([]Post, error) { return st.Posts.ByCategories([]int{3}) }, []int{}},
+		}
+		for _, list := range lists {
+			posts, err := list.posts()
+			if err != nil {
+				t.Fatalf("%s: %v", list.name, err)
+			}
+			if got := postIDs(posts); fmt.Sprint(got) != fmt.Sprint(list.want) {
+				t.Errorf("%s = %v, want %v", list.name, got, list.want)
+			}
+		}
+
+		if err := st.Posts.Flag(second); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Posts.Flag(second + 1); err != ErrNotFound {
+			t.Errorf("Flag of a missing post: %v", err)
+		}
+		flagged, _ := st.Posts.Flagged()
+		if count, _ := st.Posts.CountFlagged(); count != 1 || fmt.Sprint(postIDs(flagged)) != fmt.Sprint([]int{second}) {
+			t.Errorf("flagged %v, count %d", postIDs(flagged), count)
+		}
+
+		if err := st.Votes.Vote(first, users[1], "like", false); err != nil {
+			t.Fatal(err)
+		}
+		liked, _ := st.Posts.LikedBy("bob")
+		if fmt.Sprint(postIDs(liked)) != fmt.Sprint([]int{first}) {
+			t.Errorf("LikedBy = %v", postIDs(liked))
+		}
+	})
+}
+
+func TestComments(t *testing.T) {
+	eachStore(t, func(t *testing.T, st *Store) {
+		users := createUsers(t, st, "alice", "bob")
+		postID, _ := st.Posts.Create(users[0], "Post", "Post", nil)
+		otherPost, _ := st.Posts.Create(users[0], "Other", "Other", nil)
+		first, err := st.Comments.Create(postID, 0, users[1], "first")
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, _ := st.Comments.Create(otherPost, 0, users[0], "second")
+
+		comment, err := st.Comments.ByID(first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if comment.PostID != postID || comment.Username != "bob" || comment.Content != "first" || comment.Deleted {
+			t.Errorf("ByID = %+v", comment)
+		}
+		if _, err := st.Comments.ByID(second + 1); err != ErrNotFound {
+			t.Errorf("ByID of a missing comment: %v", err)
+		}
+
+		comments, err := st.Comments.All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(comments) != 2 || comments[0].ID != first || comments[1].ID != second {
+			t.Errorf("All = %+v", comments)
+		}
+		if count, _ := st.Comments.CountForPost(postID); count != 1 {
+			t.Errorf("CountForPost = %d", count)
+		}
+	})
+}
+
+func TestPostEditAndDelete(t *testing.T) {
+	eachStore(t, func(t *testing.T, st *Store) {
+		users := createUsers(t, st, "alice", "mod")
+		id, err := st.Posts.Create(users[0], "Title", "Old", []int{1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, _ := st.Posts.Create(users[0], "Other", "Other", []int{1})
+		if err := st.Posts.Edit(id, users[1], "New title", "*New*"); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Posts.Edit(other+1, users[1], "x", "x"); err != ErrNotFound {
+			t.Errorf("Edit of a missing post: %v", err)
+		}
+
+		post, _ := st.Posts.ByID(id)
+		if post.Title != "New title" || post.ContentHTML != "<p><em>New</em></p>\n" || post.EditedAt == nil {
+			t.Errorf("edited post = %+v", post)
+		}
+		revisions, err := st.Posts.Revisions(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 1 || revisions[0].Title != "Title" || revisions[0].Content != "Old" || revisions[0].Editor != "mod" {
+			t.Errorf("Revisions = %+v", revisions)
+		}
+
+		parent, _ := st.Comments.Create(id, 0, users[1], "parent")
+		reply, _ := st.Comments.Create(id, parent, users[0], "reply")
+		kept, _ := st.Comments.Create(other, 0, users[0], "kept")
+		st.Comments.Edit(reply, users[0], "edited reply")
+		st.Votes.Vote(id, users[1], "like", false)
+		st.Votes.Vote(parent, users[0], "like", true)
+
+		if err := st.Posts.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Posts.Delete(id); err != ErrNotFound {
+			t.Errorf("second Delete: %v", err)
+		}
+		if _, err := st.Posts.ByID(id); err != ErrNotFound {
+			t.Errorf("ByID of a deleted post: %v", err)
+		}
+		if revisions, _ := st.Posts.Revisions(id); len(revisions) != 0 {
+			t.Errorf("revisions of a deleted post are left: %+v", revisions)
+		}
+		comments, _ := st.Comments.All()
+		if len(comments) != 1 || comments[0].ID != kept {
+			t.Errorf("comments left = %+v", comments)
+		}
+		if revisions, _ := st.Comments.Revisions(reply); len(revisions) != 0 {
+			t.Errorf("revisions of a deleted comment are left: %+v", revisions)
+		}
+		if count, _ := st.Votes.Count(id, "like", false); count != 0 {
+			t.Errorf("%d votes of the deleted post are left", count)
+		}
+		if count, _ := st.Votes.Count(parent, "like", true); count != 0 {
+			t.Errorf("%d votes of a deleted comment are left", count)
+		}
+		if posts, _ := st.Posts.ByCategories([]int{1}); fmt.Sprint(postIDs(posts)) != fmt.Sprint([]int{other}) {
+			t.Errorf("ByCategories = %v", postIDs(posts))
+		}
+	})
+}
+
+func TestMessages(t *testing.T) {
+	eachStore(t, func(t *testing.T, st *Store) {
+		users := createUsers(t, st, "alice", "bob", "carol")
+		alice, bob := users[0], users[1]
+
+		msg, err := st.Messages.Create(alice, bob, "hi @bob", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID == 0 || msg.Sender != fmt.Sprint(alice) || msg.Receiver != fmt.Sprint(bob) || msg.Content != "hi @bob" || msg.Timestamp == "" {
+			t.Errorf("created message = %+v", msg)
+		}
+		if !strings.Contains(msg.ContentHTML, `data-username="bob"`) {
+			t.Errorf("created message = %+v", msg)
+		}
+		reply, _ := st.Messages.Create(bob, alice, "hello", 0)
+		st.Messages.Create(alice, users[2], "elsewhere", 0)
+
+		page, err := st.Messages.Conversation(bob, alice, MessageQuery{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Messages) != 2 || page.Messages[0].ID != reply.ID || page.Messages[1].ID != msg.ID {
+			t.Errorf("Conversation = %+v", page.Messages)
+		}
+	})
+}
+
+// TestMessagePagination pages through a conversation towards older messages
+// and back towards newer ones, for private messages and groups.
+func TestMessagePagination(t *testing.T) {
+	const total = 23
+	sources := []struct {
+		name string
+		// setup stores total messages and returns the function reading
+		// their pages.
+		setup func(t *testing.T, st *Store, users []int) func(MessageQuery) (MessagePage, error)
+	}{
+		{"private", func(t *testing.T, st *Store, users []int) func(MessageQuery) (MessagePage, error) {
+			for i := 0; i < total; i++ {
+				if _, err := st.Messages.Create(users[i%2], users[(i+1)%2], fmt.Sprint(i), 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return func(q MessageQuery) (MessagePage, error) { return st.Messages.Conversation(users[0], users[1], q) }
+		}},
+		{"group", func(t *testing.T, st *Store, users []int) func(MessageQuery) (MessagePage, error) {
+			group, err := st.Conversations.CreateGroup("group", users[0], users[1:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < total; i++ {
+				if _, err := st.Conversations.Post(group, users[i%2], fmt.Sprint(i), 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return func(q MessageQuery) (MessagePage, error) { return st.Conversations.Messages(group, q) }
+		}},
+	}
+	for _, source := range sources {
+		for _, limit := range []int{1, 5, 10, total, total + 1} {
+			t.Run(fmt.Sprintf("%s/%d", source.name, limit), func(t *testing.T) {
+				eachStore(t, func(t *testing.T, st *Store) {
+					read := source.setup(t, st, createUsers(t, st, "alice", "bob"))
+					testPagination(t, read, total, limit)
+				})
+			})
+		}
+	}
+}
+
+func testPagination(t *testing.T, read func(MessageQuery) (MessagePage, error), total int, limit int) {
+	cursor := func(s string) Cursor {
+		c, err := ParseCursor(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// Back from the newest message; the pages come newest first.
+	var older []string
+	q := MessageQuery{Limit: limit}
+	for pages := 0; ; pages++ {
+		if pages > total {
+			t.Fatal("paging back does not end")
+		}
+		page, err := read(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Messages) > limit {
+			t.Fatalf("page of %d messages", len(page.Messages))
+		}
+		if !q.Before.IsZero() && page.After != page.Messages[0].Cursor {
+			t.Errorf("After = %q, want the newest message of the page", page.After)
+		}
+		for _, msg := range page.Messages {
+			older = append(older, msg.Content)
+		}
+		if page.Before == "" {
+			break
+		}
+		q = MessageQuery{Before: cursor(page.Before), Limit: limit}
+	}
+	var want []string
+	for i := total - 1; i >= 0; i-- {
+		want = append(want, fmt.Sprint(i))
+	}
+	if fmt.Sprint(older) != fmt.Sprint(want) {
+		t.Fatalf("paging back read %v", older)
+	}
+
+	// Forward from the oldest message; pages are still newest first.
+	oldest, err := read(MessageQuery{Before: cursor(mustSecondOldest(t, read, total)), Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var newer []string
+	q = MessageQuery{After: cursor(oldest.Messages[0].Cursor), Limit: limit}
+	for pages := 0; ; pages++ {
+		if pages > total {
+			t.Fatal("paging forward does not end")
+		}
+		page, err := read(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Before != page.Messages[len(page.Messages)-1].Cursor {
+			t.Errorf("Before = %q, want the oldest message of the page", page.Before)
+		}
+		for i := len(page.Messages) - 1; i >= 0; i-- {
+			newer = append(newer, page.Messages[i].Content)
+		}
+		if page.After == "" {
+			break
+		}
+		q = MessageQuery{After: cursor(page.After), Limit: limit}
+	}
+	want = nil
+	for i := 1; i < total; i++ {
+		want = append(want, fmt.Sprint(i))
+	}
+	if fmt.Sprint(newer) != fmt.Sprint(want) {
+		t.Fatalf("paging forward read %v", newer)
+	}
+}
+
+// mustSecondOldest returns the cursor of the second oldest of total
+// messages, so that the page before it holds the oldest.
+func mustSecondOldest(t *testing.T, read func(MessageQuery) (MessagePage, error), total int) string {
+	page, err := read(MessageQuery{Limit: total})
+	if err != nil || len(page.Messages) != total {
+		t.Fatalf("read all: %d messages, %v", len(page.Messages), err)
+	}
+	return page.Messages[total-2].Cursor
+}
+
+func TestBadCursor(t *testing.T) {
+	for _, s := range []string{"x", "1", "_1", "1_", "1_x", "x_1"} {
+		if _, err := ParseCursor(s); err != ErrBadCursor {
+			t.Errorf("ParseCursor(%q) = %v", s, err)
+		}
+	}
+	c := Cursor{ID: 7}
+	if parsed, err := ParseCursor(c.String()); err != nil || parsed.ID != 7 {
+		t.Errorf("round trip of %v = %v, %v", c, parsed, err)
+	}
+}
+
+// unreadFrom returns how many messages from username userID has not read,
+// according to Userlist.
+func unreadFrom(t *testing.T, st *Store, userID int, username string) int {
+	list, err := st.Users.Userlist(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range list {
+		if entry.Username == username {
+			return entry.Unread
+		}
+	}
+	t.Fatalf("%s is not in the user list", username)
+	return 0
+}
+
+func TestUnread(t *testing.T) {
+	eachStore(t, func(t *testing.T, st *Store) {
+		users := createUsers(t, st, "alice", "bob", "carol")
+		alice, bob, carol := users[0], users[1], users[2]
+
+		first, _ := st.Messages.Create(alice, bob, "one", 0)
+		second, _ := st.Messages.Create(alice, bob, "two", 0)
+		st.Messages.Create(carol, bob, "elsewhere", 0)
+		st.Messages.Create(bob, alice, "own", 0)
+		if unread := unreadFrom(t, st, bob, "alice"); unread != 2 {
+			t.Errorf("unread before reading = %d", unread)
+		}
+
+		if newest, err := st.Messages.MarkRead(bob, alice, first.ID); err != nil || newest != first.ID {
+			t.Errorf("MarkRead up to the first = %d, %v", newest, err)
+		}
+		if unread := unreadFrom(t, st, bob, "alice"); unread != 1 {
+			t.Errorf("unread after reading one = %d", unread)
+		}
+
+		// Deleted messages cannot be read any more, so they are not unread.
+		if _, err := st.Messages.Delete(second.ID); err != nil {
+			t.Fatal(err)
+		}
+		if unread := unreadFrom(t, st, bob, "alice"); unread != 0 {
+			t.Errorf("unread after deleting the other = %d", unread)
+		}
+
+		third, _ := st.Messages.Create(alice, bob, "three", 0)
+		if newest, err := st.Messages.MarkRead(bob, alice, 0); err != nil || newest != third.ID {
+			t.Errorf("MarkRead of all = %d, %v", newest, err)
+		}
+		if newest, _ := st.Messages.MarkRead(bob, alice, 0); newest != 0 {
+			t.Errorf("second MarkRead = %d", newest)
+		}
+		if unread := unreadFrom(t, st, bob, "carol"); unread != 1 {
+			t.Errorf("reading alice's messages changed carol's: %d unread", unread)
+		}
+	})
+}
+
+func TestMessageEditAndDelete(t *testing.T) {
+	eachStore(t, func(t *testing.T, st *Store) {
+		users := createUsers(t, st, "alice", "bob")
+		alice, bob := users[0], users[1]
+		msg, _ := st.Messages.Create(alice, bob, "hi", 0)
+		reply, _ := st.Messages.Create(bob, alice, "hello", 0)
+
+		edited, err := st.Messages.Edit(msg.ID, "edited")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if edited.Content != "edited" || edited.EditedAt == "" {
+			t.Errorf("edited message = %+v", edited)
+		}
+		if revisions, _ := st.Messages.Revisions(msg.ID); len(revisions) != 1 || revisions[0].Content != "hi" {
+			t.Errorf("Revisions = %+v", revisions)
+		}
+
+		deleted, err := st.Messages.Delete(msg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted.Content != "" || deleted.ContentHTML != "" || deleted.DeletedAt == "" {
+			t.Errorf("deleted message = %+v", deleted)
+		}
+		if _, err := st.Messages.Delete(msg.ID); err != ErrNotFound {
+			t.Errorf("second Delete: %v", err)
+		}
+		if _, err := st.Messages.Edit(msg.ID, "again"); err != ErrNotFound {
+			t.Errorf("Edit of a deleted message: %v", err)
+		}
+		// Moderators still see what deleted messages said.
+		if kept, _ := st.Messages.ByID(msg.ID); kept.Content != "edited" {
+			t.Errorf("ByID of a deleted message = %+v", kept)
+		}
+		page, _ := st.Messages.Conversation(bob, alice, MessageQuery{Limit: 10})
+		if len(page.Messages) != 2 || page.Messages[0].ID != reply.ID || page.Messages[1].Content != "" || page.Messages[1].DeletedAt == "" {
+			t.Errorf("Conversation = %+v", page.Messages)
+		}
+		if _, err := st.Messages.Edit(12345, "missing"); err != ErrNotFound {
+			t.Errorf("Edit of a missing message: %v", err)
+		}
+	})
+}
+
+func TestBlocks(t *testing.T) {
+	eachStore(t, func(t *testing.T, st *Store) {
+		users := createUsers(t, st, "alice", "bob", "carol")
+		alice, bob, carol := users[0], users[1], users[2]
+
+		if err := st.Privacy.Block(alice, bob); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Privacy.Block(alice, bob); err != ErrConflict {
+			t.Errorf("second Block: %v", err)
+		}
+		st.Privacy.Block(carol, bob)
+
+		tests := []struct {
+			blocker, blocked int
+			want             bool
+		}{
+			{alice, bob, true},
+			{bob, alice, false},
+			{carol, bob, true},
+			{alice, carol, false},
+		}
+		for _, tt := range tests {
+			if blocked, err := st.Privacy.IsBlocked(tt.blocker, tt.blocked); err != nil || blocked != tt.want {
+				t.Errorf("IsBlocked(%d, %d) = %v, %v", tt.blocker, tt.blocked, blocked, err)
+			}
+		}
+		if blocked, _ := st.Privacy.Blocked(alice); fmt.Sprint(blocked) != "[bob]" {
+			t.Errorf("Blocked(alice) = %v", blocked)
+		}
+		if blockers, _ := st.Privacy.BlockedBy(bob); fmt.Sprint(blockers) != "[alice carol]" {
+			t.Errorf("BlockedBy(bob) = %v", blockers)
+		}
+
+		// Users who were blocked are left out of the user list of the
+		// blocker only.
+		listed := func(userID int) string {
+			list, err := st.Users.Userlist(userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, entry := range list {
+				names = append(names, entry.Username)
+			}
+			sort.Strings(names)
+			return fmt.Sprint(names)
+		}
+		if got := listed(alice); got != "[alice carol]" {
+			t.Errorf("user list of alice = %s", got)
+		}
+		if got := listed(bob); got != "[alice bob carol]" {
+			t.Errorf("user list of bob = %s", got)
+		}
+
+		if err := st.Privacy.Unblock(alice, bob); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Privacy.Unblock(alice, bob); err != ErrNotFound {
+			t.Errorf("second Unblock: %v", err)
+		}
+		if blocked, _ := st.Privacy.IsBlocked(alice, bob); blocked {
+			t.Error("still blocked after Unblock")
+		}
+		if got := listed(alice); got != "[alice bob carol]" {
+			t.Errorf("user list of alice after Unblock = %s", got)
+		}
+	})
+}
+
+func TestHaveChatted(t *testing.T) {
+	eachStore(t, func(t *testing.T, st *Store) {
+		users := createUsers(t, st, "alice", "bob", "carol")
+		alice, bob, carol := users[0], users[1], users[2]
+		st.Messages.Create(alice, bob, "hi", 0)
+
+		tests := []struct {
+			a, b int
+			want bool
+		}{
+			{alice, bob, true},
+			{bob, alice, true},
+			{alice, carol, false},
+			{carol, bob, false},
+		}
+		for _, tt := range tests {
+			if chatted, err := st.Messages.HaveChatted(tt.a, tt.b); err != nil || chatted != tt.want {
+				t.Errorf("HaveChatted(%d, %d) = %v, %v", tt.a, tt.b, chatted, err)
+			}
+		}
+	})
+}
+
+func TestAttachmentAccess(t *testing.T) {
+	eachStore(t, func(t *testing.T, st *Store) {
+		users := createUsers(t, st, "alice", "bob", "carol", "dave")
+		alice, bob, carol, dave := users[0], users[1], users[2], users[3]
+		attach := func(name string) int {
+			id, err := st.Attachments.Create(Attachment{UploaderID: alice, Name: name, ContentType: "text/plain", Size: 1, StorageKey: name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+
+		unsent := attach("unsent")
+		private := attach("private")
+		st.Messages.Create(alice, bob, "", private)
+		deleted := attach("deleted")
+		msg, _ := st.Messages.Create(alice, bob, "", deleted)
+		st.Messages.Delete(msg.ID)
+		grouped := attach("group")
+		group, _ := st.Conversations.CreateGroup("group", alice, []int{carol})
+		st.Conversations.Post(group, alice, "", grouped)
+		channel := 0
+		conversations, _ := st.Conversations.ForUser(alice)
+		for _, c := range conversations {
+			if c.Kind == ConversationChannel {
+				channel = c.ID
+			}
+		}
+		if channel == 0 {
+			t.Fatal("no channel")
+		}
+		public := attach("channel")
+		st.Conversations.Post(channel, alice, "", public)
+
+		tests := []struct {
+			name       string
+			attachment int
+			user       int
+			want       bool
+		}{
+			{"uploader of an unsent file", unsent, alice, true},
+			{"stranger to an unsent file", unsent, bob, false},
+			{"receiver of a private message", private, bob, true},
+			{"stranger to a private message", private, carol, false},
+			{"uploader of a deleted message", deleted, alice, true},
+			{"receiver of a deleted message", deleted, bob, false},
+			{"group member", grouped, carol, true},
+			{"stranger to a group", grouped, bob, false},
+			{"anyone in a channel", public, dave, true},
+			{"missing attachment", 12345, alice, false},
+		}
+		for _, tt := range tests {
+			if access, err := st.Attachments.CanAccess(tt.attachment, tt.user); err != nil || access != tt.want {
+				t.Errorf("%s: CanAccess = %v, %v", tt.name, access, err)
+			}
+		}
+	})
+}
+
+func TestMessageDelivery(t *testing.T) {
+	eachStore(t, func(t *testing.T, st *Store) {
+		users := createUsers(t, st, "alice", "bob")
+		alice, bob := users[0], users[1]
+
+		msg, err := st.Messages.Create(alice, bob, "hi", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.SenderID != alice || msg.ReceiverID != bob || msg.Sender != fmt.Sprint(alice) || msg.Receiver != fmt.Sprint(bob) {
+			t.Errorf("sender and receiver of %+v", msg)
+		}
+		if msg.Status != MessagePending || msg.Cursor == "" {
+			t.Errorf("created message = %+v", msg)
+		}
+		second, _ := st.Messages.Create(alice, bob, "again", 0)
+		deleted, _ := st.Messages.Create(alice, bob, "oops", 0)
+		st.Messages.Delete(deleted.ID)
+		st.Messages.Create(bob, alice, "to alice", 0)
+
+		if pending, _ := st.Messages.Pending(bob, 10); len(pending) != 2 || pending[0].ID != msg.ID || pending[1].ID != second.ID {
+			t.Errorf("Pending = %+v", pending)
+		}
+		if pending, _ := st.Messages.Pending(bob, 1); len(pending) != 1 || pending[0].ID != msg.ID {
+			t.Errorf("Pending with limit 1 = %+v", pending)
+		}
+		if sender, err := st.Messages.MarkDelivered(bob, msg.ID); err != nil || sender != alice {
+			t.Errorf("MarkDelivered = %d, %v", sender, err)
+		}
+		if sender, err := st.Messages.MarkDelivered(bob, msg.ID); err != nil || sender != 0 {
+			t.Errorf("second MarkDelivered = %d, %v", sender, err)
+		}
+		// Only the receiver delivers.
+		if sender, _ := st.Messages.MarkDelivered(alice, second.ID); sender != 0 {
+			t.Errorf("MarkDelivered by the sender = %d", sender)
+		}
+		if pending, _ := st.Messages.Pending(bob, 10); len(pending) != 1 || pending[0].ID != second.ID {
+			t.Errorf("Pending after delivery = %+v", pending)
+		}
+		if delivered, _ := st.Messages.ByID(msg.ID); delivered.Status != MessageDelivered || delivered.DeliveredAt == "" {
+			t.Errorf("delivered message = %+v", delivered)
+		}
+	})
+}
+
+func TestSearch(t *testing.T) {
+	eachStore(t, func(t *testing.T, st *Store) {
+		users := createUsers(t, st, "alice", "bob", "carol")
+		alice, bob, carol := users[0], users[1], users[2]
+
+		msg, _ := st.Messages.Create(alice, bob, "the secret is <script>alert(1)</script>", 0)
+		deleted, _ := st.Messages.Create(bob, alice, "another secret", 0)
+		st.Messages.Delete(deleted.ID)
+		st.Messages.Create(carol, bob, "nothing to see", 0)
+		postID, _ := st.Posts.Create(carol, "A secret recipe", "<b>flour</b> & water", []int{1})
+		commentID, _ := st.Comments.Create(postID, 0, bob, "is it a secret?")
+
+		search := func(userID int, text string, kinds ...string) []SearchResult {
+			page, err := st.Search.Search(SearchQuery{Text: text, Kinds: kinds, UserID: userID, Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return page.Results
+		}
+		results := func(found []SearchResult) string {
+			var ids []string
+			for _, r := range found {
+				ids = append(ids, fmt.Sprintf("%s %d", r.Kind, r.ID))
+			}
+			sort.Strings(ids)
+			return strings.Join(ids, ", ")
+		}
+
+		// Private messages are found by their two users only, and deleted
+		// ones not at all.
+		tests := []struct {
+			name   string
+			userID int
+			want   string
+		}{
+			{"sender", alice, fmt.Sprintf("message %d", msg.ID)},
+			{"receiver", bob, fmt.Sprintf("message %d", msg.ID)},
+			{"someone else", carol, ""},
+			{"guest", 0, ""},
+		}
+		for _, tt := range tests {
+			if got := results(search(tt.userID, "secret", SearchMessage)); got != tt.want {
+				t.Errorf("%s: found %q, want %q", tt.name, got, tt.want)
+			}
+		}
+		if found := search(bob, "secret", SearchMessage); len(found) == 1 && (found[0].Author != "alice" || found[0].With != "alice") {
+			t.Errorf("message found by bob = %+v", found[0])
+		}
+		if found := search(alice, "secret", SearchMessage); len(found) == 1 && found[0].With != "bob" {
+			t.Errorf("message found by alice = %+v", found[0])
+		}
+
+		want := fmt.Sprintf("comment %d, message %d, post %d", commentID, msg.ID, postID)
+		if got := results(search(alice, "secret")); got != want {
+			t.Errorf("all kinds: found %q, want %q", got, want)
+		}
+		if got := results(search(carol, "secret")); got != fmt.Sprintf("comment %d, post %d", commentID, postID) {
+			t.Errorf("all kinds for carol: found %q", got)
+		}
+
+		// Snippets are escaped before the matches are marked.
+		snippets := []struct {
+			text string
+			kind string
+			want []string
+		}{
+			{"script", SearchMessage, []string{"&lt;<mark>script</mark>&gt;alert(1)&lt;/<mark>script</mark>&gt;"}},
+			{"alert", SearchMessage, []string{"&lt;script&gt;<mark>alert</mark>(1)"}},
+			{"flour", SearchPost, []string{"&lt;b&gt;<mark>flour</mark>&lt;/b&gt; &amp; water"}},
+		}
+		for _, s := range snippets {
+			found := search(alice, s.text, s.kind)
+			if len(found) != 1 {
+				t.Errorf("%s: found %+v", s.text, found)
+				continue
+			}
+			snippet := found[0].Snippet
+			if strings.Contains(snippet, "<script") || strings.Contains(snippet, "<b>") {
+				t.Errorf("%s: unescaped snippet %q", s.text, snippet)
+			}
+			for _, part := range s.want {
+				if !strings.Contains(snippet, part) {
+					t.Errorf("%s: snippet %q lacks %q", s.text, snippet, part)
+				}
+			}
+		}
+	})
+}
+
+// TestCommentDelete deletes comments of the thread a <- b <- c, where each
+// replies to the one before, and checks what is left of it.
+func TestCommentDelete(t *testing.T) {
+	tests := []struct {
+		name    string
+		deletes string
+		want    string
+	}{
+		{"reply", "c", "a b"},
+		{"comment with replies", "a", "[deleted] b c"},
+		{"placeholder loses its last reply", "bc", "a"},
+		{"chain of placeholders", "abc", ""},
+		{"reply of a reply", "ac", "[deleted] b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eachStore(t, func(t *testing.T, st *Store) {
+				users := createUsers(t, st, "alice")
+				postID, _ := st.Posts.Create(users[0], "Post", "Post", nil)
+				ids := map[rune]int{}
+				parent := 0
+				for _, name := range "abc" {
+					id, err := st.Comments.Create(postID, parent, users[0], string(name))
+					if err != nil {
+						t.Fatal(err)
+					}
+					ids[name], parent = id, id
+				}
+
+				for _, name := range tt.deletes {
+					if err := st.Comments.Delete(ids[name]); err != nil {
+						t.Fatalf("delete %c: %v", name, err)
+					}
+				}
+
+				comments, err := st.Comments.All()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var left []string
+				for _, comment := range comments {
+					left = append(left, comment.Content)
+					if comment.Deleted && (comment.Username != "" || comment.ContentHTML != "") {
+						t.Errorf("placeholder keeps its author or content: %+v", comment)
+					}
+				}
+				if got := strings.Join(left, " "); got != tt.want {
+					t.Errorf("left %q, want %q", got, tt.want)
+				}
+				if count, _ := st.Comments.CountForPost(postID); count != len(left)-strings.Count(tt.want, DeletedComment) {
+					t.Errorf("CountForPost = %d", count)
+				}
+			})
+		})
+	}
+}
+
+func TestCommentEdit(t *testing.T) {
+	eachStore(t, func(t *testing.T, st *Store) {
+		users := createUsers(t, st, "alice")
+		postID, _ := st.Posts.Create(users[0], "Post", "Post", nil)
+		parent, _ := st.Comments.Create(postID, 0, users[0], "parent")
+		reply, _ := st.Comments.Create(postID, parent, users[0], "reply")
+
+		if err := st.Comments.Edit(reply, users[0], "`edited`"); err != nil {
+			t.Fatal(err)
+		}
+		comment, err := st.Comments.ByID(reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if comment.ParentID != parent || comment.Content != "`edited`" || comment.ContentHTML != "<p><code>edited</code></p>\n" || comment.EditedAt == nil {
+			t.Errorf("edited comment = %+v", comment)
+		}
+		if revisions, _ := st.Comments.Revisions(reply); len(revisions) != 1 || revisions[0].Content != "reply" || revisions[0].Editor != "alice" {
+			t.Errorf("Revisions = %+v", revisions)
+		}
+
+		// Placeholders cannot be edited or deleted again.
+		if err := st.Comments.Delete(parent); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Comments.Edit(parent, users[0], "back"); err != ErrNotFound {
+			t.Errorf("Edit of a placeholder: %v", err)
+		}
+		if err := st.Comments.Delete(parent); err != ErrNotFound {
+			t.Errorf("Delete of a placeholder: %v", err)
+		}
+		if _, err := st.Comments.ByID(reply + 1); err != ErrNotFound {
+			t.Errorf("ByID of a missing comment: %v", err)
+		}
+	})
+}
+
+// TestRenderContent checks that RenderContent fills the content_html left
+// NULL by the migration, for rows written before it.
+func TestRenderContent(t *testing.T) {
+	st := newTestSQLite(t)
+	db := st.Posts.(*sqlitePosts).db
+	users := createUsers(t, st, "alice", "bob")
+	postID, _ := st.Posts.Create(users[0], "Post", "hi @bob", nil)
+	st.Comments.Create(postID, 0, users[1], "**reply**")
+	st.Messages.Create(users[0], users[1], "hi", 0)
+	groupID, _ := st.Conversations.CreateGroup("Group", users[0], []int{users[1]})
+	st.Conversations.Post(groupID, users[0], "hello", 0)
+	for _, table := range renderedTables {
+		if _, err := db.Exec("UPDATE " + table + " SET content_html = NULL;"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if post, _ := st.Posts.ByID(postID); !strings.Contains(post.ContentHTML, `data-username="bob"`) {
+		t.Errorf("legacy post read as %q", post.ContentHTML)
+	}
+	filled, err := RenderContent(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filled != len(renderedTables) {
+		t.Errorf("filled %d rows, want %d", filled, len(renderedTables))
+	}
+	for _, table := range renderedTables {
+		var missing int
+		db.QueryRow("SELECT COUNT(*) FROM " + table + " WHERE content_html IS NULL;").Scan(&missing)
+		if missing != 0 {
+			t.Errorf("%d rows of %s left without HTML", missing, table)
+		}
+	}
+	var html string
+	db.QueryRow("SELECT content_html FROM comments;").Scan(&html)
+	if html != "<p><strong>reply</strong></p>\n" {
+		t.Errorf("stored comment HTML %q", html)
+	}
+	if filled, _ := RenderContent(db); filled != 0 {
+		t.Errorf("second run filled %d rows", filled)
+	}
+}